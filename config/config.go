@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -21,9 +22,20 @@ type LDAPConfig struct {
 }
 
 type KubeConfig struct {
-	Clientset *kubernetes.Clientset
-	Namespace string
-	JobsTTL   int
+	Clientset     kubernetes.Interface
+	DynamicClient dynamic.Interface
+	Namespace     string
+	JobsTTL       int
+	InstallCRDs   bool
+}
+
+type VaultConfig struct {
+	Address    string
+	Token      string
+	AuthMethod string
+	AuthMount  string
+	Role       string
+	Namespace  string
 }
 
 type JWTConfig struct {
@@ -40,6 +52,26 @@ type DBConfig struct {
 	Port     int
 }
 
+type EncryptionConfig struct {
+	Keys       string // version:base64 key pairs, comma separated
+	KeysSecret string // Kubernetes Secret holding a key per version
+	ActiveKey  string // version used to encrypt, the latest by default
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+type SNMPConfig struct {
+	TrapAddr    string // UDP address traps are received on, disabled when empty
+	Communities string // SNMPv1 and v2c communities accepted, comma separated, none when empty
+	V3Users     string // SNMPv3 users as user:auth protocol:auth passphrase:privacy protocol:privacy passphrase
+}
+
 type Config struct {
 	Environment string
 	RootSecret  string
@@ -48,16 +80,54 @@ type Config struct {
 	Kube        KubeConfig
 	JWT         JWTConfig
 	DB          DBConfig
+	Vault       VaultConfig
+	Encryption  EncryptionConfig
+	SMTP        SMTPConfig
+	SNMP        SNMPConfig
 	DebugMode   bool
+	Operator    bool
+	// Minutes between syncs of runners with autoSync enabled, 0 disables it
+	RunnerSyncInterval int
+	// Seconds between checks for scheduled jobs due to run
+	SchedulerInterval int
+	// Seconds a signed webhook timestamp is accepted for, it can't be disabled
+	WebhookTimestampTolerance int
+	// Seconds delivered webhook messages are remembered to reject replayed requests, at least the timestamp tolerance
+	WebhookReplayWindow int
+	// Seconds between checks for notifications to deliver and jobs to notify about
+	NotificationInterval int
+	// Number of attempts to deliver a notification before giving up
+	NotificationMaxAttempts int
+	// Hosts notifications are posted to whatever their address, comma separated, *.example.com matches subdomains.
+	// Any host with a public address is allowed when empty.
+	NotificationAllowedHosts string
+	// URL Kriten is reached at, used for links in notifications
+	ExternalURL string
+	// Seconds between syncs of the NATS, Kafka and Kubernetes trigger consumers with their triggers, 0 disables them
+	TriggerSyncInterval int
+	// Address syslog messages are received on, over both UDP and TCP, disabled when empty
+	SyslogAddr string
 }
 
 // NewConfig returns a new Config struct.
 func NewConfig(gitBranch string) Config {
 	return Config{
-		Environment: getEnv("ENV", "development"),
-		RootSecret:  getEnv("ROOT_SECRET", "kriten-root"),
-		APISecret:   getEnv("API_SECRET_KEY", "api-secret"),
-		DebugMode:   getEnvAsBool("DEBUG_MODE", true),
+		Environment:        getEnv("ENV", "development"),
+		RootSecret:         getEnv("ROOT_SECRET", "kriten-root"),
+		APISecret:          getEnv("API_SECRET_KEY", "api-secret"),
+		DebugMode:          getEnvAsBool("DEBUG_MODE", true),
+		Operator:           getEnvAsBool("OPERATOR_MODE", false),
+		RunnerSyncInterval: getEnvAsInt("RUNNER_SYNC_INTERVAL", 0),
+		SchedulerInterval:  getEnvAsInt("SCHEDULER_INTERVAL", 15),
+
+		WebhookTimestampTolerance: getEnvAsInt("WEBHOOK_TIMESTAMP_TOLERANCE", 300),
+		WebhookReplayWindow:       getEnvAsInt("WEBHOOK_REPLAY_WINDOW", 86400),
+		NotificationInterval:      getEnvAsInt("NOTIFICATION_INTERVAL", 10),
+		NotificationMaxAttempts:   getEnvAsInt("NOTIFICATION_MAX_ATTEMPTS", 8),
+		NotificationAllowedHosts:  getEnv("NOTIFICATION_ALLOWED_HOSTS", ""),
+		ExternalURL:               getEnv("EXTERNAL_URL", ""),
+		TriggerSyncInterval:       getEnvAsInt("TRIGGER_SYNC_INTERVAL", 30),
+		SyslogAddr:                getEnv("SYSLOG_ADDR", ""),
 		LDAP: LDAPConfig{
 			BindUser: getEnv("LDAP_BIND_USER", ""),
 			BindPass: getEnv("LDAP_BIND_PASS", ""),
@@ -66,9 +136,10 @@ func NewConfig(gitBranch string) Config {
 			BaseDN:   getEnv("LDAP_BASE_DN", ""),
 		},
 		Kube: KubeConfig{
-			Clientset: nil,
-			Namespace: getEnv("NAMESPACE", "kriten"),
-			JobsTTL:   getEnvAsInt("JOBS_TTL", JobsTTLDefault), // Default 1 hour
+			Clientset:   nil,
+			Namespace:   getEnv("NAMESPACE", "kriten"),
+			JobsTTL:     getEnvAsInt("JOBS_TTL", JobsTTLDefault), // Default 1 hour
+			InstallCRDs: getEnvAsBool("INSTALL_CRDS", true),
 		},
 		JWT: JWTConfig{
 			Key:           []byte(getEnv("JWT_KEY", "")),
@@ -82,6 +153,31 @@ func NewConfig(gitBranch string) Config {
 			Port:     getEnvAsInt("DB_PORT", -1),
 			SSL:      getEnv("DB_SSL", "disabled"),
 		},
+		Encryption: EncryptionConfig{
+			Keys:       getEnv("ENCRYPTION_KEYS", ""),
+			KeysSecret: getEnv("ENCRYPTION_KEYS_SECRET", ""),
+			ActiveKey:  getEnv("ENCRYPTION_ACTIVE_KEY", ""),
+		},
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnvAsInt("SMTP_PORT", 587),
+			User:     getEnv("SMTP_USER", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "kriten@localhost"),
+		},
+		SNMP: SNMPConfig{
+			TrapAddr:    getEnv("SNMP_TRAP_ADDR", ""),
+			Communities: getEnv("SNMP_COMMUNITIES", ""),
+			V3Users:     getEnv("SNMP_V3_USERS", ""),
+		},
+		Vault: VaultConfig{
+			Address:    getEnv("VAULT_ADDR", ""),
+			Token:      getEnv("VAULT_TOKEN", ""),
+			AuthMethod: getEnv("VAULT_AUTH_METHOD", "token"), // "token" or "kubernetes"
+			AuthMount:  getEnv("VAULT_AUTH_MOUNT", "kubernetes"),
+			Role:       getEnv("VAULT_ROLE", ""),
+			Namespace:  getEnv("VAULT_NAMESPACE", ""),
+		},
 		// ElasticSearch: ESConfig{
 		// 	CloudID: getEnv("ES_CLOUD_ID", ""),
 		// 	APIKey:  getEnv("ES_API_KEY", ""),
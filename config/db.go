@@ -18,6 +18,20 @@ func InitDB(db *gorm.DB) {
 		&models.User{},
 		&models.ApiToken{},
 		&models.Webhook{},
+		&models.Migration{},
+		&models.TaskRevision{},
+		&models.MaintenanceWindow{},
+		&models.ScheduledJob{},
+		&models.WebhookMessage{},
+		&models.WebhookDelivery{},
+		&models.Subscription{},
+		&models.NotificationDelivery{},
+		&models.NotificationChannel{},
+		&models.ChannelMessage{},
+		&models.Trigger{},
+		&models.TriggerEvent{},
+		&models.EventRule{},
+		&models.EventRuleRun{},
 	)
 	if err != nil {
 		log.Println("Error during Postgres AutoMigrate")
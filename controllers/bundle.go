@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// Passphrase used to encrypt or decrypt the secrets of a bundle
+const passphraseHeader = "X-Kriten-Passphrase"
+
+type BundleController struct {
+	BundleService services.BundleService
+	AuthService   services.AuthService
+	AuditService  services.AuditService
+	AuditCategory string
+}
+
+func NewBundleController(bs services.BundleService, as services.AuthService, als services.AuditService) BundleController {
+	return BundleController{
+		BundleService: bs,
+		AuthService:   as,
+		AuditService:  als,
+		AuditCategory: "bundle",
+	}
+}
+
+func (bc *BundleController) SetBundleRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(bc.AuthService, config.JWT))
+
+	// Bundles contain every resource, only admins can access them
+	r.Use(middlewares.AuthorizationMiddleware(bc.AuthService, "*", "write"))
+	{
+		r.GET("/export", bc.Export)
+		r.POST("/import", bc.Import)
+	}
+}
+
+// Export godoc
+//
+//	@Summary		Export configuration
+//	@Description	Export runners, tasks, cronjobs, groups, roles, role bindings and webhooks as a bundle.
+//	@Description	Secrets are included, encrypted, only when a passphrase is provided.
+//	@Tags			bundle
+//	@Accept			json
+//	@Produce		json,application/yaml
+//	@Param			format				query		string	false	"Bundle format: json (default) or yaml"
+//	@Param			X-Kriten-Passphrase	header		string	false	"Passphrase used to encrypt secrets"
+//	@Success		200					{object}	models.Bundle
+//	@Failure		400					{object}	helpers.HTTPError
+//	@Failure		500					{object}	helpers.HTTPError
+//	@Router			/export [get]
+//	@Security		Bearer
+func (bc *BundleController) Export(ctx *gin.Context) {
+	audit := bc.AuditService.InitialiseAuditLog(ctx, "export", bc.AuditCategory, "*")
+	format := ctx.DefaultQuery("format", "json")
+	if format != "json" && format != "yaml" {
+		bc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid format, allowed: json, yaml"})
+		return
+	}
+
+	bundle, err := bc.BundleService.Export(ctx.GetHeader(passphraseHeader))
+	if err != nil {
+		bc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == "yaml" {
+		// gin's YAML renderer ignores json tags, keeping the same field names as JSON
+		data, err := yaml.Marshal(bundle)
+		if err != nil {
+			bc.AuditService.CreateAudit(audit)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		audit.Status = "success"
+		bc.AuditService.CreateAudit(audit)
+		ctx.Data(http.StatusOK, "application/yaml", data)
+		return
+	}
+
+	audit.Status = "success"
+	bc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, bundle)
+}
+
+// Import godoc
+//
+//	@Summary		Import configuration
+//	@Description	Import a bundle, in YAML or JSON. Resources are applied in dependency order.
+//	@Description	Modes: create only adds missing resources, overwrite also updates existing ones,
+//	@Description	prune also deletes resources not in the bundle.
+//	@Tags			bundle
+//	@Accept			json,application/yaml
+//	@Produce		json
+//	@Param			bundle				body		models.Bundle	true	"Bundle"
+//	@Param			mode				query		string			false	"Import mode: create (default), overwrite or prune"
+//	@Param			dry_run				query		bool			false	"Only return the changes"
+//	@Param			X-Kriten-Passphrase	header		string			false	"Passphrase used to decrypt secrets"
+//	@Success		200					{object}	models.BundleImportResult
+//	@Failure		400					{object}	helpers.HTTPError
+//	@Failure		500					{object}	helpers.HTTPError
+//	@Router			/import [post]
+//	@Security		Bearer
+func (bc *BundleController) Import(ctx *gin.Context) {
+	audit := bc.AuditService.InitialiseAuditLog(ctx, "import", bc.AuditCategory, "*")
+	var bundle models.Bundle
+	var err error
+
+	mode := ctx.DefaultQuery("mode", services.ImportModeCreate)
+	dryRun := false
+	if param := ctx.Query("dry_run"); param != "" {
+		dryRun, err = strconv.ParseBool(param)
+		if err != nil {
+			bc.AuditService.CreateAudit(audit)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		bc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// YAML is a superset of JSON, both formats are accepted
+	err = yaml.Unmarshal(body, &bundle)
+	if err != nil {
+		bc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := bc.BundleService.Import(bundle, mode, ctx.GetHeader(passphraseHeader), dryRun)
+	if err != nil {
+		bc.AuditService.CreateAudit(audit)
+		if result.Changes == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "changes": result.Changes})
+		return
+	}
+
+	audit.Status = "success"
+	bc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, result)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/kriten-io/kriten/services"
 
 	"github.com/gin-gonic/gin"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 type CronJobController struct {
@@ -39,6 +40,7 @@ func (jc *CronJobController) SetCronJobRoutes(rg *gin.RouterGroup, config config
 	r.GET("", middlewares.SetAuthorizationListMiddleware(jc.AuthService, "cronjobs"), jc.ListCronJobs)
 	r.GET("/:id", middlewares.AuthorizationMiddleware(jc.AuthService, "cronjobs", "read"), jc.GetCronJob)
 	r.GET("/:id/schema", middlewares.AuthorizationMiddleware(jc.AuthService, "cronjobs", "read"), jc.GetSchema)
+	r.GET("/:id/jobs", middlewares.AuthorizationMiddleware(jc.AuthService, "cronjobs", "read"), jc.ListCronJobJobs)
 
 	r.Use(middlewares.AuthorizationMiddleware(jc.AuthService, "cronjobs", "write"))
 	{
@@ -47,6 +49,7 @@ func (jc *CronJobController) SetCronJobRoutes(rg *gin.RouterGroup, config config
 		r.PATCH("/:id", jc.UpdateCronJob)
 		r.PUT("/:id", jc.UpdateCronJob)
 		r.DELETE("/:id", jc.DeleteCronJob)
+		r.POST("/:id/run", jc.RunCronJob)
 	}
 
 }
@@ -111,6 +114,42 @@ func (jc *CronJobController) GetCronJob(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, job)
 }
 
+// ListCronJobJobs godoc
+//
+//	@Summary		List CronJob executions
+//	@Description	List the jobs spawned by a CronJob, the most recent first
+//	@Tags			cronjobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"CronJob  id"
+//	@Success		200	{array}		models.Job
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/cronjobs/{id}/jobs [get]
+//	@Security		Bearer
+func (jc *CronJobController) ListCronJobJobs(ctx *gin.Context) {
+	jobsList, err := jc.CronJobService.ListCronJobJobs(ctx.Param("id"))
+
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(jobsList)))
+	if len(jobsList) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, jobsList)
+}
+
 // CreateCronJob godoc
 //
 //	@Summary		Create a new job
@@ -227,6 +266,50 @@ func (jc *CronJobController) DeleteCronJob(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"msg": "cronjob deleted successfully"})
 }
 
+// RunCronJob godoc
+//
+//	@Summary		Run a CronJob
+//	@Description	Create a job from the CronJob template straight away
+//	@Tags			cronjobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"CronJob ID"
+//	@Param			override	query		bool	false	"Run during maintenance windows, admins only"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		423			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/cronjobs/{id}/run [post]
+//	@Security		Bearer
+func (jc *CronJobController) RunCronJob(ctx *gin.Context) {
+	cronjobID := ctx.Param("id")
+	audit := jc.AuditService.InitialiseAuditLog(ctx, "run", jc.AuditCategory, cronjobID)
+
+	override, err := maintenanceOverride(ctx, jc.AuthService)
+	if err != nil {
+		jc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := jc.CronJobService.RunCronJob(cronjobID, override)
+	if err != nil {
+		jc.AuditService.CreateAudit(audit)
+		if kerrors.IsNotFound(err) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(maintenanceStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	jc.AuditService.CreateAudit(audit)
+	auditOverride(ctx, jc.AuditService, jc.AuditCategory, job)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "job created successfully", "id": job.ID})
+}
+
 // GetSchema godoc
 //
 //	@Summary		Get schema
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EncryptionController struct {
+	EncryptionService services.EncryptionService
+	AuthService       services.AuthService
+	AuditService      services.AuditService
+	AuditCategory     string
+}
+
+func NewEncryptionController(
+	es services.EncryptionService,
+	as services.AuthService,
+	als services.AuditService,
+) EncryptionController {
+	return EncryptionController{
+		EncryptionService: es,
+		AuthService:       as,
+		AuditService:      als,
+		AuditCategory:     "encryption",
+	}
+}
+
+func (ec *EncryptionController) SetEncryptionRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(ec.AuthService, config.JWT))
+
+	// Encryption covers every resource, only admins can access it
+	r.Use(middlewares.AuthorizationMiddleware(ec.AuthService, "*", "write"))
+	{
+		r.GET("", ec.GetEncryptionStatus)
+		r.POST("/reencrypt", ec.Reencrypt)
+	}
+}
+
+// GetEncryptionStatus godoc
+//
+//	@Summary		Get encryption status
+//	@Description	Get the active key and the number of values of sensitive columns by key version
+//	@Tags			encryption
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	models.EncryptionStatus
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/encryption [get]
+//	@Security		Bearer
+func (ec *EncryptionController) GetEncryptionStatus(ctx *gin.Context) {
+	status, err := ec.EncryptionService.GetEncryptionStatus()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// Reencrypt godoc
+//
+//	@Summary		Re-encrypt sensitive columns
+//	@Description	Encrypt with the active key every value encrypted with an older key or stored in plain text.
+//	@Description	It runs while Kriten is serving requests, old keys can be removed once it completes.
+//	@Tags			encryption
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	models.EncryptionStatus
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/encryption/reencrypt [post]
+//	@Security		Bearer
+func (ec *EncryptionController) Reencrypt(ctx *gin.Context) {
+	audit := ec.AuditService.InitialiseAuditLog(ctx, "reencrypt", ec.AuditCategory, "*")
+
+	status, err := ec.EncryptionService.Reencrypt()
+	if err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	ec.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, status)
+}
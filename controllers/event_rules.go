@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type EventRuleController struct {
+	EventRuleService services.EventRuleService
+	AuthService      services.AuthService
+	AuditService     services.AuditService
+	AuditCategory    string
+}
+
+func NewEventRuleController(
+	ers services.EventRuleService,
+	as services.AuthService,
+	als services.AuditService,
+) EventRuleController {
+	return EventRuleController{
+		EventRuleService: ers,
+		AuthService:      as,
+		AuditService:     als,
+		AuditCategory:    "event_rules",
+	}
+}
+
+func (ec *EventRuleController) SetEventRuleRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(ec.AuthService, config.JWT))
+
+	r.GET("", middlewares.SetAuthorizationListMiddleware(ec.AuthService, "event_rules"), ec.ListEventRules)
+	r.GET("/:id", middlewares.AuthorizationMiddleware(ec.AuthService, "event_rules", "read"), ec.GetEventRule)
+	r.GET("/:id/runs", middlewares.AuthorizationMiddleware(ec.AuthService, "event_rules", "read"),
+		ec.ListEventRuleRuns)
+
+	r.Use(middlewares.AuthorizationMiddleware(ec.AuthService, "event_rules", "write"))
+	{
+		r.POST("", ec.CreateEventRule)
+		r.PUT("", ec.CreateEventRule)
+		r.PATCH("/:id", ec.UpdateEventRule)
+		r.PUT("/:id", ec.UpdateEventRule)
+		r.DELETE("/:id", ec.DeleteEventRule)
+	}
+}
+
+// ListEventRules godoc
+//
+//	@Summary		List all event rules
+//	@Description	List all rules running tasks on syslog messages and SNMP traps
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		models.EventRule
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/event_rules [get]
+//	@Security		Bearer
+func (ec *EventRuleController) ListEventRules(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+	rules, err := ec.EventRuleService.ListEventRules(authList)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(rules)))
+	if len(rules) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, rules)
+}
+
+// GetEventRule godoc
+//
+//	@Summary		Get an event rule
+//	@Description	Get information about a specific event rule
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Event rule name"
+//	@Success		200	{object}	models.EventRule
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/event_rules/{id} [get]
+//	@Security		Bearer
+func (ec *EventRuleController) GetEventRule(ctx *gin.Context) {
+	rule, err := ec.EventRuleService.GetEventRule(ctx.Param("id"))
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// ListEventRuleRuns godoc
+//
+//	@Summary		List runs of an event rule
+//	@Description	List the jobs started by an event rule with the events they suppressed, the latest first
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Event rule name"
+//	@Success		200	{array}		models.EventRuleRun
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/event_rules/{id}/runs [get]
+//	@Security		Bearer
+func (ec *EventRuleController) ListEventRuleRuns(ctx *gin.Context) {
+	runs, err := ec.EventRuleService.ListEventRuleRuns(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(runs)))
+	if len(runs) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, runs)
+}
+
+// CreateEventRule godoc
+//
+//	@Summary		Create an event rule
+//	@Description	Run a task on the syslog messages or SNMP traps received by Kriten matching the rule, as the user creating it.
+//	@Description	Events with the same dedup key start a single job per dedup window, and at most rate_limit jobs start per rate window.
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Param			rule	body		models.EventRule	true	"New event rule"
+//	@Success		200		{object}	models.EventRule
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/event_rules [post]
+//	@Security		Bearer
+func (ec *EventRuleController) CreateEventRule(ctx *gin.Context) {
+	userid := ctx.MustGet("userID").(uuid.UUID)
+	audit := ec.AuditService.InitialiseAuditLog(ctx, "create", ec.AuditCategory, "*")
+	var rule models.EventRule
+
+	if err := ctx.ShouldBindJSON(&rule); err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.EventTarget = rule.Name
+	rule.ID = uuid.Nil
+	rule.Owner = userid
+
+	rule, err := ec.EventRuleService.CreateEventRule(rule)
+	if err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	ec.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// UpdateEventRule godoc
+//
+//	@Summary		Update an event rule
+//	@Description	Replace an event rule, the owner is kept
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"Event rule name"
+//	@Param			rule	body		models.EventRule	true	"Update event rule"
+//	@Success		200		{object}	models.EventRule
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/event_rules/{id} [patch]
+//	@Security		Bearer
+func (ec *EventRuleController) UpdateEventRule(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := ec.AuditService.InitialiseAuditLog(ctx, "update", ec.AuditCategory, name)
+	var rule models.EventRule
+
+	if err := ctx.ShouldBindJSON(&rule); err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.Name = name
+
+	rule, err := ec.EventRuleService.UpdateEventRule(rule)
+	if err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	ec.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// DeleteEventRule godoc
+//
+//	@Summary		Delete an event rule
+//	@Description	Delete by event rule name, with its runs
+//	@Tags			event_rules
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Event rule name"
+//	@Success		204	{object}	models.EventRule
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/event_rules/{id} [delete]
+//	@Security		Bearer
+func (ec *EventRuleController) DeleteEventRule(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := ec.AuditService.InitialiseAuditLog(ctx, "delete", ec.AuditCategory, name)
+
+	err := ec.EventRuleService.DeleteEventRule(name)
+	if err != nil {
+		ec.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	ec.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "event rule deleted successfully"})
+}
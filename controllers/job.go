@@ -1,30 +1,46 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
 	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
 	"github.com/kriten-io/kriten/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	uuid "github.com/satori/go.uuid"
 )
 
 type JobController struct {
-	JobService    services.JobService
-	AuthService   services.AuthService
-	AuditService  services.AuditService
-	AuditCategory string
+	JobService          services.JobService
+	ScheduledJobService services.ScheduledJobService
+	AuthService         services.AuthService
+	AuditService        services.AuditService
+	AuditCategory       string
 }
 
-func NewJobController(js services.JobService, as services.AuthService, als services.AuditService) JobController {
+func NewJobController(
+	js services.JobService,
+	sjs services.ScheduledJobService,
+	as services.AuthService,
+	als services.AuditService,
+) JobController {
 	return JobController{
-		JobService:    js,
-		AuthService:   as,
-		AuditService:  als,
-		AuditCategory: "jobs",
+		JobService:          js,
+		ScheduledJobService: sjs,
+		AuthService:         as,
+		AuditService:        als,
+		AuditCategory:       "jobs",
 	}
 }
 
@@ -45,6 +61,14 @@ func (jc *JobController) SetJobRoutes(rg *gin.RouterGroup, config config.Config)
 
 }
 
+func (jc *JobController) SetOpenAPIRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(jc.AuthService, config.JWT))
+
+	// Only tasks the user can run as jobs are included
+	r.GET("", middlewares.SetAuthorizationListMiddleware(jc.AuthService, "jobs"), jc.GetOpenAPI)
+}
+
 // ListJobs godoc
 //
 //	@Summary		List all jobs
@@ -138,13 +162,16 @@ func (jc *JobController) GetJobLog(ctx *gin.Context) {
 //	@Summary		Create a new job
 //	@Description	Add a job to the cluster
 //	@Tags			jobs
-//	@Accept			json
+//	@Accept			json,x-www-form-urlencoded
 //	@Produce		json
 //	@Param			id	path		string	true	"Task  name"
-//	@Param			evars	body		object	false	"Extra vars"
+//	@Param			evars	body		object	false	"Extra vars, also accepted as form or query string parameters"
+//	@Param			override	query	bool	false	"Run during maintenance windows, admins only"
+//	@Param			run_at		query	string	false	"Run once at the given RFC 3339 time instead of now"
 //	@Success		200		{object}	models.Task
 //	@Failure		400		{object}	helpers.HTTPError
 //	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		423		{object}	helpers.HTTPError
 //	@Failure		500		{object}	helpers.HTTPError
 //	@Router			/jobs/{id} [post]
 //	@Security		Bearer
@@ -153,7 +180,14 @@ func (jc *JobController) CreateJob(ctx *gin.Context) {
 	audit := jc.AuditService.InitialiseAuditLog(ctx, "create", jc.AuditCategory, taskID)
 	username := ctx.MustGet("username").(string)
 
-	extraVars, err := io.ReadAll(ctx.Request.Body)
+	override, err := maintenanceOverride(ctx, jc.AuthService)
+	if err != nil {
+		jc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	extraVars, err := readExtraVars(ctx)
 
 	if err != nil {
 		jc.AuditService.CreateAudit(audit)
@@ -161,15 +195,26 @@ func (jc *JobController) CreateJob(ctx *gin.Context) {
 		return
 	}
 
-	job, err := jc.JobService.CreateJob(username, taskID, string(extraVars))
+	if runAt := ctx.Query("run_at"); runAt != "" {
+		jc.scheduleJob(ctx, audit, runAt, override, string(extraVars))
+		return
+	}
+
+	job, err := jc.JobService.CreateJob(username, taskID, string(extraVars), override)
 
 	if err != nil {
 		jc.AuditService.CreateAudit(audit)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		var validationErr *helpers.SchemaValidationError
+		if errors.As(err, &validationErr) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "errors": validationErr.Errors})
+			return
+		}
+		ctx.JSON(maintenanceStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	audit.Status = "success"
+	auditOverride(ctx, jc.AuditService, jc.AuditCategory, job)
 
 	if (job.ID != "") && (job.Completed != 0) {
 		jc.AuditService.CreateAudit(audit)
@@ -181,6 +226,92 @@ func (jc *JobController) CreateJob(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"msg": "job created successfully", "id": job.ID})
 }
 
+// scheduleJob stores the job to be launched at run_at by the scheduler.
+func (jc *JobController) scheduleJob(ctx *gin.Context, audit models.AuditLog, runAt string, override bool,
+	extraVars string) {
+	audit.EventType = "schedule"
+
+	if override {
+		jc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "override can't be used with run_at"})
+		return
+	}
+
+	at, err := time.Parse(time.RFC3339, runAt)
+	if err != nil {
+		jc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid run_at, expected RFC 3339: %v", err)})
+		return
+	}
+
+	userID := ctx.MustGet("userID").(uuid.UUID)
+	username := ctx.MustGet("username").(string)
+	scheduledJob, err := jc.ScheduledJobService.ScheduleJob(userID, username, ctx.Param("id"), extraVars, at)
+	if err != nil {
+		jc.AuditService.CreateAudit(audit)
+		var validationErr *helpers.SchemaValidationError
+		if errors.As(err, &validationErr) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "errors": validationErr.Errors})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	jc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "job scheduled successfully", "id": scheduledJob.ID, "run_at": scheduledJob.RunAt})
+}
+
+// readExtraVars returns the job input: the JSON body, or a JSON object of the form
+// or query string parameters, whose values are coerced to the task schema types.
+func readExtraVars(ctx *gin.Context) ([]byte, error) {
+	body, err := readBody(ctx)
+	if err != nil || len(bytes.TrimSpace(body)) > 0 {
+		return body, err
+	}
+
+	query := ctx.Request.URL.Query()
+	query.Del("override")
+	query.Del("run_at")
+	if len(query) > 0 {
+		return formValues(query)
+	}
+
+	return body, nil
+}
+
+// readBody returns the JSON body, or a JSON object of the form parameters. Webhook runs only read
+// the body, as the query string isn't signed.
+func readBody(ctx *gin.Context) ([]byte, error) {
+	if ctx.ContentType() == binding.MIMEPOSTForm || ctx.ContentType() == binding.MIMEMultipartPOSTForm {
+		if ctx.ContentType() == binding.MIMEMultipartPOSTForm {
+			_, err := ctx.MultipartForm()
+			if err != nil {
+				return nil, err
+			}
+		} else if err := ctx.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		return formValues(ctx.Request.PostForm)
+	}
+
+	return io.ReadAll(ctx.Request.Body)
+}
+
+func formValues(values url.Values) ([]byte, error) {
+	input := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if len(value) == 1 {
+			input[key] = value[0]
+			continue
+		}
+		input[key] = value
+	}
+
+	return json.Marshal(input)
+}
+
 // GetSchema godoc
 //
 //	@Summary		Get task schema
@@ -211,3 +342,32 @@ func (jc *JobController) GetSchema(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, schema)
 }
+
+// GetOpenAPI godoc
+//
+//	@Summary		Get OpenAPI document
+//	@Description	Generate an OpenAPI 3.1 document with an operation for each task the user can run
+//	@Tags			jobs
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/openapi.json [get]
+//	@Security		Bearer
+func (jc *JobController) GetOpenAPI(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	serverURL := fmt.Sprintf("%s://%s/api/v1", scheme, ctx.Request.Host)
+
+	document, err := jc.JobService.GetOpenAPI(authList, serverURL)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, document)
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type MaintenanceWindowController struct {
+	MaintenanceWindowService services.MaintenanceWindowService
+	AuthService              services.AuthService
+	AuditService             services.AuditService
+	AuditCategory            string
+}
+
+func NewMaintenanceWindowController(
+	mws services.MaintenanceWindowService,
+	as services.AuthService,
+	als services.AuditService,
+) MaintenanceWindowController {
+	return MaintenanceWindowController{
+		MaintenanceWindowService: mws,
+		AuthService:              as,
+		AuditService:             als,
+		AuditCategory:            "maintenance_windows",
+	}
+}
+
+func (mc *MaintenanceWindowController) SetMaintenanceWindowRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(mc.AuthService, config.JWT))
+
+	r.GET("", middlewares.SetAuthorizationListMiddleware(mc.AuthService, "maintenance_windows"), mc.ListMaintenanceWindows)
+	r.GET("/:id", middlewares.AuthorizationMiddleware(mc.AuthService, "maintenance_windows", "read"), mc.GetMaintenanceWindow)
+
+	r.Use(middlewares.AuthorizationMiddleware(mc.AuthService, "maintenance_windows", "write"))
+	{
+		r.POST("", mc.CreateMaintenanceWindow)
+		r.PUT("", mc.CreateMaintenanceWindow)
+		r.PATCH("/:id", mc.UpdateMaintenanceWindow)
+		r.PUT("/:id", mc.UpdateMaintenanceWindow)
+		r.DELETE("/:id", mc.DeleteMaintenanceWindow)
+	}
+}
+
+// ListMaintenanceWindows godoc
+//
+//	@Summary		List all maintenance windows
+//	@Description	List all maintenance windows and change freezes
+//	@Tags			maintenance_windows
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		models.MaintenanceWindow
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/maintenance_windows [get]
+//	@Security		Bearer
+func (mc *MaintenanceWindowController) ListMaintenanceWindows(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+	windows, err := mc.MaintenanceWindowService.ListMaintenanceWindows(authList)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(windows)))
+	if len(windows) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, windows)
+}
+
+// GetMaintenanceWindow godoc
+//
+//	@Summary		Get a maintenance window
+//	@Description	Get information about a specific maintenance window
+//	@Tags			maintenance_windows
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Maintenance window name"
+//	@Success		200	{object}	models.MaintenanceWindow
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/maintenance_windows/{id} [get]
+//	@Security		Bearer
+func (mc *MaintenanceWindowController) GetMaintenanceWindow(ctx *gin.Context) {
+	name := ctx.Param("id")
+	window, err := mc.MaintenanceWindowService.GetMaintenanceWindow(name)
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, window)
+}
+
+// CreateMaintenanceWindow godoc
+//
+//	@Summary		Create a maintenance window
+//	@Description	Add a one-off or recurring maintenance window.
+//	@Description	Deny windows block jobs while active, allow windows only let jobs run while active.
+//	@Tags			maintenance_windows
+//	@Accept			json
+//	@Produce		json
+//	@Param			window	body		models.MaintenanceWindow	true	"New maintenance window"
+//	@Success		200		{object}	models.MaintenanceWindow
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/maintenance_windows [post]
+//	@Security		Bearer
+func (mc *MaintenanceWindowController) CreateMaintenanceWindow(ctx *gin.Context) {
+	audit := mc.AuditService.InitialiseAuditLog(ctx, "create", mc.AuditCategory, "*")
+	var window models.MaintenanceWindow
+
+	if err := ctx.ShouldBindJSON(&window); err != nil {
+		mc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.EventTarget = window.Name
+	window.ID = uuid.Nil
+
+	window, err := mc.MaintenanceWindowService.CreateMaintenanceWindow(window)
+	if err != nil {
+		mc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	mc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, window)
+}
+
+// UpdateMaintenanceWindow godoc
+//
+//	@Summary		Update a maintenance window
+//	@Description	Replace a maintenance window
+//	@Tags			maintenance_windows
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Maintenance window name"
+//	@Param			window	body		models.MaintenanceWindow	true	"Update maintenance window"
+//	@Success		200		{object}	models.MaintenanceWindow
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/maintenance_windows/{id} [patch]
+//	@Security		Bearer
+func (mc *MaintenanceWindowController) UpdateMaintenanceWindow(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := mc.AuditService.InitialiseAuditLog(ctx, "update", mc.AuditCategory, name)
+	var window models.MaintenanceWindow
+
+	if err := ctx.ShouldBindJSON(&window); err != nil {
+		mc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	window.Name = name
+
+	window, err := mc.MaintenanceWindowService.UpdateMaintenanceWindow(window)
+	if err != nil {
+		mc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	mc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, window)
+}
+
+// DeleteMaintenanceWindow godoc
+//
+//	@Summary		Delete a maintenance window
+//	@Description	Delete by maintenance window name
+//	@Tags			maintenance_windows
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Maintenance window name"
+//	@Success		204	{object}	models.MaintenanceWindow
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/maintenance_windows/{id} [delete]
+//	@Security		Bearer
+func (mc *MaintenanceWindowController) DeleteMaintenanceWindow(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := mc.AuditService.InitialiseAuditLog(ctx, "delete", mc.AuditCategory, name)
+
+	err := mc.MaintenanceWindowService.DeleteMaintenanceWindow(name)
+	if err != nil {
+		mc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	mc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "maintenance window deleted successfully"})
+}
+
+// maintenanceOverride reads the override query parameter, only admins can override maintenance windows.
+func maintenanceOverride(ctx *gin.Context, as services.AuthService) (bool, error) {
+	param := ctx.Query("override")
+	if param == "" {
+		return false, nil
+	}
+
+	override, err := strconv.ParseBool(param)
+	if err != nil || !override {
+		return false, err
+	}
+
+	isAuthorised, err := as.IsAutorised(&models.Authorization{
+		UserID:     ctx.MustGet("userID").(uuid.UUID),
+		Provider:   ctx.MustGet("provider").(string),
+		Resource:   "*",
+		ResourceID: "*",
+		Access:     "write",
+	})
+	if err != nil {
+		return false, err
+	}
+	if !isAuthorised {
+		return false, errors.New("only admins can override maintenance windows")
+	}
+
+	return true, nil
+}
+
+// auditOverride records the maintenance windows overridden to run a job.
+func auditOverride(ctx *gin.Context, als services.AuditService, category string, job models.Job) {
+	if job.MaintenanceOverride == "" {
+		return
+	}
+
+	audit := als.InitialiseAuditLog(ctx, "override_maintenance", category, job.MaintenanceOverride)
+	audit.Status = "success"
+	als.CreateAudit(audit)
+}
+
+// maintenanceStatus is the status code of the errors returned when creating jobs.
+func maintenanceStatus(err error) int {
+	var windowErr *services.MaintenanceWindowError
+	if errors.As(err, &windowErr) {
+		return http.StatusLocked
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type NotificationChannelController struct {
+	NotificationService services.NotificationService
+	AuthService         services.AuthService
+	AuditService        services.AuditService
+	AuditCategory       string
+}
+
+func NewNotificationChannelController(
+	ns services.NotificationService,
+	as services.AuthService,
+	als services.AuditService,
+) NotificationChannelController {
+	return NotificationChannelController{
+		NotificationService: ns,
+		AuthService:         as,
+		AuditService:        als,
+		AuditCategory:       "notification_channels",
+	}
+}
+
+func (cc *NotificationChannelController) SetNotificationChannelRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(cc.AuthService, config.JWT))
+
+	r.GET("", middlewares.SetAuthorizationListMiddleware(cc.AuthService, "notification_channels"), cc.ListChannels)
+	r.GET("/:id", middlewares.AuthorizationMiddleware(cc.AuthService, "notification_channels", "read"), cc.GetChannel)
+	r.GET("/:id/messages", middlewares.AuthorizationMiddleware(cc.AuthService, "notification_channels", "read"),
+		cc.ListChannelMessages)
+
+	r.Use(middlewares.AuthorizationMiddleware(cc.AuthService, "notification_channels", "write"))
+	{
+		r.POST("", cc.CreateChannel)
+		r.PUT("", cc.CreateChannel)
+		r.PATCH("/:id", cc.UpdateChannel)
+		r.PUT("/:id", cc.UpdateChannel)
+		r.DELETE("/:id", cc.DeleteChannel)
+	}
+}
+
+// ListChannels godoc
+//
+//	@Summary		List all notification channels
+//	@Description	List all Slack, Microsoft Teams and email notification channels
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		models.NotificationChannel
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/notification_channels [get]
+//	@Security		Bearer
+func (cc *NotificationChannelController) ListChannels(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+	channels, err := cc.NotificationService.ListChannels(authList)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(channels)))
+	if len(channels) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	for i := range channels {
+		channels[i].URL = ""
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, channels)
+}
+
+// GetChannel godoc
+//
+//	@Summary		Get a notification channel
+//	@Description	Get information about a specific notification channel
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Channel name"
+//	@Success		200	{object}	models.NotificationChannel
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/notification_channels/{id} [get]
+//	@Security		Bearer
+func (cc *NotificationChannelController) GetChannel(ctx *gin.Context) {
+	channel, err := cc.NotificationService.GetChannel(ctx.Param("id"))
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel.URL = ""
+	ctx.JSON(http.StatusOK, channel)
+}
+
+// ListChannelMessages godoc
+//
+//	@Summary		List messages of a notification channel
+//	@Description	List the messages posted, or being posted, to a channel, the latest first
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Channel name"
+//	@Success		200	{array}		models.ChannelMessage
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/notification_channels/{id}/messages [get]
+//	@Security		Bearer
+func (cc *NotificationChannelController) ListChannelMessages(ctx *gin.Context) {
+	messages, err := cc.NotificationService.ListChannelMessages(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(messages)))
+	if len(messages) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, messages)
+}
+
+// CreateChannel godoc
+//
+//	@Summary		Create a notification channel
+//	@Description	Post messages to Slack, Microsoft Teams or by email when jobs of tasks or cronjobs start or finish, owned by the user creating it.
+//	@Description	Only jobs of the tasks the owner can read are notified about.
+//	@Description	The incoming webhook URL is a secret, it's only returned here.
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Param			channel	body		models.NotificationChannel	true	"New notification channel"
+//	@Success		200		{object}	models.NotificationChannel
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/notification_channels [post]
+//	@Security		Bearer
+func (cc *NotificationChannelController) CreateChannel(ctx *gin.Context) {
+	userid := ctx.MustGet("userID").(uuid.UUID)
+	audit := cc.AuditService.InitialiseAuditLog(ctx, "create", cc.AuditCategory, "*")
+	var channel models.NotificationChannel
+
+	if err := ctx.ShouldBindJSON(&channel); err != nil {
+		cc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.EventTarget = channel.Name
+	channel.ID = uuid.Nil
+	channel.Owner = userid
+
+	channel, err := cc.NotificationService.CreateChannel(channel)
+	if err != nil {
+		cc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	cc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, channel)
+}
+
+// UpdateChannel godoc
+//
+//	@Summary		Update a notification channel
+//	@Description	Replace a notification channel, its owner is kept, and its URL when not set
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Channel name"
+//	@Param			channel	body		models.NotificationChannel	true	"Update notification channel"
+//	@Success		200		{object}	models.NotificationChannel
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/notification_channels/{id} [patch]
+//	@Security		Bearer
+func (cc *NotificationChannelController) UpdateChannel(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := cc.AuditService.InitialiseAuditLog(ctx, "update", cc.AuditCategory, name)
+	var channel models.NotificationChannel
+
+	if err := ctx.ShouldBindJSON(&channel); err != nil {
+		cc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channel.Name = name
+
+	channel, err := cc.NotificationService.UpdateChannel(channel)
+	if err != nil {
+		cc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	cc.AuditService.CreateAudit(audit)
+	channel.URL = ""
+	ctx.JSON(http.StatusOK, channel)
+}
+
+// DeleteChannel godoc
+//
+//	@Summary		Delete a notification channel
+//	@Description	Delete by channel name, its pending messages are dropped
+//	@Tags			notification channels
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Channel name"
+//	@Success		204	{object}	models.NotificationChannel
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/notification_channels/{id} [delete]
+//	@Security		Bearer
+func (cc *NotificationChannelController) DeleteChannel(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := cc.AuditService.InitialiseAuditLog(ctx, "delete", cc.AuditCategory, name)
+
+	err := cc.NotificationService.DeleteChannel(name)
+	if err != nil {
+		cc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	cc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "notification channel deleted successfully"})
+}
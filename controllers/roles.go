@@ -15,7 +15,8 @@ import (
 )
 
 // TODO: This is currently hardcoded but needs to be fetched from somewhere else
-var resources = []string{"runners", "tasks", "jobs", "users", "roles", "role_bindings"}
+var resources = []string{"runners", "tasks", "jobs", "users", "roles", "role_bindings", "maintenance_windows", "subscriptions",
+	"notification_channels", "triggers", "event_rules", "kubernetes"}
 var access = []string{"read", "write"}
 
 type RoleController struct {
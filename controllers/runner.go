@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/kriten-io/kriten/config"
@@ -51,6 +52,8 @@ func (rc *RunnerController) SetRunnerRoutes(rg *gin.RouterGroup, config config.C
 			r.PUT("/:id/secret", rc.UpdateSecret)
 			r.DELETE("/:id/secret", rc.DeleteSecret)
 		}
+
+		r.POST("/:id/sync", rc.SyncRunner)
 	}
 
 }
@@ -345,3 +348,49 @@ func (rc *RunnerController) DeleteSecret(ctx *gin.Context) {
 	rc.AuditService.CreateAudit(audit)
 	ctx.JSON(http.StatusOK, gin.H{"msg": "secret deleted successfully"})
 }
+
+// SyncRunner godoc
+//
+//	@Summary		Sync tasks from manifest
+//	@Description	Create, update or remove the runner tasks to match kriten.yaml in its repository
+//	@Tags			runners
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Runner name"
+//	@Param			dry_run	query		bool	false	"Only return the changes"
+//	@Success		200		{object}	models.RunnerSync
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/runners/{id}/sync [post]
+//	@Security		Bearer
+func (rc *RunnerController) SyncRunner(ctx *gin.Context) {
+	runnerName := ctx.Param("id")
+	audit := rc.AuditService.InitialiseAuditLog(ctx, "sync", rc.AuditCategory, runnerName)
+
+	dryRun := false
+	if param := ctx.Query("dry_run"); param != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(param)
+		if err != nil {
+			rc.AuditService.CreateAudit(audit)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	result, err := rc.RunnerService.SyncRunner(runnerName, dryRun)
+	if err != nil {
+		rc.AuditService.CreateAudit(audit)
+		if errors.IsNotFound(err) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "runner doesn't exist"})
+			return
+		}
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	rc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type ScheduledJobController struct {
+	ScheduledJobService services.ScheduledJobService
+	AuthService         services.AuthService
+	AuditService        services.AuditService
+	AuditCategory       string
+}
+
+func NewScheduledJobController(
+	sjs services.ScheduledJobService,
+	as services.AuthService,
+	als services.AuditService,
+) ScheduledJobController {
+	return ScheduledJobController{
+		ScheduledJobService: sjs,
+		AuthService:         as,
+		AuditService:        als,
+		AuditCategory:       "scheduled_jobs",
+	}
+}
+
+func (sc *ScheduledJobController) SetScheduledJobRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(sc.AuthService, config.JWT))
+
+	// Scheduled jobs are authorised against their task, the same way jobs are
+	r.GET("", middlewares.SetAuthorizationListMiddleware(sc.AuthService, "jobs"), sc.ListScheduledJobs)
+	r.GET("/:id", sc.GetScheduledJob)
+	r.PATCH("/:id", sc.RescheduleJob)
+	r.DELETE("/:id", sc.CancelScheduledJob)
+}
+
+// ListScheduledJobs godoc
+//
+//	@Summary		List scheduled jobs
+//	@Description	List jobs scheduled to run once, the next first
+//	@Tags			scheduled_jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			status	query		string	false	"Filter by status: pending, launching, launched, failed or cancelled"
+//	@Success		200		{array}		models.ScheduledJob
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/scheduled_jobs [get]
+//	@Security		Bearer
+func (sc *ScheduledJobController) ListScheduledJobs(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+
+	scheduledJobs, err := sc.ScheduledJobService.ListScheduledJobs(authList, ctx.Query("status"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(scheduledJobs)))
+	if len(scheduledJobs) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, scheduledJobs)
+}
+
+// GetScheduledJob godoc
+//
+//	@Summary		Get a scheduled job
+//	@Description	Get a job scheduled to run once
+//	@Tags			scheduled_jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Scheduled job ID"
+//	@Success		200	{object}	models.ScheduledJob
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/scheduled_jobs/{id} [get]
+//	@Security		Bearer
+func (sc *ScheduledJobController) GetScheduledJob(ctx *gin.Context) {
+	scheduledJob, ok := sc.authorise(ctx, "read")
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, scheduledJob)
+}
+
+// RescheduleJob godoc
+//
+//	@Summary		Reschedule a job
+//	@Description	Change the time a pending job is scheduled to run at
+//	@Tags			scheduled_jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string				true	"Scheduled job ID"
+//	@Param			schedule	body		map[string]string	true	"run_at, RFC 3339"
+//	@Success		200			{object}	models.ScheduledJob
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		409			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/scheduled_jobs/{id} [patch]
+//	@Security		Bearer
+func (sc *ScheduledJobController) RescheduleJob(ctx *gin.Context) {
+	audit := sc.AuditService.InitialiseAuditLog(ctx, "update", sc.AuditCategory, ctx.Param("id"))
+	var body struct {
+		RunAt time.Time `json:"run_at" binding:"required"`
+	}
+
+	_, ok := sc.authorise(ctx, "write")
+	if !ok {
+		sc.AuditService.CreateAudit(audit)
+		return
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scheduledJob, err := sc.ScheduledJobService.RescheduleJob(ctx.Param("id"), body.RunAt)
+	if err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	sc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, scheduledJob)
+}
+
+// CancelScheduledJob godoc
+//
+//	@Summary		Cancel a scheduled job
+//	@Description	Cancel a pending scheduled job, it's kept with the cancelled status
+//	@Tags			scheduled_jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Scheduled job ID"
+//	@Success		200	{object}	models.ScheduledJob
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		409	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/scheduled_jobs/{id} [delete]
+//	@Security		Bearer
+func (sc *ScheduledJobController) CancelScheduledJob(ctx *gin.Context) {
+	audit := sc.AuditService.InitialiseAuditLog(ctx, "cancel", sc.AuditCategory, ctx.Param("id"))
+
+	_, ok := sc.authorise(ctx, "write")
+	if !ok {
+		sc.AuditService.CreateAudit(audit)
+		return
+	}
+
+	scheduledJob, err := sc.ScheduledJobService.CancelScheduledJob(ctx.Param("id"))
+	if err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	sc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, scheduledJob)
+}
+
+// authorise checks the user access to the task of a scheduled job, writing the response if denied.
+func (sc *ScheduledJobController) authorise(ctx *gin.Context, access string) (models.ScheduledJob, bool) {
+	scheduledJob, err := sc.ScheduledJobService.GetScheduledJob(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return scheduledJob, false
+	}
+
+	isAuthorised, err := sc.AuthService.IsAutorised(&models.Authorization{
+		UserID:     ctx.MustGet("userID").(uuid.UUID),
+		Provider:   ctx.MustGet("provider").(string),
+		Resource:   "jobs",
+		ResourceID: scheduledJob.Task,
+		Access:     access,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error."})
+		return scheduledJob, false
+	}
+	if !isAuthorised {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "unauthorized - user cannot access resource"})
+		return scheduledJob, false
+	}
+
+	return scheduledJob, true
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type SubscriptionController struct {
+	NotificationService services.NotificationService
+	AuthService         services.AuthService
+	AuditService        services.AuditService
+	AuditCategory       string
+}
+
+func NewSubscriptionController(
+	ns services.NotificationService,
+	as services.AuthService,
+	als services.AuditService,
+) SubscriptionController {
+	return SubscriptionController{
+		NotificationService: ns,
+		AuthService:         as,
+		AuditService:        als,
+		AuditCategory:       "subscriptions",
+	}
+}
+
+func (sc *SubscriptionController) SetSubscriptionRoutes(rg *gin.RouterGroup, config config.Config) {
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(sc.AuthService, config.JWT))
+
+	r.GET("", middlewares.SetAuthorizationListMiddleware(sc.AuthService, "subscriptions"), sc.ListSubscriptions)
+	r.GET("/:id", middlewares.AuthorizationMiddleware(sc.AuthService, "subscriptions", "read"), sc.GetSubscription)
+	r.GET("/:id/deliveries", middlewares.AuthorizationMiddleware(sc.AuthService, "subscriptions", "read"),
+		sc.ListNotificationDeliveries)
+
+	r.Use(middlewares.AuthorizationMiddleware(sc.AuthService, "subscriptions", "write"))
+	{
+		r.POST("", sc.CreateSubscription)
+		r.PUT("", sc.CreateSubscription)
+		r.PATCH("/:id", sc.UpdateSubscription)
+		r.PUT("/:id", sc.UpdateSubscription)
+		r.DELETE("/:id", sc.DeleteSubscription)
+	}
+}
+
+// ListSubscriptions godoc
+//
+//	@Summary		List all subscriptions
+//	@Description	List all subscriptions to event notifications
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		models.Subscription
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/subscriptions [get]
+//	@Security		Bearer
+func (sc *SubscriptionController) ListSubscriptions(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+	subscriptions, err := sc.NotificationService.ListSubscriptions(authList)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(subscriptions)))
+	if len(subscriptions) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, subscriptions)
+}
+
+// GetSubscription godoc
+//
+//	@Summary		Get a subscription
+//	@Description	Get information about a specific subscription
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Subscription name"
+//	@Success		200	{object}	models.Subscription
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/subscriptions/{id} [get]
+//	@Security		Bearer
+func (sc *SubscriptionController) GetSubscription(ctx *gin.Context) {
+	subscription, err := sc.NotificationService.GetSubscription(ctx.Param("id"))
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription.Secret = ""
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+// ListNotificationDeliveries godoc
+//
+//	@Summary		List notifications of a subscription
+//	@Description	List the events delivered, or being delivered, to a subscription, the latest first
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Subscription name"
+//	@Success		200	{array}		models.NotificationDelivery
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/subscriptions/{id}/deliveries [get]
+//	@Security		Bearer
+func (sc *SubscriptionController) ListNotificationDeliveries(ctx *gin.Context) {
+	deliveries, err := sc.NotificationService.ListNotificationDeliveries(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(deliveries)))
+	if len(deliveries) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// CreateSubscription godoc
+//
+//	@Summary		Create a subscription
+//	@Description	Subscribe an HTTP endpoint to event notifications, delivered as CloudEvents, owned by the user creating it.
+//	@Description	Job events are delivered for the tasks the owner can read jobs of, audit and login events when the owner can read the audit log.
+//	@Description	Endpoints must have public addresses, unless their host is in NOTIFICATION_ALLOWED_HOSTS which then restricts them.
+//	@Description	A secret signing deliveries is generated when not set, it's only returned here.
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			subscription	body		models.Subscription	true	"New subscription"
+//	@Success		200				{object}	models.Subscription
+//	@Failure		400				{object}	helpers.HTTPError
+//	@Failure		404				{object}	helpers.HTTPError
+//	@Failure		500				{object}	helpers.HTTPError
+//	@Router			/subscriptions [post]
+//	@Security		Bearer
+func (sc *SubscriptionController) CreateSubscription(ctx *gin.Context) {
+	userid := ctx.MustGet("userID").(uuid.UUID)
+	audit := sc.AuditService.InitialiseAuditLog(ctx, "create", sc.AuditCategory, "*")
+	var subscription models.Subscription
+
+	if err := ctx.ShouldBindJSON(&subscription); err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.EventTarget = subscription.Name
+	subscription.ID = uuid.Nil
+	subscription.Owner = userid
+
+	subscription, err := sc.NotificationService.CreateSubscription(subscription)
+	if err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	sc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+// UpdateSubscription godoc
+//
+//	@Summary		Update a subscription
+//	@Description	Replace a subscription, its owner is kept, and its secret when not set
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id				path		string				true	"Subscription name"
+//	@Param			subscription	body		models.Subscription	true	"Update subscription"
+//	@Success		200				{object}	models.Subscription
+//	@Failure		400				{object}	helpers.HTTPError
+//	@Failure		404				{object}	helpers.HTTPError
+//	@Failure		500				{object}	helpers.HTTPError
+//	@Router			/subscriptions/{id} [patch]
+//	@Security		Bearer
+func (sc *SubscriptionController) UpdateSubscription(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := sc.AuditService.InitialiseAuditLog(ctx, "update", sc.AuditCategory, name)
+	var subscription models.Subscription
+
+	if err := ctx.ShouldBindJSON(&subscription); err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	subscription.Name = name
+
+	subscription, err := sc.NotificationService.UpdateSubscription(subscription)
+	if err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	sc.AuditService.CreateAudit(audit)
+	subscription.Secret = ""
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+// DeleteSubscription godoc
+//
+//	@Summary		Delete a subscription
+//	@Description	Delete by subscription name, its pending notifications are dropped
+//	@Tags			subscriptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Subscription name"
+//	@Success		204	{object}	models.Subscription
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/subscriptions/{id} [delete]
+//	@Security		Bearer
+func (sc *SubscriptionController) DeleteSubscription(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := sc.AuditService.InitialiseAuditLog(ctx, "delete", sc.AuditCategory, name)
+
+	err := sc.NotificationService.DeleteSubscription(name)
+	if err != nil {
+		sc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	sc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "subscription deleted successfully"})
+}
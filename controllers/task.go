@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/kriten-io/kriten/config"
@@ -38,6 +39,9 @@ func (tc *TaskController) SetTaskRoutes(rg *gin.RouterGroup, config config.Confi
 	r.GET("", middlewares.SetAuthorizationListMiddleware(tc.AuthService, "tasks"), tc.ListTasks)
 	r.GET("/:id", middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "read"), tc.GetTask)
 	r.GET("/:id/schema", middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "read"), tc.GetSchema)
+	r.GET("/:id/revisions", middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "read"), tc.ListRevisions)
+	r.GET("/:id/revisions/:revision", middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "read"), tc.GetRevision)
+	r.GET("/:id/revisions/:revision/diff", middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "read"), tc.DiffRevisions)
 
 	r.Use(middlewares.AuthorizationMiddleware(tc.AuthService, "tasks", "write"))
 	{
@@ -52,6 +56,8 @@ func (tc *TaskController) SetTaskRoutes(rg *gin.RouterGroup, config config.Confi
 			r.PUT("/:id/schema", tc.UpdateSchema)
 			r.DELETE("/:id/schema", tc.DeleteSchema)
 		}
+
+		r.POST("/:id/revisions/:revision/rollback", tc.RollbackTask)
 	}
 
 }
@@ -343,3 +349,149 @@ func (tc *TaskController) DeleteSchema(ctx *gin.Context) {
 	tc.AuditService.CreateAudit(audit)
 	ctx.JSON(http.StatusOK, gin.H{"msg": "schema deleted successfully"})
 }
+
+// ListRevisions godoc
+//
+//	@Summary		List task revisions
+//	@Description	List the revisions of a task, newest first
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Task name"
+//	@Success		200	{array}		models.TaskRevision
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/tasks/{id}/revisions [get]
+//	@Security		Bearer
+func (tc *TaskController) ListRevisions(ctx *gin.Context) {
+	taskName := ctx.Param("id")
+	revisions, err := tc.TaskService.ListRevisions(taskName)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(revisions)))
+	if len(revisions) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, revisions)
+}
+
+// GetRevision godoc
+//
+//	@Summary		Get a task revision
+//	@Description	Get the spec of a task at a specific revision
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Task name"
+//	@Param			revision	path		int		true	"Revision"
+//	@Success		200			{object}	models.TaskRevision
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/tasks/{id}/revisions/{revision} [get]
+//	@Security		Bearer
+func (tc *TaskController) GetRevision(ctx *gin.Context) {
+	taskName := ctx.Param("id")
+	revision, err := strconv.Atoi(ctx.Param("revision"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "revision must be a number"})
+		return
+	}
+
+	taskRevision, err := tc.TaskService.GetRevision(taskName, revision)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, taskRevision)
+}
+
+// DiffRevisions godoc
+//
+//	@Summary		Diff task revisions
+//	@Description	Compare a revision with another one, by default with the current revision
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Task name"
+//	@Param			revision	path		int		true	"Revision"
+//	@Param			to			query		int		false	"Revision to compare with"
+//	@Success		200			{object}	models.TaskRevisionDiff
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/tasks/{id}/revisions/{revision}/diff [get]
+//	@Security		Bearer
+func (tc *TaskController) DiffRevisions(ctx *gin.Context) {
+	taskName := ctx.Param("id")
+	from, err := strconv.Atoi(ctx.Param("revision"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "revision must be a number"})
+		return
+	}
+
+	to := 0
+	if param := ctx.Query("to"); param != "" {
+		to, err = strconv.Atoi(param)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "to must be a number"})
+			return
+		}
+	}
+
+	diff, err := tc.TaskService.DiffRevisions(taskName, from, to)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, diff)
+}
+
+// RollbackTask godoc
+//
+//	@Summary		Rollback a task
+//	@Description	Restore a previous revision of a task, stored as a new revision
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Task name"
+//	@Param			revision	path		int		true	"Revision to restore"
+//	@Success		200			{object}	models.Task
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/tasks/{id}/revisions/{revision}/rollback [post]
+//	@Security		Bearer
+func (tc *TaskController) RollbackTask(ctx *gin.Context) {
+	taskName := ctx.Param("id")
+	audit := tc.AuditService.InitialiseAuditLog(ctx, "rollback", tc.AuditCategory, taskName)
+
+	revision, err := strconv.Atoi(ctx.Param("revision"))
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "revision must be a number"})
+		return
+	}
+
+	task, err := tc.TaskService.RollbackTask(taskName, revision)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	tc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, task)
+}
@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/middlewares"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+type TriggerController struct {
+	TriggerService services.TriggerService
+	AuthService    services.AuthService
+	AuditService   services.AuditService
+	AuditCategory  string
+}
+
+func NewTriggerController(
+	trs services.TriggerService,
+	as services.AuthService,
+	als services.AuditService,
+) TriggerController {
+	return TriggerController{
+		TriggerService: trs,
+		AuthService:    as,
+		AuditService:   als,
+		AuditCategory:  "triggers",
+	}
+}
+
+func (tc *TriggerController) SetTriggerRoutes(rg *gin.RouterGroup, config config.Config) {
+	// Events are authenticated with the trigger secret and run as the trigger owner
+	rg.POST("/:id/events", middlewares.TriggerAuthenticationMiddleware(tc.TriggerService), tc.ReceiveEvent)
+
+	r := rg.Group("").Use(
+		middlewares.AuthenticationMiddleware(tc.AuthService, config.JWT))
+
+	r.GET("", middlewares.SetAuthorizationListMiddleware(tc.AuthService, "triggers"), tc.ListTriggers)
+	r.GET("/:id", middlewares.AuthorizationMiddleware(tc.AuthService, "triggers", "read"), tc.GetTrigger)
+
+	r.Use(middlewares.AuthorizationMiddleware(tc.AuthService, "triggers", "write"))
+	{
+		r.POST("", tc.CreateTrigger)
+		r.PUT("", tc.CreateTrigger)
+		r.PATCH("/:id", tc.UpdateTrigger)
+		r.PUT("/:id", tc.UpdateTrigger)
+		r.DELETE("/:id", tc.DeleteTrigger)
+	}
+}
+
+// ListTriggers godoc
+//
+//	@Summary		List all triggers
+//	@Description	List all triggers running tasks on events
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		models.Trigger
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/triggers [get]
+//	@Security		Bearer
+func (tc *TriggerController) ListTriggers(ctx *gin.Context) {
+	authList := ctx.MustGet("authList").([]string)
+	triggers, err := tc.TriggerService.ListTriggers(authList)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(triggers)))
+	if len(triggers) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	for i := range triggers {
+		triggers[i].Secret = ""
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.JSON(http.StatusOK, triggers)
+}
+
+// GetTrigger godoc
+//
+//	@Summary		Get a trigger
+//	@Description	Get information about a specific trigger
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Trigger name"
+//	@Success		200	{object}	models.Trigger
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/triggers/{id} [get]
+//	@Security		Bearer
+func (tc *TriggerController) GetTrigger(ctx *gin.Context) {
+	trigger, err := tc.TriggerService.GetTrigger(ctx.Param("id"))
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	trigger.Secret = ""
+	ctx.JSON(http.StatusOK, trigger)
+}
+
+// CreateTrigger godoc
+//
+//	@Summary		Create a trigger
+//	@Description	Run a task on CloudEvents sent over HTTP, on messages consumed from NATS or Kafka, or on changes of Kubernetes resources, as the user creating it.
+//	@Description	HTTP triggers get a secret authenticating events when not set, it's only returned here.
+//	@Description	Kubernetes triggers need a role granting the resource watched, e.g. kubernetes v1/nodes.
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Param			trigger	body		models.Trigger	true	"New trigger"
+//	@Success		200		{object}	models.Trigger
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		403		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/triggers [post]
+//	@Security		Bearer
+func (tc *TriggerController) CreateTrigger(ctx *gin.Context) {
+	userid := ctx.MustGet("userID").(uuid.UUID)
+	audit := tc.AuditService.InitialiseAuditLog(ctx, "create", tc.AuditCategory, "*")
+	var trigger models.Trigger
+
+	if err := ctx.ShouldBindJSON(&trigger); err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.EventTarget = trigger.Name
+	trigger.ID = uuid.Nil
+	trigger.Owner = userid
+
+	if !tc.authoriseResource(ctx, trigger) {
+		tc.AuditService.CreateAudit(audit)
+		return
+	}
+
+	trigger, err := tc.TriggerService.CreateTrigger(trigger)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	tc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, trigger)
+}
+
+// UpdateTrigger godoc
+//
+//	@Summary		Update a trigger
+//	@Description	Replace a trigger, the owner is kept and so is the secret when not set
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string			true	"Trigger name"
+//	@Param			trigger	body		models.Trigger	true	"Update trigger"
+//	@Success		200		{object}	models.Trigger
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		403		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/triggers/{id} [patch]
+//	@Security		Bearer
+func (tc *TriggerController) UpdateTrigger(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := tc.AuditService.InitialiseAuditLog(ctx, "update", tc.AuditCategory, name)
+	var trigger models.Trigger
+
+	if err := ctx.ShouldBindJSON(&trigger); err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	trigger.Name = name
+
+	if !tc.authoriseResource(ctx, trigger) {
+		tc.AuditService.CreateAudit(audit)
+		return
+	}
+
+	trigger, err := tc.TriggerService.UpdateTrigger(trigger)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	tc.AuditService.CreateAudit(audit)
+	trigger.Secret = ""
+	ctx.JSON(http.StatusOK, trigger)
+}
+
+// DeleteTrigger godoc
+//
+//	@Summary		Delete a trigger
+//	@Description	Delete by trigger name, its consumers stop on the next sync
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Trigger name"
+//	@Success		204	{object}	models.Trigger
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/triggers/{id} [delete]
+//	@Security		Bearer
+func (tc *TriggerController) DeleteTrigger(ctx *gin.Context) {
+	name := ctx.Param("id")
+	audit := tc.AuditService.InitialiseAuditLog(ctx, "delete", tc.AuditCategory, name)
+
+	err := tc.TriggerService.DeleteTrigger(name)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	tc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, gin.H{"msg": "trigger deleted successfully"})
+}
+
+// ReceiveEvent godoc
+//
+//	@Summary		Send an event to a trigger
+//	@Description	Run the trigger task on a CloudEvent, in the structured (application/cloudevents+json) or the binary mode (ce-* headers).
+//	@Description	Events are authenticated with the trigger secret, as a bearer token or the HMAC-SHA256 of the body in X-Kriten-Signature.
+//	@Tags			triggers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Trigger name"
+//	@Param			event	body		object	true	"CloudEvent"
+//	@Success		200		{object}	models.Job
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		401		{object}	helpers.HTTPError
+//	@Failure		403		{object}	helpers.HTTPError
+//	@Failure		423		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/triggers/{id}/events [post]
+func (tc *TriggerController) ReceiveEvent(ctx *gin.Context) {
+	trigger := ctx.MustGet("trigger").(models.Trigger)
+	owner := models.User{
+		ID:       ctx.MustGet("userID").(uuid.UUID),
+		Username: ctx.MustGet("username").(string),
+		Provider: ctx.MustGet("provider").(string),
+	}
+
+	audit := tc.AuditService.InitialiseAuditLog(ctx, "run", tc.AuditCategory, trigger.Name)
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, run, err := tc.TriggerService.ReceiveEvent(trigger, owner, ctx.Request.Header, body)
+	if err != nil {
+		tc.AuditService.CreateAudit(audit)
+		ctx.JSON(triggerStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	tc.AuditService.CreateAudit(audit)
+
+	if !run {
+		ctx.JSON(http.StatusOK, gin.H{"msg": "event filtered out, no job created"})
+		return
+	}
+
+	if (job.ID != "") && (job.Completed != 0) {
+		ctx.JSON(http.StatusOK, job)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "job created successfully", "id": job.ID})
+}
+
+// authoriseResource checks the user access to the resource watched by a Kubernetes trigger,
+// writing the response if denied.
+func (tc *TriggerController) authoriseResource(ctx *gin.Context, trigger models.Trigger) bool {
+	if trigger.Source != services.TriggerKubernetes {
+		return true
+	}
+
+	isAuthorised, err := tc.AuthService.IsAutorised(&models.Authorization{
+		UserID:     ctx.MustGet("userID").(uuid.UUID),
+		Provider:   ctx.MustGet("provider").(string),
+		Resource:   "kubernetes",
+		ResourceID: trigger.Resource,
+		Access:     "read",
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error."})
+		return false
+	}
+	if !isAuthorised {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "unauthorized - user cannot watch resource " + trigger.Resource})
+		return false
+	}
+
+	return true
+}
+
+func triggerStatus(err error) int {
+	var eventErr *services.TriggerEventError
+	var ownerErr *services.TriggerOwnerError
+	var validationErr *helpers.SchemaValidationError
+	switch {
+	case errors.As(err, &eventErr), errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &ownerErr):
+		return http.StatusForbidden
+	}
+
+	return maintenanceStatus(err)
+}
@@ -1,11 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"time"
 
 	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
 	"github.com/kriten-io/kriten/middlewares"
 	"github.com/kriten-io/kriten/models"
 	"github.com/kriten-io/kriten/services"
@@ -16,7 +18,6 @@ import (
 
 type WebhookController struct {
 	WebhookService services.WebhookService
-	JobService     services.JobService
 	AuthService    services.AuthService
 	providers      []string
 	AuditService   services.AuditService
@@ -25,14 +26,12 @@ type WebhookController struct {
 
 func NewWebhookController(
 	ws services.WebhookService,
-	js services.JobService,
 	as services.AuthService,
 	als services.AuditService,
 	p []string,
 ) WebhookController {
 	return WebhookController{
 		WebhookService: ws,
-		JobService:     js,
 		AuthService:    as,
 		providers:      p,
 		AuditService:   als,
@@ -41,6 +40,12 @@ func NewWebhookController(
 }
 
 func (wc *WebhookController) SetWebhookRoutes(rg *gin.RouterGroup, config config.Config) {
+	// Webhook runs are authenticated by their signature instead of user credentials
+	rg.POST("/run/:id",
+		middlewares.WebhookAuthenticationMiddleware(wc.WebhookService),
+		middlewares.AuthorizationMiddleware(wc.AuthService, "jobs", "write"),
+		wc.RunWebhook)
+
 	r := rg.Group("").Use(
 		middlewares.AuthenticationMiddleware(wc.AuthService, config.JWT))
 
@@ -49,16 +54,18 @@ func (wc *WebhookController) SetWebhookRoutes(rg *gin.RouterGroup, config config
 
 	r.GET("/all", middlewares.SetAuthorizationListMiddleware(wc.AuthService, "webHooks"), wc.ListAllWebhooks)
 	r.GET("/:id", middlewares.AuthorizationMiddleware(wc.AuthService, "webHooks", "read"), wc.GetWebhook)
+	r.GET("/:id/deliveries", middlewares.AuthorizationMiddleware(wc.AuthService, "webHooks", "read"),
+		wc.ListWebhookDeliveries)
 
 	r.POST("", wc.CreateWebhook)
 	r.PUT("", wc.CreateWebhook)
 
-	r.POST("/run/:id", middlewares.AuthorizationMiddleware(wc.AuthService, "jobs", "write"), wc.RunWebhook)
-
 	r.Use(middlewares.AuthorizationMiddleware(wc.AuthService, "webHooks", "write"))
 	{
-		//r.PATCH("/:id", wc.UpdateWebhooks)
-		//r.PUT("/:id", wc.UpdateWebhooks)
+		r.PATCH("/:id", wc.UpdateWebhook)
+		r.PUT("/:id", wc.UpdateWebhook)
+		r.POST("/:id/rotate", wc.RotateWebhookSecret)
+		r.POST("/:id/deliveries/:delivery/redeliver", wc.RedeliverWebhook)
 		r.DELETE("/:id", wc.DeleteWebhook)
 	}
 }
@@ -86,6 +93,7 @@ func (wc *WebhookController) ListWebhooks(ctx *gin.Context) {
 	}
 
 	// audit.Status = "success"
+	hideSecrets(webHooks)
 	ctx.Header("Content-range", fmt.Sprintf("%v", len(webHooks)))
 	if len(webHooks) == 0 {
 		var arr [0]int
@@ -119,6 +127,7 @@ func (wc *WebhookController) ListAllWebhooks(ctx *gin.Context) {
 		return
 	}
 
+	hideSecrets(webHooks)
 	ctx.Header("Content-range", fmt.Sprintf("%v", len(webHooks)))
 	if len(webHooks) == 0 {
 		var arr [0]int
@@ -153,13 +162,15 @@ func (wc *WebhookController) GetWebhook(ctx *gin.Context) {
 		return
 	}
 
+	webhook.Secret = ""
 	ctx.JSON(http.StatusOK, webhook)
 }
 
 // CreateWebhook godoc
 //
 //	@Summary		Create a new webhook
-//	@Description	Add a webhook to the cluster
+//	@Description	Add a webhook to the cluster, a secret is generated when not set.
+//	@Description	The secret is only returned here, or when it's rotated.
 //	@Tags			webhooks
 //	@Accept			json
 //	@Produce		json
@@ -195,6 +206,177 @@ func (wc *WebhookController) CreateWebhook(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, webhook)
 }
 
+// UpdateWebhook godoc
+//
+//	@Summary		Update a webhook
+//	@Description	Update the fields set, the secret is changed with a rotation and the owner can't be changed
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string			true	"Webhook ID"
+//	@Param			webhook	body		models.Webhook	true	"Update Webhook"
+//	@Success		200		{object}	models.Webhook
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/webhooks/{id} [patch]
+//	@Security		Bearer
+func (wc *WebhookController) UpdateWebhook(ctx *gin.Context) {
+	webhookID := ctx.Param("id")
+	audit := wc.AuditService.InitialiseAuditLog(ctx, "update", wc.AuditCategory, webhookID)
+	var webhook models.Webhook
+	var err error
+
+	if err := ctx.ShouldBindJSON(&webhook); err != nil {
+		wc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook.ID, err = uuid.FromString(webhookID)
+	if err != nil {
+		wc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook.Owner = uuid.Nil
+	webhook.Secret = ""
+	webhook.PreviousSecretExpiresAt = nil
+
+	webhook, err = wc.WebhookService.UpdateWebhook(webhook)
+	if err != nil {
+		wc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	wc.AuditService.CreateAudit(audit)
+	webhook.Secret = ""
+	ctx.JSON(http.StatusOK, webhook)
+}
+
+// RotateWebhookSecret godoc
+//
+//	@Summary		Rotate a webhook secret
+//	@Description	Replace the webhook secret, a random one is generated when not set.
+//	@Description	The previous secret keeps verifying requests during the grace period, e.g. "24h".
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"Webhook ID"
+//	@Param			rotate	body		map[string]string	false	"secret and grace_period"
+//	@Success		200		{object}	models.Webhook
+//	@Failure		400		{object}	helpers.HTTPError
+//	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		500		{object}	helpers.HTTPError
+//	@Router			/webhooks/{id}/rotate [post]
+//	@Security		Bearer
+func (wc *WebhookController) RotateWebhookSecret(ctx *gin.Context) {
+	webhookID := ctx.Param("id")
+	audit := wc.AuditService.InitialiseAuditLog(ctx, "rotate", wc.AuditCategory, webhookID)
+	var body struct {
+		Secret      string `json:"secret"`
+		GracePeriod string `json:"grace_period"`
+	}
+
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			wc.AuditService.CreateAudit(audit)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var grace time.Duration
+	if body.GracePeriod != "" {
+		var err error
+		grace, err = time.ParseDuration(body.GracePeriod)
+		if err != nil || grace < 0 {
+			wc.AuditService.CreateAudit(audit)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid grace_period %s", body.GracePeriod)})
+			return
+		}
+	}
+
+	webhook, err := wc.WebhookService.RotateWebhookSecret(webhookID, body.Secret, grace)
+	if err != nil {
+		wc.AuditService.CreateAudit(audit)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Status = "success"
+	wc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, webhook)
+}
+
+// ListWebhookDeliveries godoc
+//
+//	@Summary		List webhook deliveries
+//	@Description	List the requests received by a webhook, the latest first
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Webhook ID"
+//	@Success		200	{array}		models.WebhookDelivery
+//	@Failure		400	{object}	helpers.HTTPError
+//	@Failure		404	{object}	helpers.HTTPError
+//	@Failure		500	{object}	helpers.HTTPError
+//	@Router			/webhooks/{id}/deliveries [get]
+//	@Security		Bearer
+func (wc *WebhookController) ListWebhookDeliveries(ctx *gin.Context) {
+	deliveries, err := wc.WebhookService.ListWebhookDeliveries(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-range", fmt.Sprintf("%v", len(deliveries)))
+	if len(deliveries) == 0 {
+		var arr [0]int
+		ctx.JSON(http.StatusOK, arr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverWebhook godoc
+//
+//	@Summary		Redeliver a webhook delivery
+//	@Description	Deliver a recorded payload again as the webhook owner, with the current mapping and filter
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Webhook ID"
+//	@Param			delivery	path		string	true	"Delivery ID"
+//	@Success		200			{object}	models.WebhookDelivery
+//	@Failure		400			{object}	helpers.HTTPError
+//	@Failure		404			{object}	helpers.HTTPError
+//	@Failure		500			{object}	helpers.HTTPError
+//	@Router			/webhooks/{id}/deliveries/{delivery}/redeliver [post]
+//	@Security		Bearer
+func (wc *WebhookController) RedeliverWebhook(ctx *gin.Context) {
+	webhookID := ctx.Param("id")
+	audit := wc.AuditService.InitialiseAuditLog(ctx, "redeliver", wc.AuditCategory, webhookID)
+
+	delivery, _, err := wc.WebhookService.RedeliverWebhook(webhookID, ctx.Param("delivery"))
+	if err != nil {
+		wc.AuditService.CreateAudit(audit)
+		if delivery.ID == uuid.Nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		deliveryError(ctx, delivery, err)
+		return
+	}
+
+	audit.Status = "success"
+	wc.AuditService.CreateAudit(audit)
+	ctx.JSON(http.StatusOK, delivery)
+}
+
 // DeleteWebhook godoc
 //
 //	@Summary		Delete a webhook
@@ -227,7 +409,8 @@ func (wc *WebhookController) DeleteWebhook(ctx *gin.Context) {
 // RunWebhook godoc
 //
 //	@Summary		Run webhook
-//	@Description	Execute Kriten job via webhook
+//	@Description	Execute Kriten job via webhook, the request is verified with the webhook signature scheme
+//	@Description	and the payload filtered and mapped into the task inputs when the webhook defines it
 //	@Tags			webhooks
 //	@Accept			json
 //	@Produce		json
@@ -236,43 +419,67 @@ func (wc *WebhookController) DeleteWebhook(ctx *gin.Context) {
 //	@Success		200		{object}	models.Job
 //	@Failure		400		{object}	helpers.HTTPError
 //	@Failure		404		{object}	helpers.HTTPError
+//	@Failure		409		{object}	helpers.HTTPError
 //	@Failure		500		{object}	helpers.HTTPError
 //	@Router			/webhooks/run/{id} [post]
 //	@Security		Signature
 func (wc *WebhookController) RunWebhook(ctx *gin.Context) {
-	webhookID := ctx.Param("id")
-	taskID := ctx.MustGet("taskID").(string)
+	webhook := ctx.MustGet("webhook").(models.Webhook)
 	username := ctx.MustGet("username").(string)
 
-	audit := wc.AuditService.InitialiseAuditLog(ctx, "run", wc.AuditCategory, webhookID)
-	audit.Status = "success"
-	wc.AuditService.CreateAudit(audit)
-
-	extraVars, err := io.ReadAll(ctx.Request.Body)
+	audit := wc.AuditService.InitialiseAuditLog(ctx, "run", wc.AuditCategory, webhook.ID.String())
 
+	extraVars, err := readBody(ctx)
 	if err != nil {
 		wc.AuditService.CreateAudit(audit)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	job, err := wc.JobService.CreateJob(username, taskID, string(extraVars))
-
+	delivery, job, err := wc.WebhookService.DeliverWebhook(webhook, username, models.WebhookDelivery{
+		Headers:      ctx.Request.Header,
+		Verification: ctx.GetString("verification"),
+		Payload:      string(extraVars),
+	})
 	if err != nil {
 		wc.AuditService.CreateAudit(audit)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		deliveryError(ctx, delivery, err)
 		return
 	}
 
 	audit.Status = "success"
+	wc.AuditService.CreateAudit(audit)
+
+	if delivery.Status == services.DeliveryFiltered {
+		ctx.JSON(http.StatusOK, gin.H{"msg": "payload filtered out, no job created", "delivery": delivery.ID})
+		return
+	}
 
 	if (job.ID != "") && (job.Completed != 0) {
-		//ctx.JSON(http.StatusOK, gin.H{"id": jobID, "json_data": sync.JsonData})
-		wc.AuditService.CreateAudit(audit)
 		ctx.JSON(http.StatusOK, job)
 		return
 	}
 
-	wc.AuditService.CreateAudit(audit)
-	ctx.JSON(http.StatusOK, gin.H{"msg": "job created successfully", "id": job.ID})
+	ctx.JSON(http.StatusOK, gin.H{"msg": "job created successfully", "id": job.ID, "delivery": delivery.ID})
+}
+
+// hideSecrets clears the secrets of webhooks, they're only returned on creation and rotation.
+func hideSecrets(webHooks []models.Webhook) {
+	for i := range webHooks {
+		webHooks[i].Secret = ""
+	}
+}
+
+// deliveryError writes the response of a failed delivery, pointing to its record.
+func deliveryError(ctx *gin.Context, delivery models.WebhookDelivery, err error) {
+	var validationErr *helpers.SchemaValidationError
+	var mappingErr *services.WebhookMappingError
+	switch {
+	case errors.As(err, &validationErr):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "errors": validationErr.Errors, "delivery": delivery.ID})
+	case errors.As(err, &mappingErr):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "delivery": delivery.ID})
+	default:
+		ctx.JSON(maintenanceStatus(err), gin.H{"error": err.Error(), "delivery": delivery.ID})
+	}
 }
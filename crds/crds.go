@@ -0,0 +1,8 @@
+// Package crds contains the CustomResourceDefinitions used by Kriten to store
+// runners, tasks and cronjobs, and to declare webhooks and role bindings.
+package crds
+
+import "embed"
+
+//go:embed *.yaml
+var Manifests embed.FS
@@ -0,0 +1,315 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/crds"
+	"github.com/kriten-io/kriten/models"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KritenGroup      = "kriten.io"
+	KritenVersion    = "v1alpha1"
+	KritenAPIVersion = KritenGroup + "/" + KritenVersion
+	KindCronJob      = "cronjob"
+	KindWebhook      = "webhook"
+	KindRoleBinding  = "rolebinding"
+	LabelRunner      = "kriten.io/runner"
+	LabelTask        = "kriten.io/task"
+	// LabelTaskRevision is set on tasks and on the jobs created from them
+	LabelTaskRevision = "kriten.io/task-revision"
+	// LabelCronJob is set on the jobs spawned by a cronjob
+	LabelCronJob = "kriten.io/cronjob"
+	// LabelSource marks tasks created from the kriten.yaml manifest of their runner
+	LabelSource    = "kriten.io/source"
+	SourceManifest = "manifest"
+	// AnnotationSpec holds the KritenCronJob spec a CronJob was built from
+	AnnotationSpec = "kriten.io/spec"
+	// Time the API server is given to establish a CRD
+	crdEstablishTimeout = time.Minute
+)
+
+// Fields of models.CronJob read from the CronJob, not part of the resource spec
+var cronJobStatusFields = []string{"next_runs", "last_schedule_time", "last_successful_time", "active", "drift", "blocked_by"}
+
+// CronJobSpec returns the canonical spec of a cronjob, stored in its resource.
+func CronJobSpec(cronjob models.CronJob) (map[string]interface{}, error) {
+	return ResourceSpec(cronjob, cronJobStatusFields...)
+}
+
+var (
+	RunnerResource  = schema.GroupVersionResource{Group: KritenGroup, Version: KritenVersion, Resource: "runners"}
+	TaskResource    = schema.GroupVersionResource{Group: KritenGroup, Version: KritenVersion, Resource: "tasks"}
+	CronJobResource = schema.GroupVersionResource{Group: KritenGroup, Version: KritenVersion, Resource: "kritencronjobs"}
+	WebhookResource = schema.GroupVersionResource{Group: KritenGroup, Version: KritenVersion, Resource: "kritenwebhooks"}
+	BindingResource = schema.GroupVersionResource{Group: KritenGroup, Version: KritenVersion, Resource: "kritenrolebindings"}
+
+	crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+)
+
+// ResourceKinds maps a Kriten kind to its CustomResource
+var ResourceKinds = map[string]struct {
+	Kind     string
+	Resource schema.GroupVersionResource
+}{
+	KindRunner:      {"Runner", RunnerResource},
+	KindTask:        {"Task", TaskResource},
+	KindCronJob:     {"KritenCronJob", CronJobResource},
+	KindWebhook:     {"KritenWebhook", WebhookResource},
+	KindRoleBinding: {"KritenRoleBinding", BindingResource},
+}
+
+// InstallCRDs creates or updates the Kriten CustomResourceDefinitions.
+func InstallCRDs(kube config.KubeConfig) error {
+	files, err := fs.Glob(crds.Manifests, "*.yaml")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := crds.Manifests.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var obj map[string]interface{}
+		err = yaml.Unmarshal(data, &obj)
+		if err != nil {
+			return err
+		}
+		crd := &unstructured.Unstructured{Object: obj}
+
+		current, err := kube.DynamicClient.Resource(crdResource).Get(context.TODO(), crd.GetName(), metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			_, err = kube.DynamicClient.Resource(crdResource).Create(context.TODO(), crd, metav1.CreateOptions{})
+		} else if err == nil {
+			crd.SetResourceVersion(current.GetResourceVersion())
+			_, err = kube.DynamicClient.Resource(crdResource).Update(context.TODO(), crd, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Installed CRD %s\n", crd.GetName())
+	}
+
+	return nil
+}
+
+// WaitForCRD waits for the CustomResourceDefinition of a kind to be established, resources
+// of a definition just installed can't be created before.
+func WaitForCRD(kube config.KubeConfig, kind string) error {
+	resource := ResourceKinds[kind].Resource
+	name := resource.Resource + "." + resource.Group
+
+	err := wait.PollUntilContextTimeout(context.TODO(), time.Second, crdEstablishTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			crd, err := kube.DynamicClient.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+			if kerrors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+
+			conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+			for _, condition := range conditions {
+				condition, _ := condition.(map[string]interface{})
+				if condition["type"] == "Established" && condition["status"] == "True" {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+	if err != nil {
+		return fmt.Errorf("CRD %s not established: %w", name, err)
+	}
+
+	return nil
+}
+
+func ListResources(kube config.KubeConfig, kind string, labelSelector string) ([]unstructured.Unstructured, error) {
+	list, err := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(
+		kube.Namespace).List(
+		context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+func GetResource(kube config.KubeConfig, kind string, name string) (*unstructured.Unstructured, error) {
+	obj, err := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(
+		kube.Namespace).Get(
+		context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// CreateOrUpdateResource writes the spec of a Kriten resource, operations permitted: "create" and "update".
+func CreateOrUpdateResource(kube config.KubeConfig, kind string, name string, spec map[string]interface{},
+	labels map[string]string, operation string) (*unstructured.Unstructured, error) {
+	client := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(kube.Namespace)
+	var obj *unstructured.Unstructured
+	var err error
+
+	if operation == "update" {
+		obj, err = client.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetAPIVersion(KritenAPIVersion)
+		obj.SetKind(ResourceKinds[kind].Kind)
+		obj.SetName(name)
+		obj.SetNamespace(kube.Namespace)
+	}
+
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+	for k, v := range KritenLabels(kind) {
+		objLabels[k] = v
+	}
+	for k, v := range labels {
+		objLabels[k] = v
+	}
+	obj.SetLabels(objLabels)
+	obj.Object["spec"] = spec
+
+	if operation == "update" {
+		obj, err = client.Update(context.TODO(), obj, metav1.UpdateOptions{})
+	} else {
+		obj, err = client.Create(context.TODO(), obj, metav1.CreateOptions{})
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// UpdateResource writes back an object retrieved from the cluster, e.g. after changing its finalizers.
+func UpdateResource(kube config.KubeConfig, kind string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	obj, err := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(
+		kube.Namespace).Update(
+		context.TODO(), obj, metav1.UpdateOptions{})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func DeleteResource(kube config.KubeConfig, kind string, name string) error {
+	err := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(
+		kube.Namespace).Delete(
+		context.TODO(), name, metav1.DeleteOptions{})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateResourceStatus sets the phase and message in the status subresource.
+func UpdateResourceStatus(kube config.KubeConfig, kind string, obj *unstructured.Unstructured,
+	phase string, message string) error {
+	status, _, _ := unstructured.NestedMap(obj.Object, "status")
+	if status == nil {
+		status = map[string]interface{}{}
+	}
+	status["phase"] = phase
+	status["message"] = message
+	status["observedGeneration"] = obj.GetGeneration()
+	obj.Object["status"] = status
+
+	_, err := kube.DynamicClient.Resource(ResourceKinds[kind].Resource).Namespace(
+		kube.Namespace).UpdateStatus(
+		context.TODO(), obj, metav1.UpdateOptions{})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// ResourceSpec converts a model into a resource spec, omitting fields that aren't part of it.
+func ResourceSpec(model interface{}, omit ...string) (map[string]interface{}, error) {
+	var spec map[string]interface{}
+
+	b, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(spec, "name")
+	for _, field := range omit {
+		delete(spec, field)
+	}
+
+	return spec, nil
+}
+
+// ResourceToModel converts a resource spec into a model, the resource name is set as "name".
+func ResourceToModel(obj *unstructured.Unstructured, model interface{}) error {
+	spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	spec["name"] = obj.GetName()
+
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, model)
+}
+
+// OwnerReference returns a reference to a Kriten resource, used to garbage collect dependent objects.
+func OwnerReference(kind string, obj *unstructured.Unstructured) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: KritenAPIVersion,
+		Kind:       ResourceKinds[kind].Kind,
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+	}
+}
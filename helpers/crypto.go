@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	passphraseSaltSize = 16
+	passphraseKeySize  = 32
+)
+
+// EncryptWithPassphrase seals data with AES-256-GCM, using a key derived from the passphrase with scrypt.
+// The result is base64 encoded and contains salt, nonce and ciphertext.
+func EncryptWithPassphrase(data []byte, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("passphrase cannot be empty")
+	}
+
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(salt, nonce...)
+	sealed = gcm.Seal(sealed, nonce, data, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptWithPassphrase opens data sealed by EncryptWithPassphrase.
+func DecryptWithPassphrase(encoded string, passphrase string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < passphraseSaltSize {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	salt := sealed[:passphraseSaltSize]
+	gcm, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[passphraseSaltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	data, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt data, please check the passphrase")
+	}
+
+	return data, nil
+}
+
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, passphraseKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
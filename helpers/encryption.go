@@ -0,0 +1,272 @@
+package helpers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/kriten-io/kriten/config"
+
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm/schema"
+)
+
+// Prefix of encrypted values, the ones without it are plain text stored before encryption was enabled
+const encryptedPrefix = "enc:"
+
+const encryptionKeySize = 32
+
+// Keyring holds the key encryption keys by version. Values are sealed with a random data key,
+// which is sealed with the active key and stored along with them.
+type Keyring struct {
+	Active string
+	Keys   map[string][]byte
+}
+
+// keyring used by the encrypted serializer, nil when encryption isn't configured
+var keyring *Keyring
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// LoadKeyring reads the encryption keys from the env and from a Kubernetes Secret, where every
+// data key is a version. The active key defaults to the latest version.
+func LoadKeyring(conf config.Config) (*Keyring, error) {
+	keys := map[string][]byte{}
+
+	for _, entry := range strings.Split(conf.Encryption.Keys, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		version, encoded, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid encryption key %s, expected version:key", version)
+		}
+		key, err := decodeEncryptionKey([]byte(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", version, err)
+		}
+		keys[version] = key
+	}
+
+	if conf.Encryption.KeysSecret != "" {
+		secret, err := GetSecret(conf.Kube, conf.Encryption.KeysSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption keys: %w", err)
+		}
+		for version, data := range secret.Data {
+			key, err := decodeEncryptionKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("encryption key %s: %w", version, err)
+			}
+			keys[version] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	active := conf.Encryption.ActiveKey
+	if active == "" {
+		versions := make([]string, 0, len(keys))
+		for version := range keys {
+			versions = append(versions, version)
+		}
+		// versions are compared as numbers when they are, "10" comes after "9"
+		slices.SortFunc(versions, func(a, b string) int {
+			if len(a) != len(b) {
+				return len(a) - len(b)
+			}
+			return strings.Compare(a, b)
+		})
+		active = versions[len(versions)-1]
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active encryption key %s not found", active)
+	}
+
+	return &Keyring{Active: active, Keys: keys}, nil
+}
+
+// SetKeyring sets the keys used to encrypt sensitive columns, values are stored in plain text without them.
+func SetKeyring(k *Keyring) {
+	if k == nil {
+		log.Println("No encryption keys configured, sensitive columns are stored in plain text")
+	}
+	keyring = k
+}
+
+// ActiveKeyVersion returns the version of the key new values are encrypted with.
+func ActiveKeyVersion() string {
+	if keyring == nil {
+		return ""
+	}
+	return keyring.Active
+}
+
+// KeyVersion returns the version of the key a value was encrypted with, empty for plain text.
+func KeyVersion(value string) string {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return ""
+	}
+	version, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return version
+}
+
+// EncryptValue seals a value with a new data key, formatted as enc:version:sealed key:sealed value.
+func EncryptValue(value string) (string, error) {
+	if keyring == nil || value == "" {
+		return value, nil
+	}
+
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(keyring.Keys[keyring.Active], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + keyring.Active + ":" +
+		base64.StdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.StdEncoding.EncodeToString(sealedValue), nil
+}
+
+// DecryptValue opens a value sealed by EncryptValue, plain text values are returned as they are.
+func DecryptValue(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("invalid encrypted value")
+	}
+	if keyring == nil {
+		return "", errors.New("value is encrypted but no encryption keys are configured")
+	}
+	key, ok := keyring.Keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("encryption key %s not found", parts[0])
+	}
+
+	sealedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(key, sealedKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := open(dataKey, sealedValue)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// EncryptedSerializer encrypts string columns tagged with `gorm:"serializer:encrypted"`.
+type EncryptedSerializer struct{}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("unsupported encrypted value of type %T", dbValue)
+	}
+
+	value, err := DecryptValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field.DBName, err)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (EncryptedSerializer) Value(_ context.Context, _ *schema.Field, _ reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("unsupported encrypted value of type %T", fieldValue)
+	}
+	return EncryptValue(value)
+}
+
+// decodeEncryptionKey accepts raw 32 bytes keys or their base64 encoding.
+func decodeEncryptionKey(data []byte) ([]byte, error) {
+	if len(data) == encryptionKeySize {
+		return data, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("keys must be %d bytes, raw or base64 encoded", encryptionKeySize)
+	}
+	return key, nil
+}
+
+func seal(key []byte, data []byte) ([]byte, error) {
+	gcm, err := keyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := keyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	data, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt data, please check the encryption keys")
+	}
+	return data, nil
+}
+
+func keyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
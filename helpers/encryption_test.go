@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, encryptionKeySize)
+	testKey2 = bytes.Repeat([]byte{2}, encryptionKeySize)
+)
+
+// useKeyring sets the keyring for the duration of a test.
+func useKeyring(t *testing.T, active string, keys map[string][]byte) {
+	t.Helper()
+	previous := keyring
+	keyring = &Keyring{Active: active, Keys: keys}
+	t.Cleanup(func() { keyring = previous })
+}
+
+func TestLoadKeyring(t *testing.T) {
+	encoded1 := base64.StdEncoding.EncodeToString(testKey1)
+	encoded2 := base64.StdEncoding.EncodeToString(testKey2)
+
+	tests := []struct {
+		name   string
+		conf   config.EncryptionConfig
+		active string
+		valid  bool
+	}{
+		{"no keys", config.EncryptionConfig{}, "", true},
+		{"latest version", config.EncryptionConfig{Keys: "9:" + encoded1 + ", 10:" + encoded2}, "10", true},
+		{"configured version", config.EncryptionConfig{Keys: "1:" + encoded1 + ",2:" + encoded2, ActiveKey: "1"}, "1", true},
+		{"unknown active version", config.EncryptionConfig{Keys: "1:" + encoded1, ActiveKey: "2"}, "", false},
+		{"missing version", config.EncryptionConfig{Keys: encoded1}, "", false},
+		{"short key", config.EncryptionConfig{Keys: "1:" + base64.StdEncoding.EncodeToString([]byte("short"))}, "", false},
+	}
+
+	for _, test := range tests {
+		k, err := LoadKeyring(config.Config{Encryption: test.conf})
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+		if err != nil {
+			continue
+		}
+		if test.active == "" && k != nil {
+			t.Errorf("%s: expected no keyring, got %+v", test.name, k)
+		}
+		if test.active != "" && (k == nil || k.Active != test.active) {
+			t.Errorf("%s: expected active key %q, got %+v", test.name, test.active, k)
+		}
+	}
+}
+
+func TestLoadKeyringSecret(t *testing.T) {
+	kube, client := fakeKube()
+	_, err := client.CoreV1().Secrets("kriten").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kriten-encryption"},
+		Data: map[string][]byte{
+			"1": testKey1,
+			"2": []byte(base64.StdEncoding.EncodeToString(testKey2)),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := LoadKeyring(config.Config{Kube: kube, Encryption: config.EncryptionConfig{KeysSecret: "kriten-encryption"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Active != "2" || !bytes.Equal(k.Keys["1"], testKey1) || !bytes.Equal(k.Keys["2"], testKey2) {
+		t.Errorf("unexpected keyring %+v", k)
+	}
+}
+
+func TestEncryptAcrossKeyVersions(t *testing.T) {
+	useKeyring(t, "1", map[string][]byte{"1": testKey1})
+	old, err := EncryptValue("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyVersion(old) != "1" || strings.Contains(old, "s3cret") {
+		t.Fatalf("unexpected encrypted value %s", old)
+	}
+
+	// Key 2 is added and made active, values encrypted with key 1 can still be read
+	useKeyring(t, "2", map[string][]byte{"1": testKey1, "2": testKey2})
+	current, err := EncryptValue("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyVersion(current) != "2" {
+		t.Errorf("expected the active key to be used, got %s", KeyVersion(current))
+	}
+	for _, value := range []string{old, current} {
+		if decrypted, err := DecryptValue(value); err != nil || decrypted != "s3cret" {
+			t.Errorf("%s: unexpected value %q, %v", value, decrypted, err)
+		}
+	}
+
+	// Once key 1 is removed its values can't be read anymore
+	useKeyring(t, "2", map[string][]byte{"2": testKey2})
+	if _, err := DecryptValue(old); err == nil {
+		t.Error("expected a value of a removed key to fail")
+	}
+
+	// A key can't be replaced under the same version
+	useKeyring(t, "2", map[string][]byte{"2": testKey1})
+	if _, err := DecryptValue(current); err == nil {
+		t.Error("expected a value decrypted with another key to fail")
+	}
+}
+
+func TestDecryptValue(t *testing.T) {
+	useKeyring(t, "1", map[string][]byte{"1": testKey1})
+	encrypted, _ := EncryptValue("s3cret")
+	parts := strings.Split(encrypted, ":")
+	tampered := strings.Join(append(parts[:3], base64.StdEncoding.EncodeToString([]byte("tampered value of 28 bytes.."))), ":")
+
+	tests := []struct {
+		value string
+		plain string
+		valid bool
+	}{
+		{"s3cret", "s3cret", true},
+		{"", "", true},
+		{encrypted, "s3cret", true},
+		{tampered, "", false},
+		{"enc:1:abc", "", false},
+		{"enc:1:!!:!!", "", false},
+	}
+
+	for _, test := range tests {
+		plain, err := DecryptValue(test.value)
+		if (err == nil) != test.valid || plain != test.plain {
+			t.Errorf("%s: expected %q and valid %v, got %q, %v", test.value, test.plain, test.valid, plain, err)
+		}
+	}
+
+	keyring = nil
+	if _, err := DecryptValue(encrypted); err == nil {
+		t.Error("expected an encrypted value to fail without keys")
+	}
+	if value, _ := EncryptValue("s3cret"); value != "s3cret" {
+		t.Errorf("expected plain text without keys, got %s", value)
+	}
+}
+
+type encryptedRecord struct {
+	ID     uint
+	Secret string `gorm:"serializer:encrypted"`
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+	if err := db.AutoMigrate(&encryptedRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored before encryption was enabled
+	db.Create(&encryptedRecord{ID: 1, Secret: "plain"})
+
+	useKeyring(t, "1", map[string][]byte{"1": testKey1})
+	db.Create(&encryptedRecord{ID: 2, Secret: "s3cret"})
+
+	var stored string
+	db.Raw("SELECT secret FROM encrypted_records WHERE id = 2").Scan(&stored)
+	if KeyVersion(stored) != "1" || strings.Contains(stored, "s3cret") {
+		t.Errorf("value stored as %s", stored)
+	}
+
+	useKeyring(t, "2", map[string][]byte{"1": testKey1, "2": testKey2})
+	var records []encryptedRecord
+	if err := db.Order("id").Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Secret != "plain" || records[1].Secret != "s3cret" {
+		t.Errorf("unexpected records %+v", records)
+	}
+
+	// Saving a record again encrypts it with the active key
+	db.Save(&records[1])
+	db.Raw("SELECT secret FROM encrypted_records WHERE id = 2").Scan(&stored)
+	if KeyVersion(stored) != "2" {
+		t.Errorf("expected the value to be encrypted with key 2, got %s", stored)
+	}
+}
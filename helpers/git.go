@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/exp/slices"
+)
+
+// gitCloneTimeout bounds the clone of a repository, so an unresponsive server doesn't block syncs.
+const gitCloneTimeout = 2 * time.Minute
+
+// gitSchemes are the schemes repositories are cloned from, local transports such as file:// aren't allowed.
+var gitSchemes = []string{"https", "http", "ssh"}
+
+// ReadGitFile clones the branch of a repository in memory and returns the content
+// of a file in its latest commit, together with the commit hash.
+// Credentials can be part of the URL, the same way they are passed to jobs.
+func ReadGitFile(repoURL string, branch string, path string) ([]byte, string, error) {
+	endpoint, err := url.Parse(repoURL)
+	if err != nil || !slices.Contains(gitSchemes, strings.ToLower(endpoint.Scheme)) || endpoint.Host == "" {
+		return nil, "", errors.New("invalid git url, an https, http or ssh url is expected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitCloneTimeout)
+	defer cancel()
+
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           repoURL,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to clone branch %s: %w", branch, redactCredentials(err, endpoint))
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, "", err
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, head.Hash().String(), fmt.Errorf("%s not found in branch %s: %w", path, branch, err)
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, head.Hash().String(), err
+	}
+
+	return []byte(content), head.Hash().String(), nil
+}
+
+// redactCredentials removes the credentials of a URL from an error, it's returned to API clients.
+func redactCredentials(err error, endpoint *url.URL) error {
+	if endpoint.User == nil {
+		return err
+	}
+
+	message := err.Error()
+	secrets := []string{endpoint.User.String(), endpoint.User.Username()}
+	if password, ok := endpoint.User.Password(); ok {
+		secrets = append(secrets, password)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, "xxxxx")
+		}
+	}
+
+	return errors.New(message)
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/kriten-io/kriten/config"
 	"github.com/kriten-io/kriten/models"
@@ -16,6 +18,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
@@ -23,6 +26,26 @@ const (
 	k8sConfigMapValidationError = "invalid name '%s', should be lowercase alphanumeric characters or '-' and '.'"
 )
 
+// Labels set on every Kubernetes object managed by Kriten
+const (
+	LabelKind      = "kriten.io/kind"
+	LabelManagedBy = "kriten.io/managed-by"
+	ManagedBy      = "kriten"
+	KindRunner     = "runner"
+	KindTask       = "task"
+)
+
+func KritenLabels(kind string) map[string]string {
+	return map[string]string{
+		LabelKind:      kind,
+		LabelManagedBy: ManagedBy,
+	}
+}
+
+func KindSelector(kind string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", LabelKind, kind, LabelManagedBy, ManagedBy)
+}
+
 func ValidateK8sConfigMapName(name string) error {
 	// Check if the name matches the regex
 	matched, err := regexp.MatchString(k8sConfigMapRegexValidation, name)
@@ -35,52 +58,32 @@ func ValidateK8sConfigMapName(name string) error {
 	return nil
 }
 
-func ListConfigMaps(kube config.KubeConfig) (*corev1.ConfigMapList, error) {
-	configMaps, err := kube.Clientset.CoreV1().ConfigMaps(
-		kube.Namespace).List(
-		context.TODO(), metav1.ListOptions{})
-
-	if err != nil {
-		log.Println(err)
+// ParseGVR reads a resource written as apiVersion/resource, e.g. v1/nodes or apps/v1/deployments.
+func ParseGVR(resource string) (schema.GroupVersionResource, error) {
+	i := strings.LastIndex(resource, "/")
+	if i < 0 || i == len(resource)-1 {
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid resource '%s', should be apiVersion/resource", resource)
 	}
 
-	return configMaps, err
-}
-
-func GetConfigMap(kube config.KubeConfig, name string) (*corev1.ConfigMap, error) {
-	configMap, err := kube.Clientset.CoreV1().ConfigMaps(
-		kube.Namespace).Get(
-		context.TODO(), name, metav1.GetOptions{})
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	gv, err := schema.ParseGroupVersion(resource[:i])
+	if err != nil || gv.Version == "" || gv.String() != resource[:i] {
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid resource '%s', should be apiVersion/resource", resource)
 	}
 
-	return configMap, nil
+	return gv.WithResource(resource[i+1:]), nil
 }
 
-func CreateOrUpdateConfigMap(kube config.KubeConfig, data map[string]string, operation string) (*corev1.ConfigMap, error) {
-	configMap := ConfigMap(data, kube.Namespace)
-	var ret *corev1.ConfigMap
-	var err error
-
-	// Operations permetter : "create" and "update"
-	if operation == "create" {
-		ret, err = kube.Clientset.CoreV1().ConfigMaps(
-			kube.Namespace).Create(
-			context.TODO(), configMap, metav1.CreateOptions{})
-	} else if operation == "update" {
-		ret, err = kube.Clientset.CoreV1().ConfigMaps(
-			kube.Namespace).Update(
-			context.TODO(), configMap, metav1.UpdateOptions{})
-	}
+// ListConfigMaps returns the ConfigMaps of the given kind, e.g. runners or tasks.
+func ListConfigMaps(kube config.KubeConfig, kind string) (*corev1.ConfigMapList, error) {
+	configMaps, err := kube.Clientset.CoreV1().ConfigMaps(
+		kube.Namespace).List(
+		context.TODO(), metav1.ListOptions{LabelSelector: KindSelector(kind)})
 
 	if err != nil {
-		return nil, err
+		log.Println(err)
 	}
 
-	return ret, nil
+	return configMaps, err
 }
 
 func DeleteConfigMap(kube config.KubeConfig, name string) error {
@@ -96,19 +99,6 @@ func DeleteConfigMap(kube config.KubeConfig, name string) error {
 	return nil
 }
 
-func ConfigMap(data map[string]string, namespace string) *corev1.ConfigMap {
-	name := data["name"]
-
-	return &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
-		Data: data,
-	}
-
-}
-
 func GetSecret(kube config.KubeConfig, secretName string) (*corev1.Secret, error) {
 	secret, err := kube.Clientset.CoreV1().Secrets(
 		kube.Namespace).Get(
@@ -215,22 +205,78 @@ func GetJob(kube config.KubeConfig, name string) (*batchv1.Job, error) {
 }
 
 // TODO: Too many arguments, will need a rework
+// secretData is only set when the runner secrets come from an external provider, the values
+// are then stored in a Secret owned by the Job, which is garbage collected together with it.
+// labels are added to the Job and its Pods, next to owner and task-name.
 func CreateJob(kube config.KubeConfig, name string, runnerName string, runnerImage string, owner string,
-	extraVars string, command string, gitURL string, gitBranch string) (string, error) {
-	job := JobObject(name, kube, runnerName, runnerImage, owner, extraVars, command, gitURL, gitBranch)
+	extraVars string, command string, gitURL string, gitBranch string, secretData map[string]string,
+	labels map[string]string) (string, error) {
+	var jobSecret *corev1.Secret
+	var err error
+	secretName := runnerName
 
-	job, err := kube.Clientset.BatchV1().Jobs(
+	if secretData != nil {
+		jobSecret, err = kube.Clientset.CoreV1().Secrets(
+			kube.Namespace).Create(
+			context.TODO(), JobSecret(name, kube.Namespace, secretData), metav1.CreateOptions{})
+		if err != nil {
+			log.Println(err)
+			return "", err
+		}
+		secretName = jobSecret.Name
+	}
+
+	job := JobObject(name, kube, secretName, runnerImage, owner, extraVars, command, gitURL, gitBranch, labels)
+
+	job, err = kube.Clientset.BatchV1().Jobs(
 		kube.Namespace).Create(
 		context.TODO(), job, metav1.CreateOptions{})
 
 	if err != nil {
 		log.Println(err)
+		if jobSecret != nil {
+			_ = DeleteSecret(kube, jobSecret.Name)
+		}
 		return "", err
 	}
 
+	if jobSecret != nil {
+		jobSecret.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       job.Name,
+				UID:        job.UID,
+			},
+		}
+		_, err = kube.Clientset.CoreV1().Secrets(
+			kube.Namespace).Update(
+			context.TODO(), jobSecret, metav1.UpdateOptions{})
+		// The secret would outlive the job without its owner, so the job isn't run
+		if err != nil {
+			log.Printf("failed to set owner of job secret %s: %v\n", jobSecret.Name, err)
+			background := metav1.DeletePropagationBackground
+			_ = kube.Clientset.BatchV1().Jobs(
+				kube.Namespace).Delete(
+				context.TODO(), job.Name, metav1.DeleteOptions{PropagationPolicy: &background})
+			_ = DeleteSecret(kube, jobSecret.Name)
+			return "", err
+		}
+	}
+
 	return job.Name, nil
 }
 
+func JobSecret(name string, namespace string, data map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: name + "-secret-",
+			Namespace:    namespace,
+		},
+		StringData: data,
+	}
+}
+
 func ListPods(kube config.KubeConfig, labelSelector string) (*corev1.PodList, error) {
 	pods, err := kube.Clientset.CoreV1().Pods(
 		kube.Namespace).List(context.TODO(),
@@ -271,12 +317,13 @@ func GetLogs(kube config.KubeConfig, podName string, containerName string) (stri
 
 func JobObject(name string,
 	kube config.KubeConfig,
-	runnerName string,
+	secretName string,
 	image string, owner string,
 	extraVars string,
 	command string,
 	gitURL string,
-	gitBranch string) *batchv1.Job {
+	gitBranch string,
+	labels map[string]string) *batchv1.Job {
 
 	var ttlSeconds = int32(kube.JobsTTL)
 	var backoffLimit int32 = 1
@@ -295,20 +342,26 @@ func JobObject(name string,
 		})
 	}
 
+	podLabels := map[string]string{
+		"owner":     owner,
+		"task-name": name,
+	}
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+
 	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: name + "-",
 			Namespace:    kube.Namespace,
+			Labels:       podLabels,
 		},
 		Spec: batchv1.JobSpec{
 			TTLSecondsAfterFinished: &ttlSeconds,
 			BackoffLimit:            &backoffLimit,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"owner":     owner,
-						"task-name": name,
-					},
+					Labels: podLabels,
 				},
 				Spec: corev1.PodSpec{
 					Volumes: []corev1.Volume{
@@ -316,7 +369,7 @@ func JobObject(name string,
 							Name: "secret",
 							VolumeSource: corev1.VolumeSource{
 								Secret: &corev1.SecretVolumeSource{
-									SecretName: runnerName,
+									SecretName: secretName,
 									Optional:   &optionalSecret,
 								},
 							},
@@ -357,7 +410,7 @@ func JobObject(name string,
 								{
 									SecretRef: &corev1.SecretEnvSource{
 										LocalObjectReference: corev1.LocalObjectReference{
-											Name: runnerName,
+											Name: secretName,
 										},
 										Optional: &optionalSecret,
 									},
@@ -435,7 +488,8 @@ func GetCronJob(kube config.KubeConfig, name string) (*batchv1.CronJob, error) {
 	return job, nil
 }
 
-func CreateOrUpdateCronJob(kube config.KubeConfig, cronjob models.CronJob, runner *corev1.ConfigMap, command string, operation string) (*batchv1.CronJob, error) {
+func CreateOrUpdateCronJob(kube config.KubeConfig, cronjob models.CronJob, runner *models.Runner, command string,
+	labels map[string]string, owner metav1.OwnerReference, operation string) (*batchv1.CronJob, error) {
 	var extraVars string
 	var err error
 
@@ -449,15 +503,40 @@ func CreateOrUpdateCronJob(kube config.KubeConfig, cronjob models.CronJob, runne
 
 	jobObj := JobObject(cronjob.Task,
 		kube,
-		runner.Data["name"],
-		runner.Data["image"],
+		runner.Name,
+		runner.Image,
 		cronjob.Owner,
 		extraVars,
 		command,
-		runner.Data["gitURL"],
-		runner.Data["branch"],
+		runner.GitURL,
+		runner.Branch,
+		labels,
 	)
-	cron := CronJobObject(kube, cronjob, jobObj.Spec)
+	cron := CronJobObject(kube, cronjob, jobObj.Labels, jobObj.Spec)
+	cron.OwnerReferences = []metav1.OwnerReference{owner}
+
+	spec, err := CronJobSpec(cronjob)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	cron.Annotations = map[string]string{}
+
+	if operation == "update" {
+		// Annotations set by others are kept, the spec is written with the CronJob in a single request
+		current, err := GetCronJob(kube, cron.Name)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range current.Annotations {
+			cron.Annotations[k] = v
+		}
+		cron.ResourceVersion = current.ResourceVersion
+	}
+	cron.Annotations[AnnotationSpec] = string(canonical)
 
 	if operation == "create" {
 		cron, err = kube.Clientset.BatchV1().CronJobs(
@@ -477,6 +556,53 @@ func CreateOrUpdateCronJob(kube config.KubeConfig, cronjob models.CronJob, runne
 	return cron, nil
 }
 
+// CronJobDrifted tells whether a CronJob was changed since Kriten last wrote it, comparing its spec
+// with the cronjob spec it was built from. Fields defaulted by Kubernetes are only compared when set.
+func CronJobDrifted(cron *batchv1.CronJob) bool {
+	var cronjob models.CronJob
+	if err := json.Unmarshal([]byte(cron.Annotations[AnnotationSpec]), &cronjob); err != nil {
+		return true
+	}
+
+	spec := cron.Spec
+	policy := batchv1.ConcurrencyPolicy(cronjob.ConcurrencyPolicy)
+	if policy == "" {
+		policy = batchv1.AllowConcurrent
+	}
+	if spec.Schedule != cronjob.Schedule || spec.ConcurrencyPolicy != policy ||
+		(spec.TimeZone == nil) != (cronjob.TimeZone == "") ||
+		spec.TimeZone != nil && *spec.TimeZone != cronjob.TimeZone ||
+		!equalPtr(spec.StartingDeadlineSeconds, cronjob.StartingDeadlineSeconds) ||
+		cronjob.SuccessfulJobsHistoryLimit != nil && !equalPtr(spec.SuccessfulJobsHistoryLimit, cronjob.SuccessfulJobsHistoryLimit) ||
+		cronjob.FailedJobsHistoryLimit != nil && !equalPtr(spec.FailedJobsHistoryLimit, cronjob.FailedJobsHistoryLimit) {
+		return true
+	}
+	// Maintenance windows suspend cronjobs as well, only a disabled one resumed is a change
+	if cronjob.Disable && (spec.Suspend == nil || !*spec.Suspend) {
+		return true
+	}
+
+	containers := spec.JobTemplate.Spec.Template.Spec.Containers
+	if len(containers) != 1 || containers[0].Name != cronjob.Task {
+		return true
+	}
+	var extraVars map[string]interface{}
+	for _, env := range containers[0].Env {
+		if env.Name == "EXTRA_VARS" && json.Unmarshal([]byte(env.Value), &extraVars) != nil {
+			return true
+		}
+	}
+	if len(extraVars) != 0 || len(cronjob.ExtraVars) != 0 {
+		return !reflect.DeepEqual(extraVars, cronjob.ExtraVars)
+	}
+
+	return false
+}
+
+func equalPtr[T comparable](a *T, b *T) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
 func DeleteCronJob(kube config.KubeConfig, name string) error {
 	err := kube.Clientset.BatchV1().CronJobs(
 		kube.Namespace).Delete(
@@ -490,16 +616,69 @@ func DeleteCronJob(kube config.KubeConfig, name string) error {
 	return nil
 }
 
-func CronJobObject(kube config.KubeConfig, cronjob models.CronJob, jobSpec batchv1.JobSpec) *batchv1.CronJob {
+// RunCronJob creates a Job from the template of a CronJob straight away, the same way
+// "kubectl create job --from=cronjob/<name>" does.
+func RunCronJob(kube config.KubeConfig, name string) (string, error) {
+	cron, err := GetCronJob(kube, name)
+	if err != nil {
+		return "", err
+	}
+
+	annotations := map[string]string{"cronjob.kubernetes.io/instantiate": "manual"}
+	for k, v := range cron.Spec.JobTemplate.Annotations {
+		annotations[k] = v
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: name + "-manual-",
+			Namespace:    kube.Namespace,
+			Labels:       cron.Spec.JobTemplate.Labels,
+			Annotations:  annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cron, batchv1.SchemeGroupVersion.WithKind("CronJob")),
+			},
+		},
+		Spec: cron.Spec.JobTemplate.Spec,
+	}
+
+	job, err = kube.Clientset.BatchV1().Jobs(
+		kube.Namespace).Create(
+		context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	return job.Name, nil
+}
+
+func CronJobObject(kube config.KubeConfig, cronjob models.CronJob, jobLabels map[string]string,
+	jobSpec batchv1.JobSpec) *batchv1.CronJob {
+	var timeZone *string
+	if cronjob.TimeZone != "" {
+		timeZone = &cronjob.TimeZone
+	}
+	// Suspended as well while maintenance windows block it
+	suspend := cronjob.Disable || cronjob.BlockedBy != ""
+
 	return &batchv1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cronjob.Name,
 			Namespace: kube.Namespace,
 		},
 		Spec: batchv1.CronJobSpec{
-			Schedule: cronjob.Schedule,
-			Suspend:  &cronjob.Disable,
+			Schedule:                   cronjob.Schedule,
+			TimeZone:                   timeZone,
+			Suspend:                    &suspend,
+			ConcurrencyPolicy:          batchv1.ConcurrencyPolicy(cronjob.ConcurrencyPolicy),
+			StartingDeadlineSeconds:    cronjob.StartingDeadlineSeconds,
+			SuccessfulJobsHistoryLimit: cronjob.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     cronjob.FailedJobsHistoryLimit,
 			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels,
+				},
 				Spec: jobSpec,
 			},
 		},
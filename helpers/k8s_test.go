@@ -0,0 +1,139 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeKube returns a fake clientset generating names and UIDs like the API server does.
+func fakeKube() (config.KubeConfig, *fake.Clientset) {
+	client := fake.NewClientset()
+	generated := 0
+	client.PrependReactor("create", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, ok := action.(k8stesting.CreateAction).GetObject().(metav1.Object)
+		if !ok {
+			return false, nil, nil
+		}
+		generated++
+		if obj.GetName() == "" && obj.GetGenerateName() != "" {
+			obj.SetName(fmt.Sprintf("%s%05d", obj.GetGenerateName(), generated))
+		}
+		obj.SetUID(types.UID(fmt.Sprintf("uid-%d", generated)))
+		return false, nil, nil
+	})
+
+	return config.KubeConfig{Clientset: client, Namespace: "kriten", JobsTTL: 3600}, client
+}
+
+func createTestJob(kube config.KubeConfig, secretData map[string]string) (string, error) {
+	return CreateJob(kube, "backup", "ansible", "kriten/ansible:latest", "admin", `{"host": "db1"}`,
+		"ansible-playbook backup.yml", "https://github.com/kriten-io/playbooks.git", "main", secretData, nil)
+}
+
+func TestCreateJobWithSecret(t *testing.T) {
+	kube, client := fakeKube()
+
+	name, err := createTestJob(kube, map[string]string{"password": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := client.BatchV1().Jobs("kriten").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, _ := client.CoreV1().Secrets("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(secrets.Items) != 1 {
+		t.Fatalf("expected 1 job secret, found %d", len(secrets.Items))
+	}
+	secret := secrets.Items[0]
+
+	if secret.StringData["password"] != "s3cret" {
+		t.Errorf("unexpected secret data %v", secret.StringData)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != job.UID ||
+		secret.OwnerReferences[0].Name != job.Name {
+		t.Errorf("job secret not owned by the job: %+v", secret.OwnerReferences)
+	}
+	if ref := job.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name; ref != secret.Name {
+		t.Errorf("job reads secret %s instead of %s", ref, secret.Name)
+	}
+}
+
+func TestCreateJobWithRunnerSecret(t *testing.T) {
+	kube, client := fakeKube()
+
+	name, err := createTestJob(kube, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, _ := client.BatchV1().Jobs("kriten").Get(context.TODO(), name, metav1.GetOptions{})
+	if ref := job.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name; ref != "ansible" {
+		t.Errorf("job reads secret %s instead of the runner one", ref)
+	}
+	secrets, _ := client.CoreV1().Secrets("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(secrets.Items) != 0 {
+		t.Errorf("expected no job secret, found %d", len(secrets.Items))
+	}
+}
+
+func TestCreateJobFailureDeletesSecret(t *testing.T) {
+	kube, client := fakeKube()
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+
+	if _, err := createTestJob(kube, map[string]string{"password": "s3cret"}); err == nil {
+		t.Fatal("expected the job creation to fail")
+	}
+
+	secrets, _ := client.CoreV1().Secrets("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(secrets.Items) != 0 {
+		t.Errorf("job secret left behind: %s", secrets.Items[0].Name)
+	}
+}
+
+func TestCreateJobOwnerFailureDeletesJob(t *testing.T) {
+	kube, client := fakeKube()
+	client.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("conflict")
+	})
+
+	if _, err := createTestJob(kube, map[string]string{"password": "s3cret"}); err == nil {
+		t.Fatal("expected an error when the job secret owner can't be set")
+	}
+
+	secrets, _ := client.CoreV1().Secrets("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(secrets.Items) != 0 {
+		t.Errorf("job secret left behind: %s", secrets.Items[0].Name)
+	}
+	jobs, _ := client.BatchV1().Jobs("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(jobs.Items) != 0 {
+		t.Errorf("job left running without its secret owned: %s", jobs.Items[0].Name)
+	}
+
+	var deleted []string
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" && action.GetResource().Resource == "jobs" {
+			deleted = append(deleted, action.(k8stesting.DeleteAction).GetName())
+			policy := action.(k8stesting.DeleteActionImpl).DeleteOptions.PropagationPolicy
+			if policy == nil || *policy != metav1.DeletePropagationBackground {
+				t.Error("job deleted without its pods")
+			}
+		}
+	}
+	if len(deleted) != 1 {
+		t.Errorf("expected the job to be deleted once, deleted %v", deleted)
+	}
+}
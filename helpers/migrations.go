@@ -0,0 +1,200 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"gorm.io/gorm"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunMigrationOnce applies a migration only if it hasn't been recorded yet.
+func RunMigrationOnce(db *gorm.DB, name string, migration func() error) error {
+	var res int64
+	db.Model(&models.Migration{}).Where("name = ?", name).Count(&res)
+	if res > 0 {
+		return nil
+	}
+
+	log.Printf("Running migration %s\n", name)
+	err := migration()
+	if err != nil {
+		log.Printf("Migration %s failed: %v\n", name, err)
+		return err
+	}
+
+	return db.Create(&models.Migration{Name: name}).Error
+}
+
+// LabelConfigMaps labels runners and tasks created before Kriten started using
+// labels to identify its ConfigMaps.
+func LabelConfigMaps(kube config.KubeConfig) error {
+	configMaps, err := kube.Clientset.CoreV1().ConfigMaps(
+		kube.Namespace).List(
+		context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if configMap.Labels[LabelManagedBy] != "" {
+			continue
+		}
+
+		// Legacy objects were identified by their fields
+		var kind string
+		if configMap.Data["image"] != "" && configMap.Data["gitURL"] != "" {
+			kind = KindRunner
+		} else if configMap.Data["runner"] != "" && configMap.Data["command"] != "" {
+			kind = KindTask
+		} else {
+			continue
+		}
+
+		if configMap.Labels == nil {
+			configMap.Labels = map[string]string{}
+		}
+		for k, v := range KritenLabels(kind) {
+			configMap.Labels[k] = v
+		}
+
+		_, err = kube.Clientset.CoreV1().ConfigMaps(
+			kube.Namespace).Update(
+			context.TODO(), configMap, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		log.Printf("Labelled ConfigMap %s as %s\n", configMap.Name, kind)
+	}
+
+	return nil
+}
+
+// MigrateConfigMapsToResources converts runners and tasks stored as ConfigMaps into
+// Kriten custom resources, ConfigMaps are deleted once their resource is created.
+func MigrateConfigMapsToResources(kube config.KubeConfig) error {
+	for _, kind := range []string{KindRunner, KindTask} {
+		configMaps, err := ListConfigMaps(kube, kind)
+		if err != nil {
+			return err
+		}
+
+		for _, configMap := range configMaps.Items {
+			var spec map[string]interface{}
+			var labels map[string]string
+
+			if kind == KindRunner {
+				var runner models.Runner
+				b, _ := json.Marshal(configMap.Data)
+				_ = json.Unmarshal(b, &runner)
+				spec, err = ResourceSpec(runner, "token", "secret")
+			} else {
+				task := models.Task{
+					Runner:  configMap.Data["runner"],
+					Command: configMap.Data["command"],
+				}
+				task.Synchronous, _ = strconv.ParseBool(configMap.Data["synchronous"])
+				if configMap.Data["schema"] != "" {
+					err = json.Unmarshal([]byte(configMap.Data["schema"]), &task.Schema)
+					if err != nil {
+						return err
+					}
+				}
+				labels = map[string]string{LabelRunner: task.Runner}
+				spec, err = ResourceSpec(task)
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = CreateOrUpdateResource(kube, kind, configMap.Name, spec, labels, "create")
+			if err != nil && !kerrors.IsAlreadyExists(err) {
+				return err
+			}
+
+			err = DeleteConfigMap(kube, configMap.Name)
+			if err != nil {
+				return err
+			}
+			log.Printf("Migrated %s %s to custom resource\n", kind, configMap.Name)
+		}
+	}
+
+	return nil
+}
+
+// MigrateCronJobsToResources creates a KritenCronJob for every CronJob created before
+// they were introduced, and makes it the owner of the CronJob. KritenCronJobs already created,
+// e.g. by a migration that stopped midway, are kept.
+func MigrateCronJobsToResources(kube config.KubeConfig) error {
+	cronjobs, err := ListCronJobs(kube, nil)
+	if err != nil {
+		return err
+	}
+
+	established := false
+	for i := range cronjobs.Items {
+		cron := &cronjobs.Items[i]
+		labels := cron.Spec.JobTemplate.Spec.Template.Labels
+		if len(cron.OwnerReferences) != 0 || labels["task-name"] == "" {
+			continue
+		}
+
+		cronjob := models.CronJob{
+			Name:     cron.Name,
+			Owner:    labels["owner"],
+			Task:     labels["task-name"],
+			Schedule: cron.Spec.Schedule,
+		}
+		if cron.Spec.Suspend != nil {
+			cronjob.Disable = *cron.Spec.Suspend
+		}
+		for _, container := range cron.Spec.JobTemplate.Spec.Template.Spec.Containers {
+			for _, env := range container.Env {
+				if env.Name == "EXTRA_VARS" {
+					_ = json.Unmarshal([]byte(env.Value), &cronjob.ExtraVars)
+				}
+			}
+		}
+
+		spec, err := ResourceSpec(cronjob)
+		if err != nil {
+			return err
+		}
+
+		if !established {
+			err = WaitForCRD(kube, KindCronJob)
+			if err != nil {
+				return err
+			}
+			established = true
+		}
+
+		obj, err := CreateOrUpdateResource(kube, KindCronJob, cron.Name, spec,
+			map[string]string{LabelTask: cronjob.Task}, "create")
+		if kerrors.IsAlreadyExists(err) {
+			obj, err = GetResource(kube, KindCronJob, cron.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		cron.OwnerReferences = []metav1.OwnerReference{OwnerReference(KindCronJob, obj)}
+		_, err = kube.Clientset.BatchV1().CronJobs(
+			kube.Namespace).Update(
+			context.TODO(), cron, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		log.Printf("Migrated cronjob %s to custom resource\n", cron.Name)
+	}
+
+	return nil
+}
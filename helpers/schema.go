@@ -0,0 +1,421 @@
+package helpers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/exp/slices"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Schemas without "$schema" are treated as JSON Schema draft 2020-12
+const schemaURL = "kriten://task/schema.json"
+
+// Validators of the schemas used most recently are cached by the hash of their schema,
+// schemas are set by users so the cache is bounded.
+const compiledSchemasSize = 256
+
+var compiledSchemas = schemaCache{
+	entries: map[string]*list.Element{},
+	order:   list.New(),
+}
+
+type schemaCache struct {
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	mu      sync.Mutex
+}
+
+type schemaCacheEntry struct {
+	key    string
+	schema *jsonschema.Schema
+}
+
+func (c *schemaCache) Load(key string) (*jsonschema.Schema, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*schemaCacheEntry).schema, true
+}
+
+func (c *schemaCache) Store(key string, schema *jsonschema.Schema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&schemaCacheEntry{key: key, schema: schema})
+
+	if c.order.Len() > compiledSchemasSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*schemaCacheEntry).key)
+	}
+}
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+type SchemaError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaValidationError lists every error found, with the JSON pointer of the invalid value.
+type SchemaValidationError struct {
+	Errors []SchemaError `json:"errors"`
+}
+
+func (e *SchemaValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", err.Path, err.Message))
+	}
+	return "input does not validate against schema: " + strings.Join(msgs, "; ")
+}
+
+// CompileSchema checks a schema against its meta-schema and returns its validator.
+func CompileSchema(schema map[string]any) (*jsonschema.Schema, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	if compiled, ok := compiledSchemas.Load(key); ok {
+		return compiled, nil
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	// Schemas are set by users, "$ref"s must not read files or URLs from the server
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	compiler.AssertFormat()
+	err = compiler.AddResource(schemaURL, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	compiledSchemas.Store(key, compiled)
+
+	return compiled, nil
+}
+
+// ValidateJSON validates raw JSON against a schema, an empty input is validated as an empty object.
+func ValidateJSON(schema map[string]any, input []byte) error {
+	if len(bytes.TrimSpace(input)) == 0 {
+		input = []byte("{}")
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("invalid JSON input: %w", err)
+	}
+
+	return validateValue(schema, doc)
+}
+
+// ValidateValue validates a decoded value, e.g. the extra vars of a cronjob.
+func ValidateValue(schema map[string]any, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return ValidateJSON(schema, data)
+}
+
+func validateValue(schema map[string]any, doc any) error {
+	compiled, err := CompileSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	err = compiled.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	ret := &SchemaValidationError{}
+	collectSchemaErrors(validationErr, ret)
+	return ret
+}
+
+// collectSchemaErrors flattens the error tree, only the leaves carry the actual failures.
+func collectSchemaErrors(err *jsonschema.ValidationError, ret *SchemaValidationError) {
+	if len(err.Causes) == 0 {
+		var tokens []string
+		for _, token := range err.InstanceLocation {
+			tokens = append(tokens, strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+		}
+		path := "/" + strings.Join(tokens, "/")
+		ret.Errors = append(ret.Errors, SchemaError{
+			Path:    path,
+			Message: err.ErrorKind.LocalizedString(schemaPrinter),
+		})
+		return
+	}
+
+	for _, cause := range err.Causes {
+		collectSchemaErrors(cause, ret)
+	}
+}
+
+// Handling of properties not declared in a task schema
+const (
+	UnknownPropertiesAllow  = "allow"
+	UnknownPropertiesDrop   = "drop"
+	UnknownPropertiesReject = "reject"
+)
+
+// NormaliseInput fills the defaults declared in a schema and coerces values to the declared types,
+// e.g. "3" to 3 for an integer. Inputs from query strings and forms only contain strings.
+// Properties not declared in the schema are kept, dropped or rejected according to unknown.
+func NormaliseInput(schema map[string]any, input any, unknown string) (any, error) {
+	n := normaliser{root: schema, unknown: unknown}
+	return n.normalise(schema, input, "")
+}
+
+type normaliser struct {
+	root    map[string]any
+	unknown string
+}
+
+func (n *normaliser) normalise(schema map[string]any, value any, path string) (any, error) {
+	schema = n.resolve(schema)
+	value = coerceValue(value, schemaTypes(schema))
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties := n.properties(schema)
+		for name, property := range properties {
+			if _, ok := v[name]; ok {
+				continue
+			}
+			if def, ok := n.resolve(property)["default"]; ok {
+				v[name] = copyValue(def)
+			}
+		}
+
+		for name, item := range v {
+			property, ok := properties[name]
+			if ok {
+				normalised, err := n.normalise(property, item, path+"/"+name)
+				if err != nil {
+					return nil, err
+				}
+				v[name] = normalised
+				continue
+			}
+
+			// only objects with declared properties and no additionalProperties are restricted
+			if _, additional := schema["additionalProperties"]; additional || len(properties) == 0 {
+				continue
+			}
+			switch n.unknown {
+			case UnknownPropertiesDrop:
+				delete(v, name)
+			case UnknownPropertiesReject:
+				return nil, &SchemaValidationError{
+					Errors: []SchemaError{{Path: path + "/" + name, Message: "property is not declared in the schema"}},
+				}
+			}
+		}
+		return v, nil
+	case []any:
+		items, ok := schema["items"].(map[string]any)
+		if !ok {
+			return v, nil
+		}
+		for i, item := range v {
+			normalised, err := n.normalise(items, item, fmt.Sprintf("%s/%d", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalised
+		}
+		return v, nil
+	}
+
+	return value, nil
+}
+
+// resolve follows local references, e.g. "#/$defs/address".
+func (n *normaliser) resolve(schema map[string]any) map[string]any {
+	for i := 0; i < 10; i++ {
+		ref, ok := schema["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return schema
+		}
+
+		var target any = n.root
+		for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+			token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			obj, ok := target.(map[string]any)
+			if !ok {
+				return schema
+			}
+			target = obj[token]
+		}
+
+		resolved, ok := target.(map[string]any)
+		if !ok {
+			return schema
+		}
+		schema = resolved
+	}
+
+	return schema
+}
+
+// properties merges the properties of a schema with the ones of its allOf subschemas.
+func (n *normaliser) properties(schema map[string]any) map[string]map[string]any {
+	ret := map[string]map[string]any{}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			if subSchema, ok := sub.(map[string]any); ok {
+				for name, property := range n.properties(n.resolve(subSchema)) {
+					ret[name] = property
+				}
+			}
+		}
+	}
+
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for name, property := range properties {
+			if propertySchema, ok := property.(map[string]any); ok {
+				ret[name] = propertySchema
+			}
+		}
+	}
+
+	return ret
+}
+
+func schemaTypes(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+
+	return nil
+}
+
+func coerceValue(value any, types []string) any {
+	if len(types) == 0 {
+		return value
+	}
+	has := func(t string) bool { return slices.Contains(types, t) }
+
+	if values, ok := value.([]string); ok {
+		items := make([]any, len(values))
+		for i := range values {
+			items[i] = values[i]
+		}
+		value = items
+	}
+	// a single query string parameter is a list of one value
+	if items, ok := value.([]any); ok && len(items) == 1 && !has("array") {
+		value = items[0]
+	}
+
+	switch v := value.(type) {
+	case string:
+		if has("string") {
+			return v
+		}
+		if has("integer") {
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+		}
+		if has("number") {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		if has("boolean") {
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+		if has("null") && v == "" {
+			return nil
+		}
+		if has("object") || has("array") {
+			var decoded any
+			if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+				return decoded
+			}
+		}
+		if has("array") {
+			var items []any
+			for _, item := range strings.Split(v, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+			return items
+		}
+	case json.Number, float64, int64, int:
+		if !has("integer") && !has("number") && has("string") {
+			return fmt.Sprint(v)
+		}
+	case bool:
+		if !has("boolean") && has("string") {
+			return strconv.FormatBool(v)
+		}
+	}
+
+	return value
+}
+
+func copyValue(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var ret any
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return value
+	}
+	return ret
+}
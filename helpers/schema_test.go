@@ -0,0 +1,248 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompileSchemaExternalRefs(t *testing.T) {
+	var fetched bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte(`{"type": "string"}`))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(file, []byte(`{"type": "string"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{
+		server.URL + "/schema.json",
+		"http://169.254.169.254/latest/meta-data",
+		"file://" + file,
+		file,
+	} {
+		schema := map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"host": map[string]any{"$ref": ref}},
+		}
+		if _, err := CompileSchema(schema); err == nil {
+			t.Errorf("%s: expected the external $ref to be rejected", ref)
+		}
+	}
+	if fetched {
+		t.Error("external $ref fetched")
+	}
+}
+
+func TestCompileSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]any
+		valid  bool
+	}{
+		{"empty", map[string]any{}, true},
+		{"local $ref", map[string]any{
+			"$defs":      map[string]any{"port": map[string]any{"type": "integer", "maximum": 65535}},
+			"properties": map[string]any{"port": map[string]any{"$ref": "#/$defs/port"}},
+		}, true},
+		{"missing local $ref", map[string]any{
+			"properties": map[string]any{"port": map[string]any{"$ref": "#/$defs/port"}},
+		}, false},
+		{"invalid type", map[string]any{"type": "host"}, false},
+		{"invalid keyword value", map[string]any{"type": "string", "minLength": "3"}, false},
+	}
+
+	for _, test := range tests {
+		_, err := CompileSchema(test.schema)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"host"},
+		"$defs":    map[string]any{"port": map[string]any{"type": "integer", "maximum": 65535}},
+		"properties": map[string]any{
+			"host":  map[string]any{"type": "string", "format": "hostname"},
+			"ports": map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/port"}},
+		},
+	}
+
+	tests := []struct {
+		input string
+		paths []string
+	}{
+		{`{"host": "db1", "ports": [22, 5432]}`, nil},
+		{``, []string{"/"}},
+		{`{"host": "db 1"}`, []string{"/host"}},
+		{`{"host": "db1", "ports": [22, 70000, "ssh"]}`, []string{"/ports/1", "/ports/2"}},
+	}
+
+	for _, test := range tests {
+		err := ValidateJSON(schema, []byte(test.input))
+		if test.paths == nil {
+			if err != nil {
+				t.Errorf("%s: valid input rejected: %v", test.input, err)
+			}
+			continue
+		}
+
+		var validationErr *SchemaValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected a validation error, got %v", test.input, err)
+			continue
+		}
+		paths := map[string]bool{}
+		for _, e := range validationErr.Errors {
+			paths[e.Path] = true
+		}
+		for _, path := range test.paths {
+			if !paths[path] {
+				t.Errorf("%s: expected an error at %s, got %v", test.input, path, validationErr.Errors)
+			}
+		}
+	}
+
+	if err := ValidateJSON(schema, []byte(`{"host":`)); err == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+}
+
+func TestNormaliseInput(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"$defs": map[string]any{
+			"retries": map[string]any{"type": "integer", "default": 3},
+		},
+		"properties": map[string]any{
+			"host":    map[string]any{"type": "string"},
+			"port":    map[string]any{"type": "integer", "default": 22},
+			"ratio":   map[string]any{"type": "number"},
+			"dry_run": map[string]any{"type": "boolean", "default": false},
+			"tags":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"ports":   map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			"retries": map[string]any{"$ref": "#/$defs/retries"},
+			"options": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"timeout": map[string]any{"type": "integer", "default": 30}},
+				"default":    map[string]any{},
+			},
+			"label": map[string]any{"type": []any{"string", "null"}},
+		},
+	}
+
+	// Defaults are copied through JSON, their numbers are float64
+	tests := []struct {
+		name     string
+		input    map[string]any
+		expected map[string]any
+	}{
+		{
+			"defaults",
+			map[string]any{"host": "db1"},
+			map[string]any{"host": "db1", "port": float64(22), "dry_run": false, "retries": float64(3), "options": map[string]any{"timeout": float64(30)}},
+		},
+		{
+			"query string values",
+			map[string]any{
+				"host": []string{"db1"}, "port": []string{"2222"}, "ratio": "0.5", "dry_run": "true",
+				"tags": "web, db", "ports": []string{"22", "80"}, "retries": "5",
+			},
+			map[string]any{
+				"host": "db1", "port": int64(2222), "ratio": 0.5, "dry_run": true,
+				"tags": []any{"web", "db"}, "ports": []any{int64(22), int64(80)}, "retries": int64(5),
+				"options": map[string]any{"timeout": float64(30)},
+			},
+		},
+		{
+			"JSON values",
+			map[string]any{"host": 1, "options": `{"timeout": "60"}`, "label": true, "ports": `[22]`},
+			map[string]any{
+				"host": "1", "port": float64(22), "dry_run": false, "retries": float64(3),
+				"options": map[string]any{"timeout": int64(60)}, "label": "true", "ports": []any{float64(22)},
+			},
+		},
+		{
+			"values that can't be coerced are left to validation",
+			map[string]any{"port": "ssh", "dry_run": "maybe"},
+			map[string]any{"port": "ssh", "dry_run": "maybe", "retries": float64(3), "options": map[string]any{"timeout": float64(30)}},
+		},
+	}
+
+	for _, test := range tests {
+		normalised, err := NormaliseInput(schema, test.input, UnknownPropertiesAllow)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(normalised, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, normalised)
+		}
+	}
+}
+
+func TestNormaliseInputDefaultsAreCopied(t *testing.T) {
+	schema := map[string]any{
+		"properties": map[string]any{
+			"tags": map[string]any{"type": "array", "default": []any{"web"}},
+		},
+	}
+
+	first, _ := NormaliseInput(schema, map[string]any{}, UnknownPropertiesAllow)
+	first.(map[string]any)["tags"].([]any)[0] = "db"
+
+	second, _ := NormaliseInput(schema, map[string]any{}, UnknownPropertiesAllow)
+	if tags := second.(map[string]any)["tags"].([]any); tags[0] != "web" {
+		t.Errorf("default changed by a previous input: %v", tags)
+	}
+}
+
+func TestNormaliseInputUnknownProperties(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"allOf": []any{
+			map[string]any{"properties": map[string]any{"port": map[string]any{"type": "integer"}}},
+		},
+		"properties": map[string]any{
+			"host":   map[string]any{"type": "string"},
+			"labels": map[string]any{"type": "object"},
+		},
+	}
+	input := func() map[string]any {
+		return map[string]any{"host": "db1", "port": "22", "debug": true, "labels": map[string]any{"team": "netops"}}
+	}
+
+	allowed, err := NormaliseInput(schema, input(), UnknownPropertiesAllow)
+	if _, ok := allowed.(map[string]any)["debug"]; err != nil || !ok {
+		t.Errorf("unknown property not kept: %v, %v", allowed, err)
+	}
+
+	dropped, err := NormaliseInput(schema, input(), UnknownPropertiesDrop)
+	expected := map[string]any{"host": "db1", "port": int64(22), "labels": map[string]any{"team": "netops"}}
+	if err != nil || !reflect.DeepEqual(dropped, expected) {
+		t.Errorf("expected %v, got %v, %v", expected, dropped, err)
+	}
+
+	_, err = NormaliseInput(schema, input(), UnknownPropertiesReject)
+	var validationErr *SchemaValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Path != "/debug" {
+		t.Errorf("expected /debug to be rejected, got %v", err)
+	}
+
+	// Objects allowing additional properties keep them
+	schema["additionalProperties"] = true
+	if _, err := NormaliseInput(schema, input(), UnknownPropertiesReject); err != nil {
+		t.Errorf("additional property rejected: %v", err)
+	}
+}
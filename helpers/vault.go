@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+)
+
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+var vaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type vaultResponse struct {
+	Data          map[string]interface{} `json:"data"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Auth          *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// ReadVaultKV reads the latest version of a KV v2 secret.
+func ReadVaultKV(conf config.VaultConfig, mount string, path string) (map[string]string, error) {
+	res, err := vaultRequest(conf, http.MethodGet, fmt.Sprintf("%s/data/%s", mount, path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// KV v2 wraps the secret values in a nested "data" field, next to "metadata"
+	data, ok := res.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s/%s not found", mount, path)
+	}
+
+	return flattenVaultData(data), nil
+}
+
+// ReadVaultDynamicSecret generates a new set of credentials from a secrets engine
+// (e.g. database/creds/<role>). Every call returns a fresh lease.
+func ReadVaultDynamicSecret(conf config.VaultConfig, mount string, path string) (map[string]string, error) {
+	res, err := vaultRequest(conf, http.MethodGet, fmt.Sprintf("%s/%s", mount, path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("vault returned no credentials for %s/%s", mount, path)
+	}
+	log.Printf("vault issued dynamic secret %s/%s with lease of %ds\n", mount, path, res.LeaseDuration)
+
+	return flattenVaultData(res.Data), nil
+}
+
+func vaultToken(conf config.VaultConfig) (string, error) {
+	if conf.AuthMethod != "kubernetes" {
+		if conf.Token == "" {
+			return "", errors.New("vault token is not configured")
+		}
+		return conf.Token, nil
+	}
+
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	body := map[string]string{
+		"role": conf.Role,
+		"jwt":  string(jwt),
+	}
+	res, err := vaultDo(conf, "", http.MethodPost, fmt.Sprintf("auth/%s/login", conf.AuthMount), body)
+	if err != nil {
+		return "", fmt.Errorf("vault kubernetes login failed: %w", err)
+	}
+	if res.Auth == nil || res.Auth.ClientToken == "" {
+		return "", errors.New("vault kubernetes login returned no token")
+	}
+
+	return res.Auth.ClientToken, nil
+}
+
+func vaultRequest(conf config.VaultConfig, method string, path string, body interface{}) (*vaultResponse, error) {
+	if conf.Address == "" {
+		return nil, errors.New("vault address is not configured")
+	}
+
+	token, err := vaultToken(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return vaultDo(conf, token, method, path, body)
+}
+
+func vaultDo(conf config.VaultConfig, token string, method string, path string, body interface{}) (*vaultResponse, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(conf.Address, "/"), strings.TrimPrefix(path, "/"))
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", conf.Namespace)
+	}
+
+	resp, err := vaultHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode vault response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		if len(res.Errors) > 0 {
+			return nil, fmt.Errorf("vault error (%d): %s", resp.StatusCode, strings.Join(res.Errors, ", "))
+		}
+		return nil, fmt.Errorf("vault error (%d) on %s", resp.StatusCode, path)
+	}
+
+	return &res, nil
+}
+
+// Environment variables can only hold strings, nested values are passed as JSON.
+func flattenVaultData(data map[string]interface{}) map[string]string {
+	ret := make(map[string]string, len(data))
+	for k, v := range data {
+		switch val := v.(type) {
+		case string:
+			ret[k] = val
+		case nil:
+			ret[k] = ""
+		default:
+			b, err := json.Marshal(val)
+			if err != nil {
+				ret[k] = fmt.Sprint(val)
+				continue
+			}
+			ret[k] = string(b)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,198 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// vaultTestConfig returns the Vault dev server the tests run against, e.g. one started with
+// vault server -dev -dev-root-token-id=root and VAULT_ADDR=http://127.0.0.1:8200. They're skipped without it.
+func vaultTestConfig(t *testing.T) config.VaultConfig {
+	t.Helper()
+
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		t.Skip("VAULT_ADDR not set, skipping tests against a Vault dev server")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		token = "root"
+	}
+
+	return config.VaultConfig{Address: address, Token: token, AuthMethod: "token"}
+}
+
+func writeVaultKV(t *testing.T, conf config.VaultConfig, path string, data map[string]interface{}) {
+	t.Helper()
+
+	_, err := vaultDo(conf, conf.Token, http.MethodPost, "secret/data/"+path, map[string]interface{}{"data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = vaultDo(conf, conf.Token, http.MethodDelete, "secret/metadata/"+path, nil)
+	})
+}
+
+func TestReadVaultKV(t *testing.T) {
+	conf := vaultTestConfig(t)
+	writeVaultKV(t, conf, "kriten-test/db", map[string]interface{}{
+		"username": "kriten",
+		"password": "s3cret",
+		"port":     5432,
+		"options":  map[string]interface{}{"ssl": true},
+		"comment":  nil,
+	})
+
+	secrets, err := ReadVaultKV(conf, "secret", "kriten-test/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"username": "kriten",
+		"password": "s3cret",
+		"port":     "5432",
+		"options":  `{"ssl":true}`,
+		"comment":  "",
+	}
+	for key, value := range expected {
+		if secrets[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, secrets[key])
+		}
+	}
+}
+
+func TestReadVaultKVLatestVersion(t *testing.T) {
+	conf := vaultTestConfig(t)
+	writeVaultKV(t, conf, "kriten-test/rotated", map[string]interface{}{"password": "old"})
+	writeVaultKV(t, conf, "kriten-test/rotated", map[string]interface{}{"password": "new"})
+
+	secrets, err := ReadVaultKV(conf, "secret", "kriten-test/rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["password"] != "new" {
+		t.Errorf("expected the latest version, got %q", secrets["password"])
+	}
+}
+
+func TestReadVaultKVNotFound(t *testing.T) {
+	conf := vaultTestConfig(t)
+
+	_, err := ReadVaultKV(conf, "secret", "kriten-test/missing")
+	if err == nil {
+		t.Fatal("expected an error on a missing secret")
+	}
+}
+
+func TestReadVaultKVPermissionDenied(t *testing.T) {
+	conf := vaultTestConfig(t)
+	writeVaultKV(t, conf, "kriten-test/db", map[string]interface{}{"password": "s3cret"})
+
+	conf.Token = "invalid"
+	_, err := ReadVaultKV(conf, "secret", "kriten-test/db")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected a 403 error with an invalid token, got %v", err)
+	}
+}
+
+// TestReadVaultDynamicSecret reads a KV v1 mount, which like secrets engines issuing credentials
+// returns its values at the top level of the response data.
+func TestReadVaultDynamicSecret(t *testing.T) {
+	conf := vaultTestConfig(t)
+
+	_, err := vaultDo(conf, conf.Token, http.MethodPost, "sys/mounts/kriten-test-kv1",
+		map[string]interface{}{"type": "kv", "options": map[string]string{"version": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = vaultDo(conf, conf.Token, http.MethodDelete, "sys/mounts/kriten-test-kv1", nil)
+	})
+	_, err = vaultDo(conf, conf.Token, http.MethodPost, "kriten-test-kv1/creds",
+		map[string]interface{}{"username": "v-kriten-1", "password": "generated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := ReadVaultDynamicSecret(conf, "kriten-test-kv1", "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["username"] != "v-kriten-1" || secrets["password"] != "generated" {
+		t.Errorf("unexpected credentials %v", secrets)
+	}
+
+	_, err = ReadVaultDynamicSecret(conf, "kriten-test-kv1", "missing")
+	if err == nil {
+		t.Error("expected an error on missing credentials")
+	}
+}
+
+// TestVaultJobSecret creates a job with the secrets read from Vault, they're only stored in the job secret.
+func TestVaultJobSecret(t *testing.T) {
+	conf := vaultTestConfig(t)
+	writeVaultKV(t, conf, "kriten-test/job", map[string]interface{}{"api_key": "k3y"})
+
+	secrets, err := ReadVaultKV(conf, "secret", "kriten-test/job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kube, client := fakeKube()
+	name, err := createTestJob(kube, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _ := client.CoreV1().Secrets("kriten").List(context.TODO(), metav1.ListOptions{})
+	if len(list.Items) != 1 || list.Items[0].StringData["api_key"] != "k3y" {
+		t.Fatalf("job secret doesn't hold the Vault secret: %+v", list.Items)
+	}
+	if owner := list.Items[0].OwnerReferences; len(owner) != 1 || owner[0].Name != name {
+		t.Errorf("job secret not owned by job %s: %+v", name, owner)
+	}
+}
+
+func TestVaultNotConfigured(t *testing.T) {
+	_, err := ReadVaultKV(config.VaultConfig{}, "secret", "kriten-test/db")
+	if err == nil {
+		t.Error("expected an error without VAULT_ADDR")
+	}
+
+	_, err = ReadVaultKV(config.VaultConfig{Address: "http://127.0.0.1:1"}, "secret", "kriten-test/db")
+	if err == nil {
+		t.Error("expected an error without a token")
+	}
+}
+
+func TestFlattenVaultData(t *testing.T) {
+	data := flattenVaultData(map[string]interface{}{
+		"string": "value",
+		"number": 42.5,
+		"bool":   true,
+		"list":   []interface{}{"a", "b"},
+		"null":   nil,
+	})
+
+	expected := map[string]string{
+		"string": "value",
+		"number": "42.5",
+		"bool":   "true",
+		"list":   `["a","b"]`,
+		"null":   "",
+	}
+	for key, value := range expected {
+		if data[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, data[key])
+		}
+	}
+}
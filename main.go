@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"path/filepath"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/kriten-io/kriten/config"
 	"github.com/kriten-io/kriten/controllers"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/operator"
 	"github.com/kriten-io/kriten/services"
 
 	docs "github.com/kriten-io/kriten/docs"
@@ -21,6 +24,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -41,6 +45,13 @@ var (
 	als        services.AuditService
 	rls        services.RoleService
 	rbs        services.RoleBindingService
+	bs         services.BundleService
+	mws        services.MaintenanceWindowService
+	sjs        services.ScheduledJobService
+	es         services.EncryptionService
+	ns         services.NotificationService
+	trs        services.TriggerService
+	ers        services.EventRuleService
 	ac         controllers.AuthController
 	alc        controllers.AuditController
 	rc         controllers.RunnerController
@@ -53,6 +64,14 @@ var (
 	gc         controllers.GroupController
 	rlc        controllers.RoleController
 	rbc        controllers.RoleBindingController
+	bc         controllers.BundleController
+	mwc        controllers.MaintenanceWindowController
+	sjc        controllers.ScheduledJobController
+	ec         controllers.EncryptionController
+	nc         controllers.SubscriptionController
+	ncc        controllers.NotificationChannelController
+	trc        controllers.TriggerController
+	erc        controllers.EventRuleController
 	conf       config.Config
 	kubeConfig *rest.Config
 	// es         helpers.ElasticSearch
@@ -70,6 +89,14 @@ func init() {
 		log.Fatal("Error loading .env file")
 	}
 	conf = config.NewConfig(GitBranch)
+	// Slack requests have no delivery ID, only their signed timestamp keeps them from being replayed forever
+	if conf.WebhookTimestampTolerance <= 0 {
+		log.Fatal("WEBHOOK_TIMESTAMP_TOLERANCE must be positive")
+	}
+	// Replayed requests with a valid timestamp are only rejected while their message is remembered
+	if conf.WebhookReplayWindow < conf.WebhookTimestampTolerance {
+		log.Fatal("WEBHOOK_REPLAY_WINDOW can't be shorter than WEBHOOK_TIMESTAMP_TOLERANCE")
+	}
 
 	// Retrieving k8s clientset
 	if conf.Environment == "production" {
@@ -92,6 +119,24 @@ func init() {
 	if err != nil {
 		panic(err.Error())
 	}
+	conf.Kube.DynamicClient, err = dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if conf.Kube.InstallCRDs {
+		err = helpers.InstallCRDs(conf.Kube)
+		if err != nil {
+			log.Println("Error while installing Kriten CRDs")
+			log.Println(err)
+		}
+	}
+
+	keyring, err := helpers.LoadKeyring(conf)
+	if err != nil {
+		log.Fatalf("Error loading encryption keys: %v", err)
+	}
+	helpers.SetKeyring(keyring)
 
 	// Establishing connection with PostgreSQL database
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%v sslmode=%s",
@@ -122,6 +167,27 @@ func init() {
 	}
 	config.InitDB(db)
 
+	err = helpers.RunMigrationOnce(db, "configmap-labels", func() error {
+		return helpers.LabelConfigMaps(conf.Kube)
+	})
+	if err != nil {
+		log.Println("Error while labelling existing runners and tasks")
+	}
+
+	err = helpers.RunMigrationOnce(db, "configmaps-to-crds", func() error {
+		return helpers.MigrateConfigMapsToResources(conf.Kube)
+	})
+	if err != nil {
+		log.Println("Error while migrating runners and tasks to custom resources")
+	}
+
+	err = helpers.RunMigrationOnce(db, "cronjobs-to-crds", func() error {
+		return helpers.MigrateCronJobsToResources(conf.Kube)
+	})
+	if err != nil {
+		log.Println("Error while migrating cronjobs to custom resources")
+	}
+
 	// if conf.ElasticSearch.CloudID != "" {
 	// 	es.Client, err = elasticsearch.NewClient(
 	// 		elasticsearch.Config{
@@ -143,21 +209,28 @@ func init() {
 	// Services
 	us = services.NewUserService(db, conf)
 	ats = services.NewApiTokenService(db, conf)
-	ws = services.NewWebhookService(db, conf)
+	mws = services.NewMaintenanceWindowService(db, conf)
+	js = services.NewJobService(conf, mws)
+	ws = services.NewWebhookService(db, js, conf)
 	gs = services.NewGroupService(db, us, conf)
 	rls = services.NewRoleService(db, conf, &rbs, &us)
 	rbs = services.NewRoleBindingService(db, conf, rls, gs)
 	as = services.NewAuthService(conf, us, rls, rbs, db)
-	als = services.NewAuditService(db, conf)
+	ns = services.NewNotificationService(db, js, as, conf)
+	als = services.NewAuditService(db, ns, conf)
+	trs = services.NewTriggerService(db, js, as, als, conf)
+	ers = services.NewEventRuleService(db, js, as, als, conf)
 
-	rs = services.NewRunnerService(conf)
-	ts = services.NewTaskService(ws, conf)
-	js = services.NewJobService(conf)
-	cjs = services.NewCronJobService(conf)
+	ts = services.NewTaskService(db, ws, conf)
+	rs = services.NewRunnerService(db, ts, conf)
+	cjs = services.NewCronJobService(conf, mws)
+	sjs = services.NewScheduledJobService(db, js, as, conf)
+	es = services.NewEncryptionService(db, conf)
+	bs = services.NewBundleService(conf, rs, ts, cjs, gs, rls, rbs, ws, us)
 
 	// Controllers
 	uc = controllers.NewUserController(us, gs, as, als, authProviders)
-	wc = controllers.NewWebhookController(ws, js, as, als, authProviders)
+	wc = controllers.NewWebhookController(ws, as, als, authProviders)
 	atc = controllers.NewApiTokenController(ats, as, als, authProviders)
 	gc = controllers.NewGroupController(gs, as, als, authProviders)
 	rlc = controllers.NewRoleController(rls, as, als)
@@ -167,8 +240,16 @@ func init() {
 
 	rc = controllers.NewRunnerController(rs, as, als)
 	tc = controllers.NewTaskController(ts, as, als)
-	jc = controllers.NewJobController(js, as, als)
+	jc = controllers.NewJobController(js, sjs, as, als)
 	cjc = controllers.NewCronJobController(cjs, as, als)
+	bc = controllers.NewBundleController(bs, as, als)
+	mwc = controllers.NewMaintenanceWindowController(mws, as, als)
+	sjc = controllers.NewScheduledJobController(sjs, as, als)
+	ec = controllers.NewEncryptionController(es, as, als)
+	nc = controllers.NewSubscriptionController(ns, as, als)
+	ncc = controllers.NewNotificationChannelController(ns, as, als)
+	trc = controllers.NewTriggerController(trs, as, als)
+	erc = controllers.NewEventRuleController(ers, as, als)
 }
 
 //	@title			Swagger Kriten
@@ -208,6 +289,7 @@ func main() {
 	basepath := router.Group("/api/v1")
 	{
 		ac.SetAuthRoutes(basepath)
+		bc.SetBundleRoutes(basepath, conf)
 		audit := basepath.Group("/audit_logs")
 		runners := basepath.Group("/runners")
 		tasks := basepath.Group("/tasks")
@@ -219,6 +301,14 @@ func main() {
 		roles := basepath.Group("/roles")
 		roleBindings := basepath.Group("/role_bindings")
 		webhooks := basepath.Group("/webhooks")
+		maintenanceWindows := basepath.Group("/maintenance_windows")
+		scheduledJobs := basepath.Group("/scheduled_jobs")
+		encryption := basepath.Group("/encryption")
+		subscriptions := basepath.Group("/subscriptions")
+		notificationChannels := basepath.Group("/notification_channels")
+		triggers := basepath.Group("/triggers")
+		eventRules := basepath.Group("/event_rules")
+		openapi := basepath.Group("/openapi.json")
 		{
 			alc.SetAuditRoutes(audit, conf)
 			rc.SetRunnerRoutes(runners, conf)
@@ -231,7 +321,111 @@ func main() {
 			gc.SetGroupRoutes(groups, conf)
 			rlc.SetRoleRoutes(roles, conf)
 			rbc.SetRoleBindingRoutes(roleBindings, conf)
+			jc.SetOpenAPIRoutes(openapi, conf)
+			mwc.SetMaintenanceWindowRoutes(maintenanceWindows, conf)
+			sjc.SetScheduledJobRoutes(scheduledJobs, conf)
+			ec.SetEncryptionRoutes(encryption, conf)
+			nc.SetSubscriptionRoutes(subscriptions, conf)
+			ncc.SetNotificationChannelRoutes(notificationChannels, conf)
+			trc.SetTriggerRoutes(triggers, conf)
+			erc.SetEventRuleRoutes(eventRules, conf)
+		}
+	}
+
+	// Every replica runs the runner sync, it's locked so only one of them clones the repositories at a time
+	if conf.RunnerSyncInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(conf.RunnerSyncInterval) * time.Minute)
+			for range ticker.C {
+				rs.SyncRunners()
+			}
+		}()
+	}
+
+	// Every replica runs the scheduler, rows are locked so a scheduled job is only launched once
+	if conf.SchedulerInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(conf.SchedulerInterval) * time.Second)
+			for range ticker.C {
+				err := sjs.LaunchScheduledJobs()
+				if err != nil {
+					log.Printf("Failed to launch scheduled jobs: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	// Every replica delivers notifications, they're locked and job events are deduplicated
+	if conf.NotificationInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(conf.NotificationInterval) * time.Second)
+			for range ticker.C {
+				err := ns.NotifyJobs()
+				if err != nil {
+					log.Printf("Failed to notify job events: %v\n", err)
+				}
+				err = ns.DeliverNotifications()
+				if err != nil {
+					log.Printf("Failed to deliver notifications: %v\n", err)
+				}
+				err = ns.DeliverChannelMessages()
+				if err != nil {
+					log.Printf("Failed to deliver channel messages: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	// Every replica consumes the NATS, Kafka and Kubernetes triggers, in queue and consumer groups or recording
+	// Kubernetes events so events run once
+	if conf.TriggerSyncInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(conf.TriggerSyncInterval) * time.Second)
+			for range ticker.C {
+				err := trs.SyncConsumers()
+				if err != nil {
+					log.Printf("Failed to sync trigger consumers: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	// Every replica listening receives its own events, rules lock runs so events sent to several still run once
+	if conf.SyslogAddr != "" {
+		go func() {
+			err := ers.ListenSyslog()
+			if err != nil {
+				log.Printf("Syslog listener stopped: %v\n", err)
+			}
+		}()
+	}
+
+	if conf.SNMP.TrapAddr != "" {
+		go func() {
+			err := ers.ListenSNMP()
+			if err != nil {
+				log.Printf("SNMP trap listener stopped: %v\n", err)
+			}
+		}()
+	}
+
+	// Scheduled cronjobs are suspended while maintenance windows block them
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			err := cjs.EnforceMaintenanceWindows()
+			if err != nil {
+				log.Printf("Failed to enforce maintenance windows: %v\n", err)
+			}
+		}
+	}()
+
+	if conf.Operator {
+		op, err := operator.NewOperator(kubeConfig, conf, ts, cjs, ws, rbs, us)
+		if err != nil {
+			log.Fatalf("Failed to set up the operator: %v", err)
 		}
+		go op.Start(context.Background())
 	}
 
 	log.Fatal(router.Run())
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -18,63 +19,8 @@ import (
 
 func AuthenticationMiddleware(as services.AuthService, jwtConf config.JWTConfig) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var token string
-		webhookID := ctx.Param("id")
-		// webhook-timestamp, webhook-id and webhook-signature - are specific header fields of Opsmil Infrahub webhook
-		webhookTimestamp := ctx.GetHeader("webhook-timestamp")
-		webhookMsgID := ctx.GetHeader("webhook-id")
-		webhookSig := ctx.GetHeader("webhook-signature")
-		// X-Hook-Signature header field is common webhook signature field, supported by Netbox and Nautobot
-		signature := ctx.GetHeader("X-Hook-Signature")
-		token = ctx.GetHeader("Token")
-		if strings.Contains(ctx.Request.URL.String(), "/api/v1/webhooks/run") {
-			if signature == "" && webhookSig == "" {
-				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook authentication failed."})
-				return
-			}
-
-			body, err := io.ReadAll(ctx.Request.Body)
-
-			if err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
-				return
-			}
-
-			if webhookMsgID != "" && webhookTimestamp != "" && webhookSig != "" {
-				owner, taskID, err := as.ValidateWebhookSignatureInfraHub(
-					webhookID,
-					webhookMsgID,
-					webhookTimestamp,
-					webhookSig,
-					body)
-				if err != nil {
-					ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook authentication failed."})
-					return
-				}
-				ctx.Set("userID", owner.ID)
-				ctx.Set("username", owner.Username)
-				ctx.Set("provider", owner.Provider)
-				ctx.Set("taskID", taskID)
-			} else if signature != "" {
-				owner, taskID, err := as.ValidateWebhookSignatureCommon(
-					webhookID,
-					signature,
-					body)
-				if err != nil {
-					ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook authentication failed."})
-					return
-				}
-				ctx.Set("userID", owner.ID)
-				ctx.Set("username", owner.Username)
-				ctx.Set("provider", owner.Provider)
-				ctx.Set("taskID", taskID)
-			} else {
-				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook authentication failed."})
-				return
-			}
-
-			ctx.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-		} else if token != "" {
+		token := ctx.GetHeader("Token")
+		if token != "" {
 			owner, err := as.ValidateAPIToken(token)
 			if err != nil {
 				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -112,6 +58,66 @@ func AuthenticationMiddleware(as services.AuthService, jwtConf config.JWTConfig)
 	}
 }
 
+// WebhookAuthenticationMiddleware authenticates webhook runs with the webhook signature scheme,
+// the request is then authorised as the webhook owner.
+func WebhookAuthenticationMiddleware(ws services.WebhookService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+
+		owner, webhook, verification, err := ws.VerifyWebhook(ctx.Param("id"), ctx.Request.Header, body)
+		var replayErr *services.WebhookReplayError
+		if errors.As(err, &replayErr) {
+			ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Webhook %s authentication failed: %v\n", ctx.Param("id"), err)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook authentication failed."})
+			return
+		}
+
+		ctx.Set("userID", owner.ID)
+		ctx.Set("username", owner.Username)
+		ctx.Set("provider", owner.Provider)
+		ctx.Set("webhook", webhook)
+		ctx.Set("verification", verification)
+
+		ctx.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		ctx.Next()
+	}
+}
+
+// TriggerAuthenticationMiddleware authenticates HTTP events with the trigger secret,
+// the request is then authorised as the trigger owner.
+func TriggerAuthenticationMiddleware(trs services.TriggerService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+
+		owner, trigger, err := trs.VerifyTrigger(ctx.Param("id"), ctx.Request.Header, body)
+		if err != nil {
+			log.Printf("Trigger %s authentication failed: %v\n", ctx.Param("id"), err)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "trigger authentication failed."})
+			return
+		}
+
+		ctx.Set("userID", owner.ID)
+		ctx.Set("username", owner.Username)
+		ctx.Set("provider", owner.Provider)
+		ctx.Set("trigger", trigger)
+
+		ctx.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		ctx.Next()
+	}
+}
+
 func AuthorizationMiddleware(as services.AuthService, resource string, access string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userID := ctx.MustGet("userID").(uuid.UUID)
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const BundleVersion = "v1"
+
+type Bundle struct {
+	Version      string          `json:"version"`
+	ExportedAt   time.Time       `json:"exported_at"`
+	Runners      []Runner        `json:"runners"`
+	Tasks        []Task          `json:"tasks"`
+	CronJobs     []CronJob       `json:"cronjobs"`
+	Groups       []Group         `json:"groups"`
+	Roles        []Role          `json:"roles"`
+	RoleBindings []RoleBinding   `json:"role_bindings"`
+	Webhooks     []BundleWebhook `json:"webhooks"`
+	// Secrets holds BundleSecrets encrypted with the export passphrase
+	Secrets string `json:"secrets,omitempty"`
+}
+
+// BundleWebhook refers to the owner by username and provider, as user IDs differ between installations.
+type BundleWebhook struct {
+	ID            uuid.UUID `json:"id"`
+	Owner         string    `json:"owner"`
+	OwnerProvider string    `json:"owner_provider"`
+	Description   string    `json:"description,omitempty"`
+	Task          string    `json:"task"`
+
+	SignatureScheme    string `json:"signature_scheme,omitempty"`
+	SignatureHeader    string `json:"signature_header,omitempty"`
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	SignatureEncoding  string `json:"signature_encoding,omitempty"`
+	SignaturePrefix    string `json:"signature_prefix,omitempty"`
+	Mapping            string `json:"mapping,omitempty"`
+	Filter             string `json:"filter,omitempty"`
+}
+
+type BundleSecrets struct {
+	Runners  map[string]BundleRunnerSecrets `json:"runners,omitempty"`
+	Webhooks map[string]string              `json:"webhooks,omitempty"`
+}
+
+type BundleRunnerSecrets struct {
+	Token  string            `json:"token,omitempty"`
+	Secret map[string]string `json:"secret,omitempty"`
+}
+
+type BundleChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // "create", "update", "delete", "unchanged" or "skip"
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BundleImportResult struct {
+	Mode    string         `json:"mode"`
+	DryRun  bool           `json:"dry_run"`
+	Changes []BundleChange `json:"changes"`
+}
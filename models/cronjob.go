@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type CronJob struct {
 	Name      string                 `json:"name"`
 	Owner     string                 `json:"owner"`
@@ -7,4 +9,21 @@ type CronJob struct {
 	Schedule  string                 `json:"schedule"`
 	Disable   bool                   `json:"disable"`
 	ExtraVars map[string]interface{} `json:"extra_vars,omitempty"`
+	// TimeZone of the schedule, e.g. "Europe/London", defaults to the controller's time zone (UTC)
+	TimeZone string `json:"time_zone,omitempty"`
+	// ConcurrencyPolicy is Allow (default), Forbid or Replace
+	ConcurrencyPolicy          string `json:"concurrency_policy,omitempty"`
+	StartingDeadlineSeconds    *int64 `json:"starting_deadline_seconds,omitempty"`
+	SuccessfulJobsHistoryLimit *int32 `json:"successful_jobs_history_limit,omitempty"`
+	FailedJobsHistoryLimit     *int32 `json:"failed_jobs_history_limit,omitempty"`
+	// NextRuns is computed from the schedule, not part of the spec
+	NextRuns []time.Time `json:"next_runs,omitempty"`
+	// Taken from the CronJob status, not part of the spec
+	LastScheduleTime   *time.Time `json:"last_schedule_time,omitempty"`
+	LastSuccessfulTime *time.Time `json:"last_successful_time,omitempty"`
+	Active             []string   `json:"active,omitempty"` // running jobs
+	// Drift is set when the CronJob was edited directly, it's restored on the next sync
+	Drift bool `json:"drift,omitempty"`
+	// BlockedBy lists the maintenance windows suspending the cronjob
+	BlockedBy string `json:"blocked_by,omitempty"`
 }
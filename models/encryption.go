@@ -0,0 +1,10 @@
+package models
+
+// EncryptionStatus reports how sensitive columns are encrypted.
+type EncryptionStatus struct {
+	ActiveKey string `json:"active_key,omitempty"`
+	// Number of values by column and key version, "plain" counts the values not encrypted
+	Columns map[string]map[string]int `json:"columns"`
+	// Number of values encrypted again with the active key, set by a re-encryption
+	Reencrypted int `json:"reencrypted"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// EventRule runs a task when a syslog message or an SNMP trap received by Kriten matches it, as the rule owner.
+// Jobs are deduplicated and rate limited, so a flapping device doesn't launch one job per event.
+type EventRule struct {
+	ID          uuid.UUID `gorm:"column:rule_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name        string    `gorm:"uniqueIndex;<-:create" json:"name" binding:"required"`
+	Description string    `json:"description,omitempty"`
+	Owner       uuid.UUID `gorm:"type:uuid" json:"owner"`
+	Task        string    `json:"task" binding:"required"`
+	Source      string    `json:"source" binding:"required"` // "syslog" or "snmp"
+	// Sender addresses, CIDRs or syslog hostnames, any sender when empty
+	Hosts      pq.StringArray `gorm:"type:text[]" json:"hosts"`
+	Facilities pq.StringArray `gorm:"type:text[]" json:"facilities"` // syslog facilities, e.g. local7
+	// Severity matches syslog messages up to it, e.g. "warning" matches emerg to warning
+	Severity string `json:"severity,omitempty"`
+	// Message is a regular expression syslog messages must match, its named groups are added to the fields
+	Message string         `json:"message,omitempty"`
+	OIDs    pq.StringArray `gorm:"column:oids;type:text[]" json:"oids"` // SNMP trap OIDs, an OID matches the ones under it
+	// Mapping is a JSON object of the task extra vars whose string values can be Go templates of the event fields,
+	// which are used as they are by default
+	Mapping string `json:"mapping,omitempty"`
+	// Events with the same key start a single job per DedupWindow, 5m by default. The key is a Go template
+	// of the event fields, the host and the message or trap OID by default
+	DedupKey    string `json:"dedup_key,omitempty"`
+	DedupWindow string `json:"dedup_window,omitempty"`
+	// At most RateLimit jobs start per RateWindow, 10 per 10m by default
+	RateLimit  int       `json:"rate_limit,omitempty"`
+	RateWindow string    `json:"rate_window,omitempty"`
+	Disable    bool      `json:"disable"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// EventRuleRun is a job started by an event rule, with the number of events suppressed in its windows.
+type EventRuleRun struct {
+	ID         uuid.UUID `gorm:"column:run_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Rule       uuid.UUID `gorm:"type:uuid;index" json:"rule"`
+	Key        string    `gorm:"index" json:"key"`
+	Fields     string    `json:"fields"`
+	JobID      string    `json:"job_id,omitempty"`
+	Status     string    `json:"status"` // "launched" or "failed"
+	Error      string    `json:"error,omitempty"`
+	Suppressed int       `json:"suppressed"` // matching events deduplicated or rate limited
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
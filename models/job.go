@@ -1,12 +1,16 @@
 package models
 
 type Job struct {
-	ID             string                 `json:"id"`
-	Owner          string                 `json:"owner"`
-	StartTime      string                 `json:"start_time,omitempty"`
-	CompletionTime string                 `json:"completion_time,omitempty"`
-	Failed         int32                  `json:"failed"`
-	Completed      int32                  `json:"completed"`
-	Stdout         string                 `json:"stdout"`
-	JsonData       map[string]interface{} `json:"json_data"`
+	ID             string `json:"id"`
+	Owner          string `json:"owner"`
+	StartTime      string `json:"start_time,omitempty"`
+	CompletionTime string `json:"completion_time,omitempty"`
+	Failed         int32  `json:"failed"`
+	Completed      int32  `json:"completed"`
+	TaskRevision   int    `json:"task_revision,omitempty"`
+	CronJob        string `json:"cronjob,omitempty"` // set when spawned by a cronjob
+	// MaintenanceOverride lists the maintenance windows an admin overrode to run the job
+	MaintenanceOverride string                 `json:"maintenance_override,omitempty"`
+	Stdout              string                 `json:"stdout"`
+	JsonData            map[string]interface{} `json:"json_data"`
 }
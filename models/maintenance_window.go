@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaintenanceWindow either blocks jobs while it's active ("deny"), e.g. a change freeze,
+// or only lets them run while it's active ("allow").
+// A window is one-off when Start and End are set, or recurring when Schedule and Duration are set.
+type MaintenanceWindow struct {
+	ID          uuid.UUID  `gorm:"column:window_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name        string     `gorm:"uniqueIndex;<-:create" json:"name" binding:"required"`
+	Description string     `json:"description,omitempty"`
+	Effect      string     `json:"effect" binding:"required"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+	Schedule    string     `json:"schedule,omitempty"` // cron expression of the start of every occurrence
+	Duration    string     `json:"duration,omitempty"` // e.g. "2h30m"
+	TimeZone    string     `json:"time_zone,omitempty"`
+	// The window applies to everything when no task, runner or tag is set
+	Tasks     pq.StringArray `gorm:"type:text[]" json:"tasks"`
+	Runners   pq.StringArray `gorm:"type:text[]" json:"runners"`
+	Tags      pq.StringArray `gorm:"type:text[]" json:"tags"`
+	Disable   bool           `json:"disable"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+// RunnerManifest is read from kriten.yaml at the root of a runner repository.
+type RunnerManifest struct {
+	Version  string         `json:"version"`
+	Defaults ManifestTask   `json:"defaults"`
+	Tasks    []ManifestTask `json:"tasks"`
+}
+
+type ManifestTask struct {
+	Name              string         `json:"name"`
+	Command           string         `json:"command"`
+	Synchronous       *bool          `json:"synchronous,omitempty"`
+	Schema            map[string]any `json:"schema,omitempty"`
+	OutputSchema      map[string]any `json:"outputSchema,omitempty"`
+	UnknownProperties string         `json:"unknownProperties,omitempty"`
+	Tags              []string       `json:"tags,omitempty"`
+}
+
+type ManifestChange struct {
+	Task   string `json:"task"`
+	Action string `json:"action"` // "create", "update", "delete", "unchanged" or "skip"
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type RunnerSync struct {
+	Runner  string           `json:"runner"`
+	Branch  string           `json:"branch"`
+	Commit  string           `json:"commit"`
+	DryRun  bool             `json:"dry_run"`
+	Changes []ManifestChange `json:"changes"`
+}
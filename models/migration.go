@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// Migration records one-time data migrations already applied to the cluster.
+type Migration struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
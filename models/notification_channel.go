@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// NotificationChannel posts a message to Slack, Microsoft Teams or by email when jobs of
+// its tasks or cronjobs start or finish. Only jobs of the tasks its owner can read are notified about.
+type NotificationChannel struct {
+	ID          uuid.UUID `gorm:"column:channel_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name        string    `gorm:"uniqueIndex;<-:create" json:"name" binding:"required"`
+	Description string    `json:"description,omitempty"`
+	Type        string    `json:"type" binding:"required"` // "slack", "teams" or "email"
+	Owner       uuid.UUID `gorm:"type:uuid" json:"owner"`
+	// URL of the Slack or Teams incoming webhook, it's a secret so it's only returned on creation
+	URL        string         `gorm:"serializer:encrypted" json:"url,omitempty"`
+	Recipients pq.StringArray `gorm:"type:text[]" json:"recipients"` // email addresses
+	// The channel applies to every job the owner can read when no task or cronjob is set
+	Tasks    pq.StringArray `gorm:"type:text[]" json:"tasks"`
+	CronJobs pq.StringArray `gorm:"type:text[]" json:"cronjobs"`
+	// Job statuses notified about: "started", "succeeded" and "failed", only failures by default
+	On pq.StringArray `gorm:"type:text[]" json:"on"`
+	// Template is a Go template of the message executed against a JobNotification,
+	// the subject of emails is the first line
+	Template  string    `json:"template,omitempty"`
+	LogLines  int       `json:"log_lines,omitempty"` // lines of the job log included, 20 by default
+	Disable   bool      `json:"disable"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChannelMessage is a message to post to a channel, retried until it succeeds or runs out of attempts.
+type ChannelMessage struct {
+	ID            uuid.UUID `gorm:"column:message_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Channel       uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_channel_event" json:"channel"`
+	EventID       string    `gorm:"uniqueIndex:idx_channel_event" json:"event_id"`
+	Job           string    `json:"job"`
+	JobStatus     string    `json:"job_status"`
+	Subject       string    `json:"subject"`
+	Text          string    `json:"text"`
+	Status        string    `gorm:"index" json:"status"` // "pending", "delivered" or "failed"
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index" json:"next_attempt_at"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// JobNotification is the data channel templates are executed against.
+type JobNotification struct {
+	Channel   string
+	Job       string
+	Task      string
+	CronJob   string
+	Owner     string
+	Status    string // "started", "succeeded" or "failed"
+	Reason    string // why the job failed
+	StartTime *time.Time
+	EndTime   *time.Time
+	Duration  time.Duration
+	Link      string // job in the API, set when EXTERNAL_URL is
+	Log       string // tail of the job log, empty when the job started
+}
@@ -1,10 +1,15 @@
 package models
 
 type Runner struct {
-	Secret map[string]string `json:"secret,omitempty"`
-	Name   string            `json:"name" binding:"required"`
-	Image  string            `json:"image" binding:"required"`
-	GitURL string            `json:"gitURL" binding:"required"`
-	Token  string            `json:"token"`
-	Branch string            `json:"branch"`
+	Secret         map[string]string `json:"secret,omitempty"`
+	Name           string            `json:"name" binding:"required"`
+	Image          string            `json:"image" binding:"required"`
+	GitURL         string            `json:"gitURL" binding:"required"`
+	Token          string            `json:"token"`
+	Branch         string            `json:"branch"`
+	SecretProvider string            `json:"secretProvider,omitempty"` // "kubernetes" (default) or "vault"
+	VaultEngine    string            `json:"vaultEngine,omitempty"`    // "kv" or "dynamic"
+	VaultMount     string            `json:"vaultMount,omitempty"`
+	VaultPath      string            `json:"vaultPath,omitempty"`
+	AutoSync       bool              `json:"autoSync,omitempty"` // periodically sync tasks from kriten.yaml
 }
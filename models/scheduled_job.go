@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// ScheduledJob is a job to run once at a given time, it's launched by the scheduler of any Kriten replica.
+type ScheduledJob struct {
+	ID    uuid.UUID `gorm:"column:scheduled_job_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Task  string    `json:"task"`
+	Owner string    `json:"owner"`
+	// Roles of the owner are checked again when the job is launched
+	OwnerID   uuid.UUID `gorm:"type:uuid" json:"owner_id"`
+	ExtraVars string    `json:"extra_vars,omitempty"`
+	RunAt     time.Time `gorm:"index" json:"run_at"`
+	Status    string    `gorm:"index" json:"status"` // "pending", "launching", "launched", "failed" or "cancelled"
+	JobID     string    `json:"job_id,omitempty"`    // set once launched
+	Error     string    `json:"error,omitempty"`
+	// Set when a replica claims the job to launch it
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Subscription delivers the events it's subscribed to, as CloudEvents, to an HTTP endpoint.
+// Only events about resources its owner can read are delivered.
+type Subscription struct {
+	ID          uuid.UUID `gorm:"column:subscription_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name        string    `gorm:"uniqueIndex;<-:create" json:"name" binding:"required"`
+	Description string    `json:"description,omitempty"`
+	URL         string    `json:"url" binding:"required"`
+	Owner       uuid.UUID `gorm:"type:uuid" json:"owner"`
+	// Event types, e.g. job.failed, a trailing * matches every type with the prefix, e.g. job.*
+	Events pq.StringArray `gorm:"type:text[]" json:"events"`
+	// Secret signs deliveries with an HMAC-SHA256 of the body, it's only returned on creation
+	Secret    string    `gorm:"serializer:encrypted" json:"secret,omitempty"`
+	Disable   bool      `json:"disable"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationDelivery is an event to deliver to a subscription, retried until it succeeds or runs out of attempts.
+type NotificationDelivery struct {
+	ID            uuid.UUID `gorm:"column:notification_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Subscription  uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_notification_event" json:"subscription"`
+	EventID       string    `gorm:"uniqueIndex:idx_notification_event" json:"event_id"`
+	EventType     string    `json:"event_type"`
+	Payload       string    `json:"payload"`
+	Status        string    `gorm:"index" json:"status"` // "pending", "delivered" or "failed"
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index" json:"next_attempt_at"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// CloudEvent is a CloudEvents 1.0 event in the structured JSON format.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
@@ -1,9 +1,15 @@
 package models
 
 type Task struct {
-	Schema      map[string]any `json:"schema,omitempty"`
-	Name        string         `json:"name" binding:"required"`
-	Runner      string         `json:"runner" binding:"required"`
-	Command     string         `json:"command" binding:"required"`
-	Synchronous bool           `json:"synchronous"`
+	Schema       map[string]any `json:"schema,omitempty"`
+	OutputSchema map[string]any `json:"outputSchema,omitempty"` // schema of the JSON data returned by the job
+	// UnknownProperties sets how inputs not declared in the schema are handled: allow (default), drop or reject
+	UnknownProperties string `json:"unknownProperties,omitempty"`
+	// Tags group tasks, e.g. for maintenance windows
+	Tags        []string `json:"tags,omitempty"`
+	Name        string   `json:"name" binding:"required"`
+	Runner      string   `json:"runner" binding:"required"`
+	Command     string   `json:"command" binding:"required"`
+	Synchronous bool     `json:"synchronous"`
+	Revision    int      `json:"revision,omitempty"` // set from the resource labels, not part of the spec
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TaskRevision is an immutable snapshot of a task, a new one is stored every time the task changes.
+type TaskRevision struct {
+	ID        uuid.UUID `gorm:"column:revision_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Task      string    `gorm:"uniqueIndex:idx_task_revision,priority:1" json:"task"`
+	Revision  int       `gorm:"uniqueIndex:idx_task_revision,priority:2" json:"revision"`
+	Spec      Task      `gorm:"type:jsonb;serializer:json" json:"spec"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskRevisionChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+type TaskRevisionDiff struct {
+	Task    string               `json:"task"`
+	From    int                  `json:"from"`
+	To      int                  `json:"to"`
+	Changes []TaskRevisionChange `json:"changes"`
+}
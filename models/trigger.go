@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Trigger runs a task on events, either CloudEvents sent over HTTP, messages consumed
+// from a NATS subject or a Kafka topic, or changes of Kubernetes resources. Jobs run as the trigger owner.
+type Trigger struct {
+	ID          uuid.UUID `gorm:"column:trigger_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name        string    `gorm:"uniqueIndex;<-:create" json:"name" binding:"required"`
+	Description string    `json:"description,omitempty"`
+	Owner       uuid.UUID `gorm:"type:uuid" json:"owner"`
+	Task        string    `json:"task" binding:"required"`
+	Source      string    `json:"source" binding:"required"` // "http", "nats", "kafka" or "kubernetes"
+	// NATS server URLs or Kafka brokers
+	Servers pq.StringArray `gorm:"type:text[]" json:"servers"`
+	Subject string         `json:"subject,omitempty"` // NATS subject, wildcards are allowed
+	Topic   string         `json:"topic,omitempty"`   // Kafka topic
+	// NATS queue group or Kafka consumer group, every event is handled by a single replica.
+	// Defaults to kriten-<name>
+	Group string `json:"group,omitempty"`
+	// Kubernetes resource watched as apiVersion/resource, e.g. v1/configmaps or apps/v1/deployments,
+	// in Namespace or all namespaces when empty, with the objects matching the selectors
+	Resource      string `json:"resource,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	LabelSelector string `json:"label_selector,omitempty"`
+	FieldSelector string `json:"field_selector,omitempty"`
+	// CloudEvents types matched, a trailing * matches every type with the prefix, all types when empty.
+	// Kubernetes triggers match "add", "update" and "delete"
+	Types pq.StringArray `gorm:"type:text[]" json:"types"`
+	// Filter is a Go template executed against the event, rendering true or false to decide whether the task runs.
+	// Mapping is a JSON object of the task extra vars whose string values can be Go templates, the event data by default
+	Filter  string `json:"filter,omitempty"`
+	Mapping string `json:"mapping,omitempty"`
+	// Secret authenticates HTTP events, it's only returned on creation
+	Secret    string    `gorm:"serializer:encrypted" json:"secret,omitempty"`
+	Disable   bool      `json:"disable"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TriggerEvent records a Kubernetes event handled by a trigger, so replicas watching
+// the same resource run the task once.
+type TriggerEvent struct {
+	Trigger   uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_trigger_event" json:"trigger"`
+	EventID   string    `gorm:"uniqueIndex:idx_trigger_event" json:"event_id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
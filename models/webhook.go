@@ -9,9 +9,24 @@ import (
 type Webhook struct {
 	ID          uuid.UUID `gorm:"column:id;type:uuid;default:gen_random_uuid()" json:"id"`
 	Owner       uuid.UUID `gorm:"type:uuid" json:"owner"`
-	Secret      string    `json:"secret,omitempty"`
+	Secret      string    `gorm:"serializer:encrypted" json:"secret,omitempty"`
 	Description string    `json:"description,omitempty"`
 	Task        string    `json:"task,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	// SignatureScheme selects how requests are verified, the legacy headers are detected when empty
+	SignatureScheme string `json:"signature_scheme,omitempty"`
+	// Generic HMAC settings, only used by the hmac scheme
+	SignatureHeader    string `json:"signature_header,omitempty"`
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	SignatureEncoding  string `json:"signature_encoding,omitempty"`
+	SignaturePrefix    string `json:"signature_prefix,omitempty"`
+	// Filter is a Go template executed against the payload, rendering true or false to decide whether the task runs.
+	// Mapping is a JSON object of the task extra vars whose string values can be Go templates, a value made of
+	// a single action keeps its JSON type, e.g. {"device": "{{ .data.name }}", "ports": "{{ .data.ports }}"}
+	Mapping string `json:"mapping,omitempty"`
+	Filter  string `json:"filter,omitempty"`
+	// After a rotation the previous secret still verifies requests until it expires
+	PreviousSecret          string     `gorm:"serializer:encrypted" json:"-"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	UpdatedAt               time.Time  `json:"updated_at"`
 }
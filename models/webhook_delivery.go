@@ -0,0 +1,23 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WebhookDelivery records a request received by a webhook and what it resulted in.
+type WebhookDelivery struct {
+	ID           uuid.UUID   `gorm:"column:delivery_id;type:uuid;default:gen_random_uuid()" json:"id"`
+	Webhook      uuid.UUID   `gorm:"type:uuid;index" json:"webhook"`
+	Status       string      `json:"status"` // "launched", "filtered", "failed" or "rejected"
+	Headers      http.Header `gorm:"type:jsonb;serializer:json" json:"headers,omitempty"`
+	Verification string      `json:"verification"`         // how the request was verified, or why it wasn't
+	Payload      string      `json:"payload,omitempty"`    // body as received
+	ExtraVars    string      `json:"extra_vars,omitempty"` // task inputs after mapping
+	JobID        string      `json:"job_id,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	RedeliveryOf *uuid.UUID  `gorm:"type:uuid" json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WebhookMessage records a message delivered to a webhook, by a signed header or its body hash, so replayed
+// requests are rejected until it expires.
+type WebhookMessage struct {
+	WebhookID uuid.UUID `gorm:"type:uuid;primaryKey" json:"webhook_id"`
+	MessageID string    `gorm:"primaryKey" json:"message_id"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
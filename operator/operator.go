@@ -0,0 +1,166 @@
+// Package operator reconciles Kriten custom resources declared outside of the REST API,
+// e.g. with kubectl or a GitOps tool, through the existing services.
+package operator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/services"
+
+	"github.com/go-logr/stdr"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	finalizer    = "kriten.io/finalizer"
+	resyncPeriod = 10 * time.Minute
+	leaseName    = "kriten-operator"
+	workers      = 2
+)
+
+type reconcileKey struct {
+	kind string
+	name string
+}
+
+type Operator struct {
+	TaskService        services.TaskService
+	CronJobService     services.CronJobService
+	WebhookService     services.WebhookService
+	RoleBindingService services.RoleBindingService
+	UserService        services.UserService
+	config             config.Config
+	manager            ctrl.Manager
+}
+
+// NewOperator sets up a controller-runtime manager with a controller per Kriten kind, only the replica
+// holding the lease reconciles resources.
+func NewOperator(
+	kubeConfig *rest.Config,
+	config config.Config,
+	ts services.TaskService,
+	cjs services.CronJobService,
+	ws services.WebhookService,
+	rbs services.RoleBindingService,
+	us services.UserService,
+) (*Operator, error) {
+	ctrl.SetLogger(stdr.New(log.Default()))
+
+	scheme := runtime.NewScheme()
+	err := clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	resync := resyncPeriod
+	manager, err := ctrl.NewManager(kubeConfig, ctrl.Options{
+		Scheme: scheme,
+		Cache: cache.Options{
+			DefaultNamespaces: map[string]cache.Config{config.Kube.Namespace: {}},
+			SyncPeriod:        &resync,
+		},
+		LeaderElection:                true,
+		LeaderElectionID:              leaseName,
+		LeaderElectionNamespace:       config.Kube.Namespace,
+		LeaderElectionReleaseOnCancel: true,
+		Metrics:                       metricsserver.Options{BindAddress: "0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Operator{
+		TaskService:        ts,
+		CronJobService:     cjs,
+		WebhookService:     ws,
+		RoleBindingService: rbs,
+		UserService:        us,
+		config:             config,
+		manager:            manager,
+	}
+
+	for kind, res := range helpers.ResourceKinds {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   res.Resource.Group,
+			Version: res.Resource.Version,
+			Kind:    res.Kind,
+		})
+
+		builder := ctrl.NewControllerManagedBy(manager).
+			Named(res.Resource.Resource).
+			For(obj).
+			WithOptions(controller.Options{MaxConcurrentReconciles: workers})
+		// CronJobs edited directly are restored from their KritenCronJob
+		if kind == helpers.KindCronJob {
+			builder = builder.Watches(&batchv1.CronJob{}, handler.EnqueueRequestsFromMapFunc(driftedCronJobOwner))
+		}
+
+		err = builder.Complete(&kindReconciler{operator: o, kind: kind})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// Start runs the operator until the context is cancelled or the lease is lost.
+func (o *Operator) Start(ctx context.Context) {
+	log.Println("Operator started")
+	err := o.manager.Start(ctx)
+	if err != nil {
+		log.Printf("Operator stopped: %v\n", err)
+	}
+}
+
+// kindReconciler reconciles the resources of a Kriten kind, failed reconciliations are requeued
+// with a backoff by the controller.
+type kindReconciler struct {
+	operator *Operator
+	kind     string
+}
+
+func (r *kindReconciler) Reconcile(_ context.Context, req reconcile.Request) (reconcile.Result, error) {
+	err := r.operator.reconcile(reconcileKey{kind: r.kind, name: req.Name})
+	if err != nil {
+		log.Printf("Operator failed to reconcile %s %s: %v\n", r.kind, req.Name, err)
+	}
+
+	return reconcile.Result{}, err
+}
+
+// driftedCronJobOwner reconciles the KritenCronJob owning a CronJob whose spec was changed by someone else.
+func driftedCronJobOwner(_ context.Context, obj client.Object) []reconcile.Request {
+	cron, ok := obj.(*batchv1.CronJob)
+	if !ok || !helpers.CronJobDrifted(cron) {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, owner := range cron.GetOwnerReferences() {
+		if owner.APIVersion == helpers.KritenAPIVersion && owner.Kind == helpers.ResourceKinds[helpers.KindCronJob].Kind {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKey{Namespace: cron.Namespace, Name: owner.Name},
+			})
+		}
+	}
+
+	return requests
+}
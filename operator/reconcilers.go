@@ -0,0 +1,224 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+	"github.com/kriten-io/kriten/services"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type webhookSpec struct {
+	Task          string `json:"task"`
+	Owner         string `json:"owner"`
+	OwnerProvider string `json:"ownerProvider"`
+	Description   string `json:"description"`
+	// Signature settings, see models.Webhook
+	SignatureScheme    string `json:"signatureScheme"`
+	SignatureHeader    string `json:"signatureHeader"`
+	SignatureAlgorithm string `json:"signatureAlgorithm"`
+	SignatureEncoding  string `json:"signatureEncoding"`
+	SignaturePrefix    string `json:"signaturePrefix"`
+	Mapping            string `json:"mapping"`
+	Filter             string `json:"filter"`
+	SecretRef          struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	} `json:"secretRef"`
+}
+
+func (o *Operator) reconcile(key reconcileKey) error {
+	obj, err := helpers.GetResource(o.config.Kube, key.kind, key.name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// webhooks and role bindings live in the database, finalizers make sure rows are
+	// removed before their resource is deleted.
+	hasFinalizer := key.kind == helpers.KindWebhook || key.kind == helpers.KindRoleBinding
+	if hasFinalizer && obj.GetDeletionTimestamp() != nil {
+		return o.finalize(key.kind, obj)
+	}
+	if hasFinalizer && !slices.Contains(obj.GetFinalizers(), finalizer) {
+		obj.SetFinalizers(append(obj.GetFinalizers(), finalizer))
+		obj, err = helpers.UpdateResource(o.config.Kube, key.kind, obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch key.kind {
+	case helpers.KindRunner:
+		err = o.reconcileRunner(obj)
+	case helpers.KindTask:
+		err = o.reconcileTask(obj)
+	case helpers.KindCronJob:
+		err = o.CronJobService.SyncCronJob(obj.GetName())
+	case helpers.KindWebhook:
+		err = o.reconcileWebhook(obj)
+	case helpers.KindRoleBinding:
+		err = o.reconcileRoleBinding(obj)
+	}
+
+	if err != nil {
+		_ = helpers.UpdateResourceStatus(o.config.Kube, key.kind, obj, "Error", err.Error())
+		return err
+	}
+
+	return helpers.UpdateResourceStatus(o.config.Kube, key.kind, obj, "Ready", "")
+}
+
+func (o *Operator) reconcileRunner(obj *unstructured.Unstructured) error {
+	var runner models.Runner
+	err := helpers.ResourceToModel(obj, &runner)
+	if err != nil {
+		return err
+	}
+
+	provider, err := services.NewSecretProvider(o.config, runner.SecretProvider)
+	if err != nil {
+		return err
+	}
+
+	return provider.Validate(&runner)
+}
+
+func (o *Operator) reconcileTask(obj *unstructured.Unstructured) error {
+	var task models.Task
+	err := helpers.ResourceToModel(obj, &task)
+	if err != nil {
+		return err
+	}
+
+	err = o.TaskService.CheckTask(task)
+	if err != nil {
+		return err
+	}
+
+	return o.TaskService.SyncRevision(task.Name)
+}
+
+func (o *Operator) reconcileWebhook(obj *unstructured.Unstructured) error {
+	var spec webhookSpec
+	err := helpers.ResourceToModel(obj, &spec)
+	if err != nil {
+		return err
+	}
+	if spec.OwnerProvider == "" {
+		spec.OwnerProvider = "local"
+	}
+	if spec.SecretRef.Key == "" {
+		spec.SecretRef.Key = "secret"
+	}
+
+	owner, err := o.UserService.GetByUsernameAndProvider(spec.Owner, spec.OwnerProvider)
+	if err != nil {
+		return fmt.Errorf("owner %s not found: %w", spec.Owner, err)
+	}
+
+	_, err = o.TaskService.GetTask(spec.Task)
+	if err != nil {
+		return fmt.Errorf("task %s not found", spec.Task)
+	}
+
+	secret, err := helpers.GetSecret(o.config.Kube, spec.SecretRef.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook secret: %w", err)
+	}
+	if len(secret.Data[spec.SecretRef.Key]) == 0 {
+		return fmt.Errorf("key %s not found in secret %s", spec.SecretRef.Key, spec.SecretRef.Name)
+	}
+
+	// the webhook ID matches the resource UID, so it's stable across reconciliations
+	webhook := models.Webhook{
+		ID:          uuid.FromStringOrNil(string(obj.GetUID())),
+		Owner:       owner.ID,
+		Secret:      string(secret.Data[spec.SecretRef.Key]),
+		Description: spec.Description,
+		Task:        spec.Task,
+
+		SignatureScheme:    spec.SignatureScheme,
+		SignatureHeader:    spec.SignatureHeader,
+		SignatureAlgorithm: spec.SignatureAlgorithm,
+		SignatureEncoding:  spec.SignatureEncoding,
+		SignaturePrefix:    spec.SignaturePrefix,
+		Mapping:            spec.Mapping,
+		Filter:             spec.Filter,
+	}
+
+	_, err = o.WebhookService.GetWebhook(webhook.ID.String())
+	if err != nil {
+		_, err = o.WebhookService.CreateWebhook(webhook)
+	} else {
+		_, err = o.WebhookService.UpdateWebhook(webhook)
+	}
+	if err != nil {
+		return err
+	}
+
+	return unstructured.SetNestedField(obj.Object, webhook.ID.String(), "status", "webhookID")
+}
+
+func (o *Operator) reconcileRoleBinding(obj *unstructured.Unstructured) error {
+	var roleBinding models.RoleBinding
+	err := helpers.ResourceToModel(obj, &roleBinding)
+	if err != nil {
+		return err
+	}
+	if roleBinding.SubjectProvider == "" {
+		roleBinding.SubjectProvider = "local"
+	}
+
+	current, err := o.RoleBindingService.GetRoleBinding(roleBinding.Name)
+	if err != nil {
+		_, err = o.RoleBindingService.CreateRoleBinding(roleBinding)
+		return err
+	}
+
+	if current.Builtin {
+		return errors.New("cannot update builtin resource")
+	}
+	roleBinding.ID = current.ID
+	_, err = o.RoleBindingService.UpdateRoleBinding(roleBinding)
+	return err
+}
+
+func (o *Operator) finalize(kind string, obj *unstructured.Unstructured) error {
+	if !slices.Contains(obj.GetFinalizers(), finalizer) {
+		return nil
+	}
+
+	var err error
+	switch kind {
+	case helpers.KindWebhook:
+		id := uuid.FromStringOrNil(string(obj.GetUID()))
+		if _, err = o.WebhookService.GetWebhook(id.String()); err == nil {
+			err = o.WebhookService.DeleteWebhook(id.String())
+		} else {
+			err = nil
+		}
+	case helpers.KindRoleBinding:
+		if _, err = o.RoleBindingService.GetRoleBinding(obj.GetName()); err == nil {
+			err = o.RoleBindingService.DeleteRoleBinding(obj.GetName())
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	finalizers := slices.DeleteFunc(obj.GetFinalizers(), func(f string) bool { return f == finalizer })
+	obj.SetFinalizers(finalizers)
+	_, err = helpers.UpdateResource(o.config.Kube, kind, obj)
+	return err
+}
@@ -20,14 +20,16 @@ type AuditService interface {
 }
 
 type AuditServiceImpl struct {
-	db     *gorm.DB
-	config config.Config
+	db                  *gorm.DB
+	NotificationService NotificationService
+	config              config.Config
 }
 
-func NewAuditService(database *gorm.DB, config config.Config) AuditService {
+func NewAuditService(database *gorm.DB, ns NotificationService, config config.Config) AuditService {
 	return &AuditServiceImpl{
-		db:     database,
-		config: config,
+		db:                  database,
+		NotificationService: ns,
+		config:              config,
 	}
 }
 
@@ -55,11 +57,14 @@ func (a *AuditServiceImpl) GetAuditLog(id string) (models.AuditLog, error) {
 	return log, nil
 }
 
+// CreateAudit stores an audit log and notifies the subscriptions to its event.
 func (a *AuditServiceImpl) CreateAudit(auditlog models.AuditLog) {
 	res := a.db.Create(&auditlog)
 	if res.Error != nil {
 		log.Println("Error during Audit creation: " + res.Error.Error())
 	}
+
+	a.NotificationService.NotifyAudit(auditlog)
 }
 
 func (a *AuditServiceImpl) InitialiseAuditLog(
@@ -1,11 +1,6 @@
 package services
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"crypto/sha512"
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
@@ -28,8 +23,6 @@ type AuthService interface {
 	IsAutorised(*models.Authorization) (bool, error)
 	GetAuthorizationList(*models.Authorization) ([]string, error)
 	ValidateAPIToken(string) (models.User, error)
-	ValidateWebhookSignatureInfraHub(string, string, string, string, []byte) (models.User, string, error)
-	ValidateWebhookSignatureCommon(string, string, []byte) (models.User, string, error)
 }
 
 type AuthServiceImpl struct {
@@ -177,83 +170,6 @@ func (a *AuthServiceImpl) ValidateAPIToken(key string) (models.User, error) {
 	return user, nil
 }
 
-func (a *AuthServiceImpl) ValidateWebhookSignatureInfraHub(
-	id string,
-	msgID string,
-	msgTimestamp string,
-	signature string,
-	body []byte,
-) (models.User, string, error) {
-	// Splitting the signature to get the to remove prepended "v1," from InfraHub
-	split := strings.Split(signature, ",")
-	if len(split) != 2 {
-		return models.User{}, "", errors.New("invalid signature")
-	}
-	signature = split[1]
-	data := []byte(fmt.Sprintf("%s.%s.", msgID, msgTimestamp))
-	data = append(data, body...)
-
-	var webhook models.Webhook
-	res := a.db.Where("id = ?", id).Find(&webhook)
-	if res.Error != nil {
-		return models.User{}, "", res.Error
-	}
-	// checking if there's any result
-	if res.RowsAffected == 0 {
-		return models.User{}, "", errors.New("invalid webhook")
-	}
-	// checking if the signature is valid
-	// Validating the signature
-
-	h := hmac.New(sha256.New, []byte(webhook.Secret))
-	h.Write(data)
-	expectedSignature := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return models.User{}, "", errors.New("invalid signature")
-	}
-
-	var user models.User
-	res = a.db.Where("user_id = ?", webhook.Owner).Find(&user)
-	if res.Error != nil {
-		return models.User{}, "", res.Error
-	}
-	return user, webhook.Task, nil
-}
-
-func (a *AuthServiceImpl) ValidateWebhookSignatureCommon(
-	id string,
-	signature string,
-	body []byte,
-) (models.User, string, error) {
-	var webhook models.Webhook
-	res := a.db.Where("id = ?", id).Find(&webhook)
-	if res.Error != nil {
-		return models.User{}, "", res.Error
-	}
-	// checking if there's any result
-	if res.RowsAffected == 0 {
-		return models.User{}, "", errors.New("invalid webhook")
-	}
-	// checking if the signature is valid
-	// Validating the signature
-
-	h := hmac.New(sha512.New, []byte(webhook.Secret))
-	h.Write(body)
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
-
-	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return models.User{}, "", errors.New("invalid signature")
-	}
-
-	var user models.User
-	res = a.db.Where("user_id = ?", webhook.Owner).Find(&user)
-	if res.Error != nil {
-		return models.User{}, "", res.Error
-	}
-	return user, webhook.Task, nil
-}
-
 func (a *AuthServiceImpl) IsAutorised(auth *models.Authorization) (bool, error) {
 	// Checking if the user owns the API token
 	if auth.Resource == "apiTokens" {
@@ -273,17 +189,22 @@ func (a *AuthServiceImpl) IsAutorised(auth *models.Authorization) (bool, error)
 		return false, err
 	}
 
+	return rolesGrant(roles, auth.Resource, auth.ResourceID, auth.Access), nil
+}
+
+// rolesGrant tells whether one of the roles grants an access to a resource, "write" includes "read".
+func rolesGrant(roles []models.Role, resource string, resourceID string, access string) bool {
 	for i := range roles {
 		role := &roles[i]
-		if role.Resource == "*" || role.Resource == auth.Resource &&
+		if role.Resource == "*" || role.Resource == resource &&
 			(len(role.Resource_IDs) > 0 && role.Resource_IDs[0] == "*" ||
-				slices.Contains(role.Resource_IDs, auth.ResourceID)) &&
-			(role.Access == auth.Access || role.Access == "write") {
-			return true, nil
+				slices.Contains(role.Resource_IDs, resourceID)) &&
+			(role.Access == access || role.Access == "write") {
+			return true
 		}
 	}
 
-	return false, nil
+	return false
 }
 
 func (a *AuthServiceImpl) GetAuthorizationList(auth *models.Authorization) ([]string, error) {
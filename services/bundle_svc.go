@@ -0,0 +1,734 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	ImportModeCreate    = "create"
+	ImportModeOverwrite = "overwrite"
+	ImportModePrune     = "prune"
+)
+
+type BundleService interface {
+	Export(string) (models.Bundle, error)
+	Import(models.Bundle, string, string, bool) (models.BundleImportResult, error)
+}
+
+type BundleServiceImpl struct {
+	RunnerService      RunnerService
+	TaskService        TaskService
+	CronJobService     CronJobService
+	GroupService       GroupService
+	RoleService        RoleService
+	RoleBindingService RoleBindingService
+	WebhookService     WebhookService
+	UserService        UserService
+	config             config.Config
+}
+
+func NewBundleService(
+	config config.Config,
+	rs RunnerService,
+	ts TaskService,
+	cjs CronJobService,
+	gs GroupService,
+	rls RoleService,
+	rbs RoleBindingService,
+	ws WebhookService,
+	us UserService,
+) BundleService {
+	return &BundleServiceImpl{
+		RunnerService:      rs,
+		TaskService:        ts,
+		CronJobService:     cjs,
+		GroupService:       gs,
+		RoleService:        rls,
+		RoleBindingService: rbs,
+		WebhookService:     ws,
+		UserService:        us,
+		config:             config,
+	}
+}
+
+// bundleStep is a single change of an import, apply is nil when nothing has to be done.
+type bundleStep struct {
+	change models.BundleChange
+	apply  func() error
+}
+
+// Export returns the full configuration, secrets are only included when a passphrase is provided.
+func (b *BundleServiceImpl) Export(passphrase string) (models.Bundle, error) {
+	bundle := models.Bundle{
+		Version:    models.BundleVersion,
+		ExportedAt: time.Now().UTC(),
+	}
+	secrets := models.BundleSecrets{
+		Runners:  map[string]models.BundleRunnerSecrets{},
+		Webhooks: map[string]string{},
+	}
+	all := []string{"*"}
+
+	runners, err := b.RunnerService.ListRunners(all)
+	if err != nil {
+		return bundle, err
+	}
+	for _, runner := range runners {
+		if passphrase != "" {
+			runnerSecrets, err := b.runnerSecrets(runner.Name)
+			if err != nil {
+				return bundle, err
+			}
+			if runnerSecrets.Token != "" || runnerSecrets.Secret != nil {
+				secrets.Runners[runner.Name] = runnerSecrets
+			}
+		}
+		runner.Token = ""
+		runner.Secret = nil
+		bundle.Runners = append(bundle.Runners, runner)
+	}
+
+	tasks, err := b.TaskService.ListTasks(all)
+	if err != nil {
+		return bundle, err
+	}
+	for _, task := range tasks {
+		// revisions are local to each installation
+		task.Revision = 0
+		bundle.Tasks = append(bundle.Tasks, *task)
+	}
+
+	bundle.CronJobs, err = b.CronJobService.ListCronJobs(all)
+	if err != nil {
+		return bundle, err
+	}
+
+	groups, err := b.GroupService.ListGroups(all)
+	if err != nil {
+		return bundle, err
+	}
+	for _, group := range groups {
+		if group.Builtin {
+			continue
+		}
+		// users are not part of the bundle, memberships can't be carried over
+		group.Users = nil
+		group.CreatedAt, group.UpdatedAt = time.Time{}, time.Time{}
+		bundle.Groups = append(bundle.Groups, group)
+	}
+
+	roles, err := b.RoleService.ListRoles(all)
+	if err != nil {
+		return bundle, err
+	}
+	for _, role := range roles {
+		if role.Builtin {
+			continue
+		}
+		role.CreatedAt, role.UpdatedAt = time.Time{}, time.Time{}
+		bundle.Roles = append(bundle.Roles, role)
+	}
+
+	roleBindings, err := b.RoleBindingService.ListRoleBindings(all, nil)
+	if err != nil {
+		return bundle, err
+	}
+	for _, roleBinding := range roleBindings {
+		if roleBinding.Builtin {
+			continue
+		}
+		roleBinding.CreatedAt, roleBinding.UpdatedAt = time.Time{}, time.Time{}
+		bundle.RoleBindings = append(bundle.RoleBindings, roleBinding)
+	}
+
+	webhooks, err := b.WebhookService.ListAllWebhooks(all)
+	if err != nil {
+		return bundle, err
+	}
+	for _, webhook := range webhooks {
+		owner, err := b.UserService.GetUser(webhook.Owner.String())
+		if err != nil {
+			return bundle, fmt.Errorf("webhook %s: %w", webhook.ID, err)
+		}
+		bundle.Webhooks = append(bundle.Webhooks, models.BundleWebhook{
+			ID:            webhook.ID,
+			Owner:         owner.Username,
+			OwnerProvider: owner.Provider,
+			Description:   webhook.Description,
+			Task:          webhook.Task,
+
+			SignatureScheme:    webhook.SignatureScheme,
+			SignatureHeader:    webhook.SignatureHeader,
+			SignatureAlgorithm: webhook.SignatureAlgorithm,
+			SignatureEncoding:  webhook.SignatureEncoding,
+			SignaturePrefix:    webhook.SignaturePrefix,
+			Mapping:            webhook.Mapping,
+			Filter:             webhook.Filter,
+		})
+		if passphrase != "" {
+			secrets.Webhooks[webhook.ID.String()] = webhook.Secret
+		}
+	}
+
+	if passphrase != "" {
+		data, err := json.Marshal(secrets)
+		if err != nil {
+			return bundle, err
+		}
+		bundle.Secrets, err = helpers.EncryptWithPassphrase(data, passphrase)
+		if err != nil {
+			return bundle, err
+		}
+	}
+
+	return bundle, nil
+}
+
+// Import applies a bundle following the dependencies between resources, when dryRun is set
+// the changes are only computed and returned.
+func (b *BundleServiceImpl) Import(bundle models.Bundle, mode string, passphrase string, dryRun bool) (models.BundleImportResult, error) {
+	result := models.BundleImportResult{
+		Mode:   mode,
+		DryRun: dryRun,
+	}
+
+	if bundle.Version != models.BundleVersion {
+		return result, fmt.Errorf("unsupported bundle version %q, expected %q", bundle.Version, models.BundleVersion)
+	}
+	if !slices.Contains([]string{ImportModeCreate, ImportModeOverwrite, ImportModePrune}, mode) {
+		return result, fmt.Errorf("invalid import mode %q, allowed: create, overwrite, prune", mode)
+	}
+
+	var secrets models.BundleSecrets
+	if bundle.Secrets != "" {
+		if passphrase == "" {
+			return result, errors.New("bundle contains secrets, please provide the passphrase")
+		}
+		data, err := helpers.DecryptWithPassphrase(bundle.Secrets, passphrase)
+		if err != nil {
+			return result, err
+		}
+		err = json.Unmarshal(data, &secrets)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	var steps []bundleStep
+	planners := []func(models.Bundle, models.BundleSecrets, string) ([]bundleStep, error){
+		b.planRunners,
+		b.planTasks,
+		b.planCronJobs,
+		b.planGroups,
+		b.planRoles,
+		b.planRoleBindings,
+		b.planWebhooks,
+	}
+	for _, plan := range planners {
+		planned, err := plan(bundle, secrets, mode)
+		if err != nil {
+			return result, err
+		}
+		steps = append(steps, planned...)
+	}
+
+	if mode == ImportModePrune {
+		pruned, err := b.planPrune(bundle)
+		if err != nil {
+			return result, err
+		}
+		steps = append(steps, pruned...)
+	}
+
+	failed := false
+	for _, step := range steps {
+		if !dryRun && step.apply != nil {
+			if err := step.apply(); err != nil {
+				step.change.Error = err.Error()
+				failed = true
+			}
+		}
+		result.Changes = append(result.Changes, step.change)
+	}
+
+	if failed {
+		return result, errors.New("some changes could not be applied")
+	}
+
+	return result, nil
+}
+
+func (b *BundleServiceImpl) runnerSecrets(name string) (models.BundleRunnerSecrets, error) {
+	var runnerSecrets models.BundleRunnerSecrets
+
+	token, err := helpers.GetSecret(b.config.Kube, name+"-token")
+	if err != nil && !kerrors.IsNotFound(err) {
+		return runnerSecrets, err
+	} else if err == nil {
+		runnerSecrets.Token = string(token.Data["token"])
+	}
+
+	secret, err := helpers.GetSecret(b.config.Kube, name)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return runnerSecrets, err
+	} else if err == nil && len(secret.Data) != 0 {
+		runnerSecrets.Secret = map[string]string{}
+		for k, v := range secret.Data {
+			runnerSecrets.Secret[k] = string(v)
+		}
+	}
+
+	return runnerSecrets, nil
+}
+
+func (b *BundleServiceImpl) planRunners(bundle models.Bundle, secrets models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.RunnerService.ListRunners([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, runner := range bundle.Runners {
+		runner := runner
+		runnerSecrets, hasSecrets := secrets.Runners[runner.Name]
+		runner.Token = runnerSecrets.Token
+		runner.Secret = runnerSecrets.Secret
+
+		idx := slices.IndexFunc(current, func(r models.Runner) bool { return r.Name == runner.Name })
+		if idx == -1 {
+			steps = append(steps, b.step(helpers.KindRunner, runner.Name, "create", func() error {
+				_, err := b.RunnerService.CreateRunner(runner)
+				return err
+			}))
+			continue
+		}
+
+		existing := current[idx]
+		existing.Token, existing.Secret = "", nil
+		bundled := runner
+		bundled.Token, bundled.Secret = "", nil
+		if !hasSecrets && sameSpec(existing, bundled) {
+			steps = append(steps, b.step(helpers.KindRunner, runner.Name, "unchanged", nil))
+			continue
+		}
+		if mode == ImportModeCreate {
+			steps = append(steps, b.skip(helpers.KindRunner, runner.Name, "already exists"))
+			continue
+		}
+
+		if runner.Token == "" {
+			// an empty token would remove the existing one
+			runner.Token = "************"
+		}
+		steps = append(steps, b.step(helpers.KindRunner, runner.Name, "update", func() error {
+			_, err := b.RunnerService.UpdateRunner(runner)
+			return err
+		}))
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planTasks(bundle models.Bundle, _ models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.TaskService.ListTasks([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range bundle.Tasks {
+		task := task
+		task.Revision = 0
+		idx := slices.IndexFunc(current, func(t *models.Task) bool { return t.Name == task.Name })
+		if idx != -1 {
+			current[idx].Revision = 0
+		}
+		switch {
+		case idx == -1:
+			steps = append(steps, b.step(helpers.KindTask, task.Name, "create", func() error {
+				_, err := b.TaskService.CreateTask(task)
+				return err
+			}))
+		case sameSpec(*current[idx], task):
+			steps = append(steps, b.step(helpers.KindTask, task.Name, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip(helpers.KindTask, task.Name, "already exists"))
+		default:
+			steps = append(steps, b.step(helpers.KindTask, task.Name, "update", func() error {
+				_, err := b.TaskService.UpdateTask(task)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planCronJobs(bundle models.Bundle, _ models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.CronJobService.ListCronJobs([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cronjob := range bundle.CronJobs {
+		cronjob := cronjob
+		idx := slices.IndexFunc(current, func(c models.CronJob) bool { return c.Name == cronjob.Name })
+		switch {
+		case idx == -1:
+			steps = append(steps, b.step(helpers.KindCronJob, cronjob.Name, "create", func() error {
+				_, err := b.CronJobService.CreateCronJob(cronjob)
+				return err
+			}))
+		case sameSpec(current[idx], cronjob):
+			steps = append(steps, b.step(helpers.KindCronJob, cronjob.Name, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip(helpers.KindCronJob, cronjob.Name, "already exists"))
+		default:
+			steps = append(steps, b.step(helpers.KindCronJob, cronjob.Name, "update", func() error {
+				_, err := b.CronJobService.UpdateCronJob(cronjob)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planGroups(bundle models.Bundle, _ models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.GroupService.ListGroups([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range bundle.Groups {
+		group := group
+		group.Users = nil
+		group.Builtin = false
+		idx := slices.IndexFunc(current, func(g models.Group) bool { return g.Name == group.Name })
+		switch {
+		case idx == -1:
+			steps = append(steps, b.step("group", group.Name, "create", func() error {
+				_, err := b.GroupService.CreateGroup(group)
+				return err
+			}))
+		case current[idx].Builtin:
+			steps = append(steps, b.skip("group", group.Name, "builtin resource"))
+		case sameSpec(groupSpec(current[idx]), groupSpec(group)):
+			steps = append(steps, b.step("group", group.Name, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip("group", group.Name, "already exists"))
+		default:
+			group.ID = current[idx].ID
+			steps = append(steps, b.step("group", group.Name, "update", func() error {
+				_, err := b.GroupService.UpdateGroup(group)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planRoles(bundle models.Bundle, _ models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.RoleService.ListRoles([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range bundle.Roles {
+		role := role
+		role.Builtin = false
+		idx := slices.IndexFunc(current, func(r models.Role) bool { return r.Name == role.Name })
+		if idx == -1 {
+			steps = append(steps, b.step("role", role.Name, "create", func() error {
+				_, err := b.RoleService.CreateRole(role)
+				return err
+			}))
+			continue
+		}
+
+		existing := current[idx]
+		switch {
+		case existing.Builtin:
+			steps = append(steps, b.skip("role", role.Name, "builtin resource"))
+		case existing.Resource == role.Resource && existing.Access == role.Access &&
+			slices.Equal(existing.Resource_IDs, role.Resource_IDs):
+			steps = append(steps, b.step("role", role.Name, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip("role", role.Name, "already exists"))
+		default:
+			role.ID = existing.ID
+			steps = append(steps, b.step("role", role.Name, "update", func() error {
+				_, err := b.RoleService.UpdateRole(role)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planRoleBindings(bundle models.Bundle, _ models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.RoleBindingService.ListRoleBindings([]string{"*"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roleBinding := range bundle.RoleBindings {
+		roleBinding := roleBinding
+		roleBinding.Builtin = false
+		idx := slices.IndexFunc(current, func(r models.RoleBinding) bool { return r.Name == roleBinding.Name })
+		if idx == -1 {
+			steps = append(steps, b.step("role_binding", roleBinding.Name, "create", func() error {
+				_, err := b.RoleBindingService.CreateRoleBinding(roleBinding)
+				return err
+			}))
+			continue
+		}
+
+		existing := current[idx]
+		switch {
+		case existing.Builtin:
+			steps = append(steps, b.skip("role_binding", roleBinding.Name, "builtin resource"))
+		case existing.RoleName == roleBinding.RoleName && existing.SubjectKind == roleBinding.SubjectKind &&
+			existing.SubjectName == roleBinding.SubjectName && existing.SubjectProvider == roleBinding.SubjectProvider:
+			steps = append(steps, b.step("role_binding", roleBinding.Name, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip("role_binding", roleBinding.Name, "already exists"))
+		default:
+			roleBinding.ID = existing.ID
+			steps = append(steps, b.step("role_binding", roleBinding.Name, "update", func() error {
+				_, err := b.RoleBindingService.UpdateRoleBinding(roleBinding)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) planWebhooks(bundle models.Bundle, secrets models.BundleSecrets, mode string) ([]bundleStep, error) {
+	var steps []bundleStep
+
+	current, err := b.WebhookService.ListAllWebhooks([]string{"*"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bundled := range bundle.Webhooks {
+		id := bundled.ID.String()
+		owner, err := b.UserService.GetByUsernameAndProvider(bundled.Owner, bundled.OwnerProvider)
+		if err != nil {
+			steps = append(steps, b.skip(helpers.KindWebhook, id,
+				fmt.Sprintf("owner %s (%s) not found", bundled.Owner, bundled.OwnerProvider)))
+			continue
+		}
+
+		webhook := models.Webhook{
+			ID:          bundled.ID,
+			Owner:       owner.ID,
+			Secret:      secrets.Webhooks[id],
+			Description: bundled.Description,
+			Task:        bundled.Task,
+
+			SignatureScheme:    bundled.SignatureScheme,
+			SignatureHeader:    bundled.SignatureHeader,
+			SignatureAlgorithm: bundled.SignatureAlgorithm,
+			SignatureEncoding:  bundled.SignatureEncoding,
+			SignaturePrefix:    bundled.SignaturePrefix,
+			Mapping:            bundled.Mapping,
+			Filter:             bundled.Filter,
+		}
+
+		idx := slices.IndexFunc(current, func(w models.Webhook) bool { return w.ID == bundled.ID })
+		if idx == -1 {
+			if webhook.Secret == "" {
+				steps = append(steps, b.skip(helpers.KindWebhook, id, "secret not included in the bundle"))
+				continue
+			}
+			steps = append(steps, b.step(helpers.KindWebhook, id, "create", func() error {
+				_, err := b.WebhookService.CreateWebhook(webhook)
+				return err
+			}))
+			continue
+		}
+
+		existing := current[idx]
+		switch {
+		case existing.Owner == webhook.Owner && existing.Task == webhook.Task &&
+			existing.Description == webhook.Description &&
+			existing.SignatureScheme == webhook.SignatureScheme &&
+			existing.SignatureHeader == webhook.SignatureHeader &&
+			existing.SignatureAlgorithm == webhook.SignatureAlgorithm &&
+			existing.SignatureEncoding == webhook.SignatureEncoding &&
+			existing.SignaturePrefix == webhook.SignaturePrefix &&
+			existing.Mapping == webhook.Mapping && existing.Filter == webhook.Filter &&
+			(webhook.Secret == "" || existing.Secret == webhook.Secret):
+			steps = append(steps, b.step(helpers.KindWebhook, id, "unchanged", nil))
+		case mode == ImportModeCreate:
+			steps = append(steps, b.skip(helpers.KindWebhook, id, "already exists"))
+		default:
+			steps = append(steps, b.step(helpers.KindWebhook, id, "update", func() error {
+				_, err := b.WebhookService.UpdateWebhook(webhook)
+				return err
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+// planPrune removes everything that isn't in the bundle, dependants are deleted first.
+func (b *BundleServiceImpl) planPrune(bundle models.Bundle) ([]bundleStep, error) {
+	var steps []bundleStep
+	all := []string{"*"}
+
+	webhooks, err := b.WebhookService.ListAllWebhooks(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		id := webhook.ID
+		if !slices.ContainsFunc(bundle.Webhooks, func(w models.BundleWebhook) bool { return w.ID == id }) {
+			steps = append(steps, b.step(helpers.KindWebhook, id.String(), "delete", func() error {
+				return b.WebhookService.DeleteWebhook(id.String())
+			}))
+		}
+	}
+
+	roleBindings, err := b.RoleBindingService.ListRoleBindings(all, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleBinding := range roleBindings {
+		name := roleBinding.Name
+		if !roleBinding.Builtin &&
+			!slices.ContainsFunc(bundle.RoleBindings, func(r models.RoleBinding) bool { return r.Name == name }) {
+			steps = append(steps, b.step("role_binding", name, "delete", func() error {
+				return b.RoleBindingService.DeleteRoleBinding(name)
+			}))
+		}
+	}
+
+	roles, err := b.RoleService.ListRoles(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		name := role.Name
+		if !role.Builtin && !slices.ContainsFunc(bundle.Roles, func(r models.Role) bool { return r.Name == name }) {
+			steps = append(steps, b.step("role", name, "delete", func() error {
+				return b.RoleService.DeleteRole(name)
+			}))
+		}
+	}
+
+	groups, err := b.GroupService.ListGroups(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		name, id := group.Name, group.ID
+		if !group.Builtin && !slices.ContainsFunc(bundle.Groups, func(g models.Group) bool { return g.Name == name }) {
+			steps = append(steps, b.step("group", name, "delete", func() error {
+				return b.GroupService.DeleteGroup(id.String())
+			}))
+		}
+	}
+
+	cronjobs, err := b.CronJobService.ListCronJobs(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, cronjob := range cronjobs {
+		name := cronjob.Name
+		if !slices.ContainsFunc(bundle.CronJobs, func(c models.CronJob) bool { return c.Name == name }) {
+			steps = append(steps, b.step(helpers.KindCronJob, name, "delete", func() error {
+				return b.CronJobService.DeleteCronJob(name)
+			}))
+		}
+	}
+
+	tasks, err := b.TaskService.ListTasks(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		name := task.Name
+		if !slices.ContainsFunc(bundle.Tasks, func(t models.Task) bool { return t.Name == name }) {
+			steps = append(steps, b.step(helpers.KindTask, name, "delete", func() error {
+				return b.TaskService.DeleteTask(name)
+			}))
+		}
+	}
+
+	runners, err := b.RunnerService.ListRunners(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, runner := range runners {
+		name := runner.Name
+		if !slices.ContainsFunc(bundle.Runners, func(r models.Runner) bool { return r.Name == name }) {
+			steps = append(steps, b.step(helpers.KindRunner, name, "delete", func() error {
+				return b.RunnerService.DeleteRunner(name)
+			}))
+		}
+	}
+
+	return steps, nil
+}
+
+func (b *BundleServiceImpl) step(kind string, name string, action string, apply func() error) bundleStep {
+	return bundleStep{
+		change: models.BundleChange{Kind: kind, Name: name, Action: action},
+		apply:  apply,
+	}
+}
+
+func (b *BundleServiceImpl) skip(kind string, name string, reason string) bundleStep {
+	return bundleStep{
+		change: models.BundleChange{Kind: kind, Name: name, Action: "skip", Reason: reason},
+	}
+}
+
+// groupSpec returns the fields of a group carried by bundles, memberships and IDs differ between instances.
+func groupSpec(group models.Group) models.Group {
+	group.ID = uuid.UUID{}
+	group.Users = nil
+	group.Builtin = false
+	group.CreatedAt, group.UpdatedAt = time.Time{}, time.Time{}
+	return group
+}
+
+// sameSpec compares two resources by their JSON representation.
+func sameSpec(a interface{}, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	return bytes.Equal(aJSON, bJSON)
+}
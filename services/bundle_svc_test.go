@@ -0,0 +1,98 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPlanGroups(t *testing.T) {
+	db := testDB(t, &models.Group{})
+	for _, group := range []models.Group{
+		{Name: "admin", Provider: "local", Builtin: true},
+		{Name: "ops", Provider: "local", Users: pq.StringArray{uuid.NewV4().String()}},
+		{Name: "netops", Provider: "local"},
+	} {
+		group.ID = uuid.NewV4()
+		db.Create(&group)
+	}
+	b := &BundleServiceImpl{GroupService: NewGroupService(db, nil, config.Config{})}
+
+	// Groups exported from another instance, their IDs differ
+	bundle := models.Bundle{Groups: []models.Group{
+		{ID: uuid.NewV4(), Name: "admin", Provider: "local"},
+		{ID: uuid.NewV4(), Name: "ops", Provider: "local"},
+		{ID: uuid.NewV4(), Name: "netops", Provider: "active_directory"},
+		{ID: uuid.NewV4(), Name: "dev", Provider: "local"},
+	}}
+
+	tests := map[string][]string{
+		ImportModeCreate:    {"skip", "unchanged", "skip", "create"},
+		ImportModeOverwrite: {"skip", "unchanged", "update", "create"},
+	}
+	for mode, actions := range tests {
+		steps, err := b.planGroups(bundle, models.BundleSecrets{}, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(steps) != len(actions) {
+			t.Fatalf("%s: expected %d steps, got %d", mode, len(actions), len(steps))
+		}
+		for i, step := range steps {
+			if step.change.Action != actions[i] {
+				t.Errorf("%s %s: expected %s, got %s (%s)", mode, step.change.Name, actions[i], step.change.Action, step.change.Reason)
+			}
+		}
+	}
+}
+
+type fakeWebhookService struct {
+	WebhookService
+	webhooks []models.Webhook
+}
+
+func (f *fakeWebhookService) ListAllWebhooks(_ []string) ([]models.Webhook, error) {
+	return f.webhooks, nil
+}
+
+func TestPlanWebhooksMissingOwner(t *testing.T) {
+	db := testDB(t, &models.User{})
+	db.Create(&models.User{ID: uuid.NewV4(), Username: "alice", Provider: "local"})
+	b := &BundleServiceImpl{
+		WebhookService: &fakeWebhookService{},
+		UserService:    NewUserService(db, config.Config{}),
+	}
+
+	bundle := models.Bundle{Webhooks: []models.BundleWebhook{
+		{ID: uuid.NewV4(), Owner: "alice", OwnerProvider: "local", Task: "backup"},
+		// Users aren't part of bundles, the owner may not exist on this instance
+		{ID: uuid.NewV4(), Owner: "bob", OwnerProvider: "local", Task: "backup"},
+		{ID: uuid.NewV4(), Owner: "alice", OwnerProvider: "active_directory", Task: "report"},
+	}}
+	secrets := models.BundleSecrets{Webhooks: map[string]string{}}
+	for _, webhook := range bundle.Webhooks {
+		secrets.Webhooks[webhook.ID.String()] = "s3cret"
+	}
+
+	steps, err := b.planWebhooks(bundle, secrets, ImportModeOverwrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(steps))
+	}
+	if steps[0].change.Action != "create" {
+		t.Errorf("expected the webhook of alice to be created, got %s", steps[0].change.Action)
+	}
+	for i, owner := range map[int]string{1: "bob (local)", 2: "alice (active_directory)"} {
+		change := steps[i].change
+		if change.Action != "skip" || steps[i].apply != nil || !strings.Contains(change.Reason, owner) {
+			t.Errorf("expected the webhook of %s to be skipped, got %s: %s", owner, change.Action, change.Reason)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -8,14 +9,14 @@ import (
 	"github.com/kriten-io/kriten/helpers"
 	"github.com/kriten-io/kriten/models"
 
-	"encoding/json"
 	"strings"
+	"time"
 
-	"github.com/go-openapi/spec"
-	"github.com/go-openapi/strfmt"
-	"github.com/go-openapi/validate"
-	corev1 "k8s.io/api/core/v1"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/exp/slices"
+	batchv1 "k8s.io/api/batch/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type CronJobService interface {
@@ -25,180 +26,430 @@ type CronJobService interface {
 	UpdateCronJob(models.CronJob) (models.CronJob, error)
 	DeleteCronJob(string) error
 	GetSchema(string) (map[string]interface{}, error)
+	SyncCronJob(string) error
+	RunCronJob(string, bool) (models.Job, error)
+	ListCronJobJobs(string) ([]models.Job, error)
+	EnforceMaintenanceWindows() error
 }
 
+// Number of next run times returned with a cronjob
+const cronJobNextRuns = 5
+
+// maintenanceLead is how far ahead cronjob runs are checked against maintenance windows,
+// longer than the interval at which they're enforced.
+const maintenanceLead = 2 * time.Minute
+
 type CronJobServiceImpl struct {
-	config config.Config
+	MaintenanceWindowService MaintenanceWindowService
+	config                   config.Config
 }
 
-func NewCronJobService(config config.Config) CronJobService {
+func NewCronJobService(config config.Config, mws MaintenanceWindowService) CronJobService {
 	return &CronJobServiceImpl{
-		config: config,
+		MaintenanceWindowService: mws,
+		config:                   config,
 	}
 }
 
 func (j *CronJobServiceImpl) ListCronJobs(authList []string) ([]models.CronJob, error) {
 	var jobsList []models.CronJob
-	var labelSelector []string
 
 	if len(authList) == 0 {
 		return jobsList, nil
 	}
 
-	if authList[0] != "*" {
-		for _, s := range authList {
-			labelSelector = append(labelSelector, "task-name="+s)
+	objs, err := helpers.ListResources(j.config.Kube, helpers.KindCronJob, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range objs {
+		var cronjob models.CronJob
+		err = helpers.ResourceToModel(&objs[i], &cronjob)
+		if err != nil {
+			return nil, err
+		}
+		if authList[0] != "*" && !slices.Contains(authList, cronjob.Task) {
+			continue
+		}
+		jobsList = append(jobsList, cronjob)
+	}
+
+	return jobsList, nil
+}
+
+func (j *CronJobServiceImpl) GetCronJob(name string) (models.CronJob, error) {
+	var cronjob models.CronJob
+
+	obj, err := helpers.GetResource(j.config.Kube, helpers.KindCronJob, name)
+	if err != nil {
+		return cronjob, err
+	}
+
+	err = helpers.ResourceToModel(obj, &cronjob)
+	if err != nil {
+		return cronjob, err
+	}
+
+	// An invalid schedule is reported by the resource status, it shouldn't prevent reading it
+	cronjob.NextRuns, _ = nextRuns(cronjob, time.Now(), cronJobNextRuns)
+
+	cron, err := helpers.GetCronJob(j.config.Kube, name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return cronjob, nil
+		}
+		return cronjob, err
+	}
+	if cron.Status.LastScheduleTime != nil {
+		cronjob.LastScheduleTime = &cron.Status.LastScheduleTime.Time
+	}
+	if cron.Status.LastSuccessfulTime != nil {
+		cronjob.LastSuccessfulTime = &cron.Status.LastSuccessfulTime.Time
+	}
+	for _, active := range cron.Status.Active {
+		cronjob.Active = append(cronjob.Active, active.Name)
+	}
+	cronjob.Drift = helpers.CronJobDrifted(cron)
+
+	// A cronjob whose task was deleted can still be read and deleted
+	task, err := getTaskSpec(j.config.Kube, cronjob.Task)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return cronjob, nil
+		}
+		return cronjob, err
+	}
+
+	err = j.setBlockedBy(&cronjob, task)
+	return cronjob, err
+}
+
+// setBlockedBy records the maintenance windows blocking the task of a cronjob, the CronJob is suspended
+// while they do. Kubernetes starts scheduled runs by itself, so the runs due within maintenanceLead
+// are checked as well and the CronJob is suspended before a window starts.
+func (j *CronJobServiceImpl) setBlockedBy(cronjob *models.CronJob, task *models.Task) error {
+	cronjob.BlockedBy = ""
+
+	now := time.Now()
+	runs, _ := nextRuns(*cronjob, now, int(maintenanceLead/time.Minute)+1)
+	for _, at := range append([]time.Time{now}, runs...) {
+		if at.After(now.Add(maintenanceLead)) {
+			break
+		}
+
+		err := j.MaintenanceWindowService.CheckMaintenanceWindows(task, at)
+		var windowErr *MaintenanceWindowError
+		if errors.As(err, &windowErr) {
+			cronjob.BlockedBy = windowErr.Window
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
 
-	jobs, err := helpers.ListCronJobs(j.config.Kube, labelSelector)
+	return nil
+}
+
+// EnforceMaintenanceWindows suspends the CronJobs blocked by maintenance windows, and resumes
+// them once they aren't anymore. Only CronJobs whose state changes are updated.
+func (j *CronJobServiceImpl) EnforceMaintenanceWindows() error {
+	cronjobs, err := j.ListCronJobs([]string{"*"})
+	if err != nil {
+		return err
+	}
+
+	for _, cronjob := range cronjobs {
+		task, err := getTaskSpec(j.config.Kube, cronjob.Task)
+		if err != nil {
+			continue
+		}
+		err = j.setBlockedBy(&cronjob, task)
+		if err != nil {
+			return err
+		}
+
+		cron, err := helpers.GetCronJob(j.config.Kube, cronjob.Name)
+		if err != nil {
+			continue
+		}
+		suspend := cronjob.Disable || cronjob.BlockedBy != ""
+		if cron.Spec.Suspend != nil && *cron.Spec.Suspend == suspend {
+			continue
+		}
+
+		if cronjob.BlockedBy != "" {
+			log.Printf("Suspending cronjob %s, blocked by maintenance windows: %s\n", cronjob.Name, cronjob.BlockedBy)
+		} else {
+			log.Printf("Resuming cronjob %s after maintenance windows\n", cronjob.Name)
+		}
+		err = j.SyncCronJob(cronjob.Name)
+		if err != nil {
+			log.Printf("Failed to sync cronjob %s: %v\n", cronjob.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ListCronJobJobs returns the jobs spawned by a cronjob, the most recent first.
+// Jobs created before they were labelled are found through their owner.
+func (j *CronJobServiceImpl) ListCronJobJobs(name string) ([]models.Job, error) {
+	cronjob, err := j.GetCronJob(name)
 	if err != nil {
 		return nil, err
 	}
 
+	jobs, err := helpers.ListJobs(j.config.Kube, []string{"task-name=" + cronjob.Task})
+	if err != nil {
+		return nil, err
+	}
+
+	var spawned []batchv1.Job
 	for _, job := range jobs.Items {
-		var data map[string]interface{}
-		// This unmarshal is only used to fetch the extra vars, it doesn't look very reliable so it might need a rework
-		containerEnv := job.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env
-		if len(containerEnv) > 0 {
-			err = json.Unmarshal([]byte(containerEnv[0].Value), &data)
-			if err != nil {
-				return nil, err
-			}
-		}
-		jobRet := models.CronJob{
-			Name:      job.Name,
-			Owner:     job.Spec.JobTemplate.Spec.Template.Labels["owner"],
-			Task:      job.Spec.JobTemplate.Spec.Template.Labels["task-name"],
-			Schedule:  job.Spec.Schedule,
-			Disable:   *job.Spec.Suspend,
-			ExtraVars: data,
-		}
-		jobsList = append(jobsList, jobRet)
+		owner := metav1.GetControllerOf(&job)
+		if job.Labels[helpers.LabelCronJob] == name || (owner != nil && owner.Kind == "CronJob" && owner.Name == name) {
+			spawned = append(spawned, job)
+		}
+	}
+	sortJobs(spawned)
+
+	var jobsList []models.Job
+	for i := range spawned {
+		job := jobModel(&spawned[i])
+		job.CronJob = name
+		jobsList = append(jobsList, job)
 	}
 
 	return jobsList, nil
 }
 
-func (j *CronJobServiceImpl) GetCronJob(name string) (models.CronJob, error) {
-	var cronjob models.CronJob
+// cronJobLabels are the labels of the jobs spawned by a cronjob.
+func cronJobLabels(cronjob models.CronJob, task *models.Task) map[string]string {
+	labels := map[string]string{helpers.LabelCronJob: cronjob.Name}
+	for k, v := range jobLabels(task) {
+		labels[k] = v
+	}
+
+	return labels
+}
 
-	job, err := helpers.GetCronJob(j.config.Kube, name)
+// RunCronJob creates a job from the cronjob template straight away, regardless of its schedule.
+// Maintenance windows apply, unless an admin overrides them.
+func (j *CronJobServiceImpl) RunCronJob(name string, override bool) (models.Job, error) {
+	var job models.Job
+
+	cronjob, err := j.GetCronJob(name)
 	if err != nil {
-		return cronjob, err
+		return job, err
+	}
+	task, err := getTaskSpec(j.config.Kube, cronjob.Task)
+	if err != nil {
+		return job, err
+	}
+
+	// The job runs now, windows starting later don't block it
+	err = j.MaintenanceWindowService.CheckMaintenanceWindows(task, time.Now())
+	var windowErr *MaintenanceWindowError
+	if errors.As(err, &windowErr) {
+		if !override {
+			return job, err
+		}
+		log.Printf("Maintenance windows overridden to run cronjob %s: %s\n", name, windowErr.Window)
+		job.MaintenanceOverride = windowErr.Window
+	} else if err != nil {
+		return job, err
 	}
 
-	var data map[string]interface{}
-	// This unmarshal is only used to fetch the extra vars, it doesn't look very reliable so it might need a rework
-	containerEnv := job.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env
-	if len(containerEnv) > 0 {
-		err = json.Unmarshal([]byte(containerEnv[0].Value), &data)
+	job.ID, err = helpers.RunCronJob(j.config.Kube, name)
+	job.CronJob = name
+	return job, err
+}
+
+// nextRuns computes the next n run times of a cronjob after a given time, in its time zone.
+func nextRuns(cronjob models.CronJob, after time.Time, n int) ([]time.Time, error) {
+	location := time.UTC
+	if cronjob.TimeZone != "" {
+		var err error
+		location, err = time.LoadLocation(cronjob.TimeZone)
 		if err != nil {
-			return cronjob, err
+			return nil, fmt.Errorf("invalid time zone %s: %w", cronjob.TimeZone, err)
 		}
 	}
-	cronjob = models.CronJob{
-		Name:      job.Name,
-		Owner:     job.Spec.JobTemplate.Spec.Template.Labels["owner"],
-		Task:      job.Spec.JobTemplate.Spec.Template.Labels["task-name"],
-		Schedule:  job.Spec.Schedule,
-		Disable:   *job.Spec.Suspend,
-		ExtraVars: data,
+
+	schedule, err := cron.ParseStandard(cronjob.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %w", cronjob.Schedule, err)
+	}
+
+	runs := make([]time.Time, 0, n)
+	next := after.In(location)
+	for i := 0; i < n; i++ {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
 	}
 
-	return cronjob, nil
+	return runs, nil
 }
 
 func (j *CronJobServiceImpl) CreateCronJob(cronjob models.CronJob) (models.CronJob, error) {
-	runner, command, err := PreFlightChecks(j.config.Kube, cronjob)
+	return j.writeCronJob(cronjob, "create")
+}
+
+func (j *CronJobServiceImpl) UpdateCronJob(cronjob models.CronJob) (models.CronJob, error) {
+	return j.writeCronJob(cronjob, "update")
+}
+
+// writeCronJob stores the KritenCronJob resource and the Kubernetes CronJob that runs it,
+// the latter is owned by the resource so it's garbage collected on deletion.
+func (j *CronJobServiceImpl) writeCronJob(cronjob models.CronJob, operation string) (models.CronJob, error) {
+	_, err := nextRuns(cronjob, time.Now(), 1)
 	if err != nil {
 		return models.CronJob{}, err
 	}
+	// Kubernetes rejects time zones in the schedule when the time zone is set
+	if cronjob.TimeZone != "" && strings.Contains(cronjob.Schedule, "TZ=") {
+		return models.CronJob{}, fmt.Errorf("time zone is set both in schedule and time_zone")
+	}
 
-	_, err = helpers.CreateOrUpdateCronJob(j.config.Kube, cronjob, runner, command, "create")
+	// The resource keeps the extra vars as given, defaults are applied to the CronJob only
+	spec, err := helpers.CronJobSpec(cronjob)
+	if err != nil {
+		return models.CronJob{}, err
+	}
 
-	return cronjob, err
-}
+	runner, task, err := PreFlightChecks(j.config.Kube, &cronjob)
+	if err != nil {
+		return models.CronJob{}, err
+	}
+	err = j.setBlockedBy(&cronjob, task)
+	if err != nil {
+		return models.CronJob{}, err
+	}
 
-func (j *CronJobServiceImpl) UpdateCronJob(cronjob models.CronJob) (models.CronJob, error) {
-	runner, command, err := PreFlightChecks(j.config.Kube, cronjob)
+	labels := map[string]string{helpers.LabelTask: cronjob.Task}
+	obj, err := helpers.CreateOrUpdateResource(j.config.Kube, helpers.KindCronJob, cronjob.Name, spec, labels, operation)
 	if err != nil {
 		return models.CronJob{}, err
 	}
 
-	_, err = helpers.CreateOrUpdateCronJob(j.config.Kube, cronjob, runner, command, "update")
+	owner := helpers.OwnerReference(helpers.KindCronJob, obj)
+	_, err = helpers.CreateOrUpdateCronJob(j.config.Kube, cronjob, runner, task.Command, cronJobLabels(cronjob, task), owner, operation)
+	if err != nil {
+		_ = helpers.UpdateResourceStatus(j.config.Kube, helpers.KindCronJob, obj, "Error", err.Error())
+		return cronjob, err
+	}
+
+	_ = helpers.UpdateResourceStatus(j.config.Kube, helpers.KindCronJob, obj, "Ready", "")
 
-	return cronjob, err
+	return cronjob, nil
+}
+
+// SyncCronJob creates or updates the Kubernetes CronJob of a KritenCronJob declared outside of the API.
+func (j *CronJobServiceImpl) SyncCronJob(name string) error {
+	var cronjob models.CronJob
+
+	obj, err := helpers.GetResource(j.config.Kube, helpers.KindCronJob, name)
+	if err != nil {
+		return err
+	}
+	err = helpers.ResourceToModel(obj, &cronjob)
+	if err != nil {
+		return err
+	}
+
+	runner, task, err := PreFlightChecks(j.config.Kube, &cronjob)
+	if err != nil {
+		return err
+	}
+	err = j.setBlockedBy(&cronjob, task)
+	if err != nil {
+		return err
+	}
+
+	operation := "update"
+	cron, err := helpers.GetCronJob(j.config.Kube, name)
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			return err
+		}
+		operation = "create"
+	} else if helpers.CronJobDrifted(cron) {
+		log.Printf("CronJob %s was modified outside of Kriten, restoring it from its resource\n", name)
+	}
+
+	owner := helpers.OwnerReference(helpers.KindCronJob, obj)
+	_, err = helpers.CreateOrUpdateCronJob(j.config.Kube, cronjob, runner, task.Command, cronJobLabels(cronjob, task), owner, operation)
+	return err
 }
 
 func (j *CronJobServiceImpl) DeleteCronJob(id string) error {
-	err := helpers.DeleteCronJob(j.config.Kube, id)
+	err := helpers.DeleteResource(j.config.Kube, helpers.KindCronJob, id)
 	if err != nil {
 		return err
 	}
 
+	// The CronJob is garbage collected, deleting it straight away to stop any further schedule
+	err = helpers.DeleteCronJob(j.config.Kube, id)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+
 	return nil
 }
 
 func (j *CronJobServiceImpl) GetSchema(name string) (map[string]interface{}, error) {
-	var data map[string]interface{}
-
-	configMap, err := helpers.GetConfigMap(j.config.Kube, name)
+	task, err := getTaskSpec(j.config.Kube, name)
 	if err != nil {
 		return nil, err
 	}
-	if configMap.Data["runner"] == "" {
-		return nil, fmt.Errorf("task %s not found", name)
-	}
 
-	if configMap.Data["schema"] != "" {
-		err = json.Unmarshal([]byte(configMap.Data["schema"]), &data)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return data, nil
+	return task.Schema, nil
 }
 
-func PreFlightChecks(kube config.KubeConfig, cronjob models.CronJob) (*corev1.ConfigMap, string, error) {
-	task, err := helpers.GetConfigMap(kube, cronjob.Task)
+// PreFlightChecks checks the task and runner of a cronjob, its extra vars are replaced with the normalised ones.
+func PreFlightChecks(kube config.KubeConfig, cronjob *models.CronJob) (*models.Runner, *models.Task, error) {
+	task, err := getTaskSpec(kube, cronjob.Task)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 
-	if task.Data["schema"] != "" {
-		schema := new(spec.Schema)
-		_ = json.Unmarshal([]byte(task.Data["schema"]), schema)
-
-		// strfmt.Default is the registry of recognized formats
-		err = validate.AgainstSchema(schema, cronjob.ExtraVars, strfmt.Default)
+	if task.Schema != nil {
+		extraVars, err := normaliseValues(task, cronjob.ExtraVars)
 		if err != nil {
 			log.Printf("JSON does not validate against schema: %v", err)
-			return nil, "", err
+			return nil, nil, err
 		}
+		cronjob.ExtraVars = extraVars
 	}
 
-	runner, err := helpers.GetConfigMap(kube, task.Data["runner"])
+	runner, err := getRunnerSpec(kube, task.Runner)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 
-	if runner.Data["branch"] == "" {
-		runner.Data["branch"] = "main"
+	// Jobs spawned by a CronJob are created by Kubernetes, so there's no chance to fetch
+	// secrets from an external provider before they start.
+	if runner.SecretProvider != "" && runner.SecretProvider != SecretProviderKubernetes {
+		return nil, nil, fmt.Errorf("runner %s uses the %s secret provider, which is not supported by cronjobs",
+			runner.Name, runner.SecretProvider)
 	}
 
-	secret, err := helpers.GetSecret(kube, task.Data["runner"]+"-token")
+	secret, err := helpers.GetSecret(kube, task.Runner+"-token")
 	if err != nil {
 		if !kerrors.IsNotFound(err) {
-			return nil, "", err
+			return nil, nil, err
 		}
 	} else {
 		gitToken := string(secret.Data["token"])
 		if gitToken != "" {
-			runner.Data["gitURL"] = strings.Replace(runner.Data["gitURL"], "://", "://"+gitToken+":@", 1)
+			runner.GitURL = strings.Replace(runner.GitURL, "://", "://"+gitToken+":@", 1)
 		}
 	}
 
-	return runner, task.Data["command"], nil
+	return runner, task, nil
 }
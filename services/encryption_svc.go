@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	"gorm.io/gorm"
+)
+
+// Number of rows re-encrypted at once
+const reencryptBatch = 100
+
+// encryptedColumn is a column using the encrypted serializer
+type encryptedColumn struct {
+	Table  string
+	Key    string
+	Column string
+}
+
+var encryptedColumns = []encryptedColumn{
+	{Table: "webhooks", Key: "id", Column: "secret"},
+	{Table: "webhooks", Key: "id", Column: "previous_secret"},
+	{Table: "subscriptions", Key: "subscription_id", Column: "secret"},
+	{Table: "notification_channels", Key: "channel_id", Column: "url"},
+	{Table: "triggers", Key: "trigger_id", Column: "secret"},
+}
+
+type EncryptionService interface {
+	GetEncryptionStatus() (models.EncryptionStatus, error)
+	Reencrypt() (models.EncryptionStatus, error)
+}
+
+type EncryptionServiceImpl struct {
+	db     *gorm.DB
+	config config.Config
+}
+
+func NewEncryptionService(database *gorm.DB, config config.Config) EncryptionService {
+	return &EncryptionServiceImpl{
+		db:     database,
+		config: config,
+	}
+}
+
+type encryptedRow struct {
+	Key   string
+	Value string
+}
+
+func (e *EncryptionServiceImpl) GetEncryptionStatus() (models.EncryptionStatus, error) {
+	status := models.EncryptionStatus{
+		ActiveKey: helpers.ActiveKeyVersion(),
+		Columns:   map[string]map[string]int{},
+	}
+
+	for _, column := range encryptedColumns {
+		var rows []encryptedRow
+		err := e.selectColumn(column).Find(&rows).Error
+		if err != nil {
+			return status, err
+		}
+
+		counts := map[string]int{}
+		for _, row := range rows {
+			version := helpers.KeyVersion(row.Value)
+			if version == "" {
+				version = "plain"
+			}
+			counts[version]++
+		}
+		status.Columns[column.Table+"."+column.Column] = counts
+	}
+
+	return status, nil
+}
+
+// Reencrypt encrypts every value not encrypted with the active key yet, including plain text ones.
+// It runs in batches while Kriten is serving requests: a value is only replaced if it hasn't
+// changed since it was read, e.g. by a secret rotation.
+func (e *EncryptionServiceImpl) Reencrypt() (models.EncryptionStatus, error) {
+	active := helpers.ActiveKeyVersion()
+	if active == "" {
+		return models.EncryptionStatus{}, errors.New("no encryption keys configured")
+	}
+
+	reencrypted := 0
+	for _, column := range encryptedColumns {
+		lastKey := ""
+		for {
+			var rows []encryptedRow
+			err := e.selectColumn(column).
+				Where(fmt.Sprintf("CAST(%s AS text) > ?", column.Key), lastKey).
+				Order(fmt.Sprintf("CAST(%s AS text)", column.Key)).
+				Limit(reencryptBatch).
+				Find(&rows).Error
+			if err != nil {
+				return models.EncryptionStatus{}, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+			lastKey = rows[len(rows)-1].Key
+
+			for _, row := range rows {
+				if row.Value == "" || helpers.KeyVersion(row.Value) == active {
+					continue
+				}
+
+				value, err := helpers.DecryptValue(row.Value)
+				if err != nil {
+					return models.EncryptionStatus{}, fmt.Errorf("%s %s: %w", column.Table, row.Key, err)
+				}
+				value, err = helpers.EncryptValue(value)
+				if err != nil {
+					return models.EncryptionStatus{}, err
+				}
+
+				res := e.db.Table(column.Table).
+					Where(fmt.Sprintf("%s = ? AND %s = ?", column.Key, column.Column), row.Key, row.Value).
+					Update(column.Column, value)
+				if res.Error != nil {
+					return models.EncryptionStatus{}, res.Error
+				}
+				reencrypted += int(res.RowsAffected)
+			}
+		}
+	}
+
+	status, err := e.GetEncryptionStatus()
+	status.Reencrypted = reencrypted
+	return status, err
+}
+
+// selectColumn reads the raw values of an encrypted column, without going through the serializer.
+func (e *EncryptionServiceImpl) selectColumn(column encryptedColumn) *gorm.DB {
+	return e.db.Table(column.Table).
+		Select(fmt.Sprintf("CAST(%s AS text) AS key, COALESCE(%s, '') AS value", column.Key, column.Column))
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kriten-io/kriten/helpers"
+)
+
+// testEncryptionService returns the service with tables holding only the encrypted columns.
+func testEncryptionService(t *testing.T) *EncryptionServiceImpl {
+	db := testDB(t)
+	columns := map[string][]string{}
+	for _, column := range encryptedColumns {
+		if _, ok := columns[column.Table]; !ok {
+			columns[column.Table] = []string{column.Key + " text"}
+		}
+		columns[column.Table] = append(columns[column.Table], column.Column+" text")
+	}
+	for table, definitions := range columns {
+		if err := db.Exec("CREATE TABLE " + table + " (" + strings.Join(definitions, ", ") + ")").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &EncryptionServiceImpl{db: db}
+}
+
+var testEncryptionKeys = map[string][]byte{
+	"1": bytes.Repeat([]byte{1}, 32),
+	"2": bytes.Repeat([]byte{2}, 32),
+}
+
+// useKeyring sets the encryption keys of the given versions for the duration of a test.
+func useKeyring(t *testing.T, active string, versions ...string) {
+	keys := map[string][]byte{}
+	for _, version := range versions {
+		keys[version] = testEncryptionKeys[version]
+	}
+	helpers.SetKeyring(&helpers.Keyring{Active: active, Keys: keys})
+	t.Cleanup(func() { helpers.SetKeyring(nil) })
+}
+
+func encrypt(t *testing.T, value string) string {
+	encrypted, err := helpers.EncryptValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+func TestReencrypt(t *testing.T) {
+	e := testEncryptionService(t)
+
+	useKeyring(t, "1", "1")
+	e.db.Exec("INSERT INTO webhooks (id, secret, previous_secret) VALUES (?, ?, ?)", "w1", encrypt(t, "webhook"), "")
+	e.db.Exec("INSERT INTO subscriptions (subscription_id, secret) VALUES (?, ?)", "s1", "plain")
+	e.db.Exec("INSERT INTO triggers (trigger_id, secret) VALUES (?, ?)", "t1", nil)
+
+	useKeyring(t, "2", "1", "2")
+	e.db.Exec("INSERT INTO notification_channels (channel_id, url) VALUES (?, ?)", "c1", encrypt(t, "https://hooks.example.com"))
+
+	status, err := e.Reencrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Reencrypted != 2 || status.ActiveKey != "2" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if counts := status.Columns["webhooks.secret"]; counts["2"] != 1 || counts["1"] != 0 {
+		t.Errorf("webhook secret not re-encrypted: %v", counts)
+	}
+	if counts := status.Columns["subscriptions.secret"]; counts["2"] != 1 || counts["plain"] != 0 {
+		t.Errorf("plain text subscription secret not encrypted: %v", counts)
+	}
+
+	values := map[string]string{
+		"SELECT secret FROM webhooks":               "webhook",
+		"SELECT secret FROM subscriptions":          "plain",
+		"SELECT url FROM notification_channels":     "https://hooks.example.com",
+		"SELECT COALESCE(secret, '') FROM triggers": "",
+	}
+	// Key 1 can be removed once everything is encrypted with key 2
+	useKeyring(t, "2", "2")
+	for query, expected := range values {
+		var stored string
+		e.db.Raw(query).Scan(&stored)
+		value, err := helpers.DecryptValue(stored)
+		if err != nil || value != expected {
+			t.Errorf("%s: expected %q, got %q, %v", query, expected, value, err)
+		}
+	}
+}
+
+func TestReencryptWithoutKeys(t *testing.T) {
+	e := testEncryptionService(t)
+	if _, err := e.Reencrypt(); err == nil {
+		t.Error("expected re-encryption without keys to fail")
+	}
+}
@@ -0,0 +1,514 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+)
+
+const (
+	EventSourceSyslog = "syslog"
+	EventSourceSNMP   = "snmp"
+)
+
+const (
+	EventRunLaunched = "launched"
+	EventRunFailed   = "failed"
+)
+
+const (
+	defaultDedupWindow = 5 * time.Minute
+	defaultRateLimit   = 10
+	defaultRateWindow  = 10 * time.Minute
+	// Runs are kept for the history of rules, well beyond their windows
+	eventRuleRunsRetention = 7 * 24 * time.Hour
+	// Rules are reloaded from the database at most this often, listeners match every event against them
+	eventRulesCacheTTL = 10 * time.Second
+)
+
+type EventRuleService interface {
+	ListEventRules([]string) ([]models.EventRule, error)
+	GetEventRule(string) (models.EventRule, error)
+	CreateEventRule(models.EventRule) (models.EventRule, error)
+	UpdateEventRule(models.EventRule) (models.EventRule, error)
+	DeleteEventRule(string) error
+	ListEventRuleRuns(string) ([]models.EventRuleRun, error)
+	ListenSyslog() error
+	ListenSNMP() error
+}
+
+type EventRuleServiceImpl struct {
+	JobService   JobService
+	AuthService  AuthService
+	AuditService AuditService
+	db           *gorm.DB
+	config       config.Config
+	// enabled rules, cached for the listeners
+	rules    []eventRule
+	loadedAt time.Time
+	mu       sync.Mutex
+}
+
+// eventRule is a rule with its templates and durations parsed.
+type eventRule struct {
+	models.EventRule
+	message     *regexp.Regexp
+	dedupKey    *template.Template
+	dedupWindow time.Duration
+	rateWindow  time.Duration
+}
+
+func NewEventRuleService(database *gorm.DB, js JobService, as AuthService, als AuditService,
+	config config.Config) EventRuleService {
+	return &EventRuleServiceImpl{
+		JobService:   js,
+		AuthService:  as,
+		AuditService: als,
+		db:           database,
+		config:       config,
+	}
+}
+
+func (e *EventRuleServiceImpl) ListEventRules(authList []string) ([]models.EventRule, error) {
+	var rules []models.EventRule
+	var res *gorm.DB
+
+	if len(authList) == 0 {
+		return rules, nil
+	} else if slices.Contains(authList, "*") {
+		res = e.db.Order("name").Find(&rules)
+	} else {
+		res = e.db.Where("name IN ?", authList).Order("name").Find(&rules)
+	}
+
+	return rules, res.Error
+}
+
+func (e *EventRuleServiceImpl) GetEventRule(name string) (models.EventRule, error) {
+	var rule models.EventRule
+	res := e.db.Where("name = ?", name).Find(&rule)
+	if res.Error != nil {
+		return models.EventRule{}, res.Error
+	}
+
+	if rule.Name == "" {
+		return models.EventRule{}, fmt.Errorf("event rule %s not found, please check name", name)
+	}
+
+	return rule, nil
+}
+
+func (e *EventRuleServiceImpl) CreateEventRule(rule models.EventRule) (models.EventRule, error) {
+	_, err := parseEventRule(rule)
+	if err != nil {
+		return rule, err
+	}
+
+	res := e.db.Create(&rule)
+	e.expireRules()
+	return rule, res.Error
+}
+
+// UpdateEventRule replaces a rule, its owner is kept.
+func (e *EventRuleServiceImpl) UpdateEventRule(rule models.EventRule) (models.EventRule, error) {
+	current, err := e.GetEventRule(rule.Name)
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = current.ID
+	rule.Owner = current.Owner
+	rule.CreatedAt = current.CreatedAt
+
+	_, err = parseEventRule(rule)
+	if err != nil {
+		return rule, err
+	}
+
+	res := e.db.Save(&rule)
+	if res.Error != nil {
+		return models.EventRule{}, res.Error
+	}
+	e.expireRules()
+
+	return e.GetEventRule(rule.Name)
+}
+
+func (e *EventRuleServiceImpl) DeleteEventRule(name string) error {
+	rule, err := e.GetEventRule(name)
+	if err != nil {
+		return err
+	}
+
+	err = e.db.Where("rule = ?", rule.ID).Delete(&models.EventRuleRun{}).Error
+	if err != nil {
+		return err
+	}
+	err = e.db.Unscoped().Delete(&rule).Error
+	e.expireRules()
+	return err
+}
+
+func (e *EventRuleServiceImpl) ListEventRuleRuns(name string) ([]models.EventRuleRun, error) {
+	var runs []models.EventRuleRun
+
+	rule, err := e.GetEventRule(name)
+	if err != nil {
+		return runs, err
+	}
+
+	res := e.db.Where("rule = ?", rule.ID).Order("created_at DESC").Find(&runs)
+	return runs, res.Error
+}
+
+// handleEvent runs the tasks of the rules matching an event received by a listener.
+func (e *EventRuleServiceImpl) handleEvent(source string, fields map[string]interface{}) {
+	rules, err := e.enabledRules()
+	if err != nil {
+		log.Printf("Failed to load event rules: %v\n", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Source != source {
+			continue
+		}
+
+		ruleFields, ok := matchEventRule(rule, fields)
+		if !ok {
+			continue
+		}
+
+		e.runEventRule(rule, ruleFields)
+	}
+}
+
+// runEventRule starts the rule task unless an event with the same key already did within the dedup window,
+// or the rule reached its rate limit. Suppressed events are counted on the run suppressing them.
+// Checks take a lock on the rule, so replicas receiving events of the same device don't run it twice.
+func (e *EventRuleServiceImpl) runEventRule(rule *eventRule, fields map[string]interface{}) {
+	var key bytes.Buffer
+	if err := rule.dedupKey.Execute(&key, fields); err != nil {
+		log.Printf("Event rule %s failed to render its dedup key: %v\n", rule.Name, err)
+		return
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		log.Printf("Event rule %s failed to encode fields: %v\n", rule.Name, err)
+		return
+	}
+
+	run := models.EventRuleRun{
+		Rule:   rule.ID,
+		Key:    key.String(),
+		Fields: string(data),
+		Status: EventRunLaunched,
+	}
+	launch := false
+
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", rule.ID.String()).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var previous models.EventRuleRun
+		res := tx.Where("rule = ? AND key = ? AND created_at > ?", rule.ID, run.Key, now.Add(-rule.dedupWindow)).
+			Order("created_at DESC").Limit(1).Find(&previous)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			var count int64
+			res = tx.Model(&models.EventRuleRun{}).
+				Where("rule = ? AND status = ? AND created_at > ?", rule.ID, EventRunLaunched, now.Add(-rule.rateWindow)).
+				Count(&count)
+			if res.Error != nil {
+				return res.Error
+			}
+			if count >= int64(rule.RateLimit) {
+				res = tx.Where("rule = ?", rule.ID).Order("created_at DESC").Limit(1).Find(&previous)
+				if res.Error != nil {
+					return res.Error
+				}
+			}
+		}
+		if previous.ID != uuid.Nil {
+			return tx.Model(&previous).Update("suppressed", gorm.Expr("suppressed + 1")).Error
+		}
+
+		err = tx.Where("rule = ? AND created_at < ?", rule.ID, now.Add(-eventRuleRunsRetention)).
+			Delete(&models.EventRuleRun{}).Error
+		if err != nil {
+			return err
+		}
+
+		launch = true
+		return tx.Create(&run).Error
+	})
+	if err != nil {
+		log.Printf("Event rule %s failed: %v\n", rule.Name, err)
+		return
+	}
+	if !launch {
+		return
+	}
+
+	owner, job, err := e.launch(rule, string(data))
+	if err != nil {
+		run.Status = EventRunFailed
+		run.Error = err.Error()
+		log.Printf("Event rule %s failed to run task %s: %v\n", rule.Name, rule.Task, err)
+	}
+	run.JobID = job.ID
+	err = e.db.Model(&run).Select("status", "error", "job_id").Updates(&run).Error
+	if err != nil {
+		log.Printf("Failed to record run of event rule %s: %v\n", rule.Name, err)
+	}
+
+	audit := models.AuditLog{
+		UserID:        owner.ID,
+		UserName:      owner.Username,
+		Provider:      owner.Provider,
+		EventType:     "run",
+		EventCategory: "event_rules",
+		EventTarget:   rule.Name,
+		Status:        "success",
+	}
+	if run.Status == EventRunFailed {
+		audit.Status = "error"
+	}
+	e.AuditService.CreateAudit(audit)
+}
+
+// launch runs the rule task as the rule owner, with the fields mapped into the task extra vars.
+func (e *EventRuleServiceImpl) launch(rule *eventRule, fields string) (models.User, models.Job, error) {
+	var owner models.User
+	res := e.db.Where("user_id = ?", rule.Owner).Find(&owner)
+	if res.Error != nil {
+		return owner, models.Job{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return owner, models.Job{}, errors.New("event rule owner not found")
+	}
+
+	authorised, err := e.AuthService.IsAutorised(&models.Authorization{
+		UserID:     owner.ID,
+		Provider:   owner.Provider,
+		Resource:   "jobs",
+		ResourceID: rule.Task,
+		Access:     "write",
+	})
+	if err != nil {
+		return owner, models.Job{}, err
+	}
+	if !authorised {
+		return owner, models.Job{}, fmt.Errorf("event rule owner %s cannot run task %s", owner.Username, rule.Task)
+	}
+
+	extraVars, _, err := mapPayload(rule.Mapping, "", fields)
+	if err != nil {
+		return owner, models.Job{}, err
+	}
+
+	job, err := e.JobService.CreateJob(owner.Username, rule.Task, extraVars, false)
+	return owner, job, err
+}
+
+func (e *EventRuleServiceImpl) enabledRules() ([]eventRule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Since(e.loadedAt) < eventRulesCacheTTL {
+		return e.rules, nil
+	}
+
+	var rules []models.EventRule
+	res := e.db.Where("disable = ?", false).Find(&rules)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	e.rules = nil
+	for _, rule := range rules {
+		parsed, err := parseEventRule(rule)
+		if err != nil {
+			log.Printf("Skipping invalid event rule %s: %v\n", rule.Name, err)
+			continue
+		}
+		e.rules = append(e.rules, parsed)
+	}
+	e.loadedAt = time.Now()
+
+	return e.rules, nil
+}
+
+// expireRules makes the listeners reload the rules, changes on other replicas are picked up with the cache TTL.
+func (e *EventRuleServiceImpl) expireRules() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadedAt = time.Time{}
+}
+
+// parseEventRule checks a rule and parses its regular expression, templates and windows, applying the defaults.
+func parseEventRule(rule models.EventRule) (eventRule, error) {
+	parsed := eventRule{EventRule: rule}
+	var err error
+
+	switch rule.Source {
+	case EventSourceSyslog:
+		if len(rule.OIDs) > 0 {
+			return parsed, errors.New("oids only apply to snmp rules")
+		}
+	case EventSourceSNMP:
+		if len(rule.Facilities) > 0 || rule.Severity != "" || rule.Message != "" {
+			return parsed, errors.New("facilities, severity and message only apply to syslog rules")
+		}
+	default:
+		return parsed, fmt.Errorf("invalid event rule source %s, allowed: syslog, snmp", rule.Source)
+	}
+
+	for _, facility := range rule.Facilities {
+		if !slices.Contains(syslogFacilities, facility) {
+			return parsed, fmt.Errorf("invalid facility %s, allowed: %s", facility, strings.Join(syslogFacilities, ", "))
+		}
+	}
+	if rule.Severity != "" && !slices.Contains(syslogSeverities, rule.Severity) {
+		return parsed, fmt.Errorf("invalid severity %s, allowed: %s", rule.Severity, strings.Join(syslogSeverities, ", "))
+	}
+	for _, host := range rule.Hosts {
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return parsed, fmt.Errorf("invalid host %s: %w", host, err)
+			}
+		}
+	}
+
+	if rule.Message != "" {
+		parsed.message, err = regexp.Compile(rule.Message)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid message: %w", err)
+		}
+	}
+	if _, _, err = parsePayloadTemplates(rule.Mapping, ""); err != nil {
+		return parsed, err
+	}
+
+	dedupKey := rule.DedupKey
+	if dedupKey == "" && rule.Source == EventSourceSyslog {
+		dedupKey = "{{.host}} {{.message}}"
+	} else if dedupKey == "" {
+		dedupKey = "{{.host}} {{.trap_oid}}"
+	}
+	parsed.dedupKey, err = template.New("dedup_key").Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(dedupKey)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid dedup_key: %w", err)
+	}
+
+	parsed.dedupWindow, err = parseWindow(rule.DedupWindow, defaultDedupWindow)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid dedup_window: %w", err)
+	}
+	parsed.rateWindow, err = parseWindow(rule.RateWindow, defaultRateWindow)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid rate_window: %w", err)
+	}
+	if rule.RateLimit < 0 {
+		return parsed, errors.New("rate_limit can't be negative")
+	}
+	if rule.RateLimit == 0 {
+		parsed.RateLimit = defaultRateLimit
+	}
+
+	return parsed, nil
+}
+
+func parseWindow(window string, defaultWindow time.Duration) (time.Duration, error) {
+	if window == "" {
+		return defaultWindow, nil
+	}
+
+	duration, err := time.ParseDuration(window)
+	if err == nil && duration <= 0 {
+		err = errors.New("must be positive")
+	}
+	return duration, err
+}
+
+// matchEventRule matches an event against a rule, returning the event fields with the named groups
+// of the rule message regular expression.
+func matchEventRule(rule *eventRule, fields map[string]interface{}) (map[string]interface{}, bool) {
+	if len(rule.Hosts) > 0 && !matchHost(rule.Hosts, fields) {
+		return nil, false
+	}
+
+	switch rule.Source {
+	case EventSourceSyslog:
+		if len(rule.Facilities) > 0 && !slices.Contains(rule.Facilities, fields["facility"].(string)) {
+			return nil, false
+		}
+		if rule.Severity != "" &&
+			slices.Index(syslogSeverities, fields["severity"].(string)) > slices.Index(syslogSeverities, rule.Severity) {
+			return nil, false
+		}
+		if rule.message != nil {
+			match := rule.message.FindStringSubmatch(fields["message"].(string))
+			if match == nil {
+				return nil, false
+			}
+
+			matched := make(map[string]interface{}, len(fields))
+			for name, value := range fields {
+				matched[name] = value
+			}
+			for i, name := range rule.message.SubexpNames() {
+				if _, ok := matched[name]; name != "" && !ok {
+					matched[name] = match[i]
+				}
+			}
+			return matched, true
+		}
+	case EventSourceSNMP:
+		trapOID := strings.TrimPrefix(fields["trap_oid"].(string), ".")
+		if len(rule.OIDs) > 0 && !slices.ContainsFunc(rule.OIDs, func(oid string) bool {
+			oid = strings.TrimPrefix(oid, ".")
+			return trapOID == oid || strings.HasPrefix(trapOID, oid+".")
+		}) {
+			return nil, false
+		}
+	}
+
+	return fields, true
+}
+
+// matchHost matches the sender address against IPs and CIDRs, and its name against hostnames.
+func matchHost(hosts []string, fields map[string]interface{}) bool {
+	address := net.ParseIP(fields["address"].(string))
+	host, _ := fields["host"].(string)
+
+	return slices.ContainsFunc(hosts, func(entry string) bool {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			return address != nil && network.Contains(address)
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			return ip.Equal(address)
+		}
+		return strings.EqualFold(entry, host)
+	})
+}
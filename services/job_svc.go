@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/kriten-io/kriten/config"
@@ -14,9 +15,8 @@ import (
 	"strings"
 
 	"github.com/go-errors/errors"
-	"github.com/go-openapi/spec"
-	"github.com/go-openapi/strfmt"
-	"github.com/go-openapi/validate"
+	"golang.org/x/exp/slices"
+	batchv1 "k8s.io/api/batch/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
@@ -25,17 +25,20 @@ type JobService interface {
 	ListJobs([]string) ([]models.Job, error)
 	GetJob(string, string) (models.Job, error)
 	GetLog(string, string) (string, error)
-	CreateJob(string, string, string) (models.Job, error)
+	CreateJob(string, string, string, bool) (models.Job, error)
 	GetSchema(string) (map[string]interface{}, error)
+	GetOpenAPI([]string, string) (map[string]interface{}, error)
 }
 
 type JobServiceImpl struct {
-	config config.Config
+	MaintenanceWindowService MaintenanceWindowService
+	config                   config.Config
 }
 
-func NewJobService(config config.Config) JobService {
+func NewJobService(config config.Config, mws MaintenanceWindowService) JobService {
 	return &JobServiceImpl{
-		config: config,
+		MaintenanceWindowService: mws,
+		config:                   config,
 	}
 }
 
@@ -92,27 +95,42 @@ func (j *JobServiceImpl) ListJobs(authList []string) ([]models.Job, error) {
 		return nil, err
 	}
 
-	if len(jobs.Items) != 0 {
-		sort.SliceStable(jobs.Items, func(i, j int) bool {
-			return jobs.Items[i].Status.StartTime.After(jobs.Items[j].Status.StartTime.Time)
-		})
+	sortJobs(jobs.Items)
+	for i := range jobs.Items {
+		jobsList = append(jobsList, jobModel(&jobs.Items[i]))
 	}
 
-	for i := range jobs.Items {
-		job := &jobs.Items[i]
-		var jobRet models.Job
-		jobRet.ID = job.Name
-		jobRet.Owner = job.Labels["owner"]
-		jobRet.StartTime = job.Status.StartTime.Format(time.UnixDate)
-		if job.Status.CompletionTime != nil {
-			jobRet.CompletionTime = job.Status.CompletionTime.Format(time.UnixDate)
+	return jobsList, nil
+}
+
+// sortJobs sorts jobs from the most recent, jobs not started yet come first.
+func sortJobs(jobs []batchv1.Job) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Status.StartTime == nil || jobs[j].Status.StartTime == nil {
+			return jobs[i].Status.StartTime == nil && jobs[j].Status.StartTime != nil
 		}
-		jobRet.Failed = job.Status.Failed
-		jobRet.Completed = job.Status.Succeeded
-		jobsList = append(jobsList, jobRet)
+		return jobs[i].Status.StartTime.After(jobs[j].Status.StartTime.Time)
+	})
+}
+
+// jobModel returns the status of a job, without its logs.
+func jobModel(job *batchv1.Job) models.Job {
+	var ret models.Job
+
+	ret.ID = job.Name
+	ret.Owner = job.Labels["owner"]
+	if job.Status.StartTime != nil {
+		ret.StartTime = job.Status.StartTime.Format(time.UnixDate)
+	}
+	if job.Status.CompletionTime != nil {
+		ret.CompletionTime = job.Status.CompletionTime.Format(time.UnixDate)
 	}
+	ret.Failed = job.Status.Failed
+	ret.Completed = job.Status.Succeeded
+	ret.TaskRevision, _ = strconv.Atoi(job.Labels[helpers.LabelTaskRevision])
+	ret.CronJob = job.Labels[helpers.LabelCronJob]
 
-	return jobsList, nil
+	return ret
 }
 
 func (j *JobServiceImpl) GetJob(username string, jobID string) (models.Job, error) {
@@ -163,14 +181,7 @@ func (j *JobServiceImpl) GetJob(username string, jobID string) (models.Job, erro
 		return jobStatus, err
 	}
 
-	jobStatus.ID = job.Name
-	jobStatus.Owner = job.Labels["owner"]
-	jobStatus.StartTime = job.Status.StartTime.Format(time.UnixDate)
-	if job.Status.CompletionTime != nil {
-		jobStatus.CompletionTime = job.Status.CompletionTime.Format(time.UnixDate)
-	}
-	jobStatus.Failed = job.Status.Failed
-	jobStatus.Completed = job.Status.Succeeded
+	jobStatus = jobModel(job)
 
 	jobLog, err := j.GetLog(username, jobID)
 	if err != nil {
@@ -241,44 +252,38 @@ func (j *JobServiceImpl) GetLog(username string, jobID string) (string, error) {
 	return logs, nil
 }
 
-func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars string) (models.Job, error) {
+// CreateJob runs a task, override lets admins run it while maintenance windows block it.
+func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars string, override bool) (models.Job, error) {
 	var jobStatus models.Job
 
-	task, err := helpers.GetConfigMap(j.config.Kube, taskName)
+	task, err := getTaskSpec(j.config.Kube, taskName)
 	if err != nil {
 		return jobStatus, err
 	}
-	runnerName := task.Data["runner"]
 
-	if task.Data["schema"] != "" {
-		schema := new(spec.Schema)
-		_ = json.Unmarshal([]byte(task.Data["schema"]), schema)
-
-		input := map[string]interface{}{}
-
-		// JSON data to validate
-		_ = json.Unmarshal([]byte(extraVars), &input)
-
-		// strfmt.Default is the registry of recognized formats
-		err = validate.AgainstSchema(schema, input, strfmt.Default)
-		if err != nil {
-			log.Printf("JSON does not validate against schema: %v", err)
-			return models.Job{}, err
+	err = j.MaintenanceWindowService.CheckMaintenanceWindows(task, time.Now())
+	if err != nil {
+		var windowErr *MaintenanceWindowError
+		if !override || !errors.As(err, &windowErr) {
+			return jobStatus, err
 		}
+		log.Printf("%s overrode maintenance windows to run task %s: %v\n", username, taskName, err)
+		jobStatus.MaintenanceOverride = windowErr.Window
 	}
 
-	runner, err := helpers.GetConfigMap(j.config.Kube, runnerName)
+	extraVars, err = normaliseExtraVars(task, extraVars)
 	if err != nil {
-		return jobStatus, err
+		log.Printf("JSON does not validate against schema: %v", err)
+		return models.Job{}, err
 	}
-	runnerImage := runner.Data["image"]
-	gitURL := runner.Data["gitURL"]
-	gitBranch := runner.Data["branch"]
 
-	if gitBranch == "" {
-		gitBranch = "main"
+	runner, err := getRunnerSpec(j.config.Kube, task.Runner)
+	if err != nil {
+		return jobStatus, err
 	}
-	tokenObjName := runnerName + "-token"
+	gitURL := runner.GitURL
+
+	tokenObjName := runner.Name + "-token"
 	token, err := helpers.GetSecret(j.config.Kube, tokenObjName)
 	if err != nil {
 		if !kerrors.IsNotFound(err) {
@@ -291,16 +296,27 @@ func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars s
 		}
 	}
 
+	secretProvider, err := NewSecretProvider(j.config, runner.SecretProvider)
+	if err != nil {
+		return jobStatus, err
+	}
+	secretData, err := secretProvider.JobSecrets(runner)
+	if err != nil {
+		return jobStatus, err
+	}
+
 	jobID, err := helpers.CreateJob(
 		j.config.Kube,
 		taskName,
-		runnerName,
-		runnerImage,
+		runner.Name,
+		runner.Image,
 		username,
 		extraVars,
-		task.Data["command"],
+		task.Command,
 		gitURL,
-		gitBranch,
+		runner.Branch,
+		secretData,
+		jobLabels(task),
 	)
 
 	jobStatus.ID = jobID
@@ -309,7 +325,7 @@ func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars s
 		return jobStatus, err
 	}
 
-	if task.Data["synchronous"] == "true" {
+	if task.Synchronous {
 		_ = wait.Poll(100*time.Millisecond, 20*time.Second, func() (done bool, err error) {
 
 			job, err := helpers.GetJob(j.config.Kube, jobID)
@@ -327,6 +343,7 @@ func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars s
 		})
 
 		ret, err := j.GetJob(username, jobID)
+		ret.MaintenanceOverride = jobStatus.MaintenanceOverride
 		return ret, err
 	}
 
@@ -334,22 +351,292 @@ func (j *JobServiceImpl) CreateJob(username string, taskName string, extraVars s
 }
 
 func (j *JobServiceImpl) GetSchema(name string) (map[string]interface{}, error) {
-	var data map[string]interface{}
+	task, err := getTaskSpec(j.config.Kube, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.Schema, nil
+}
+
+// jobLabels stamps jobs with the revision of the task they were created from.
+func jobLabels(task *models.Task) map[string]string {
+	if task.Revision == 0 {
+		return nil
+	}
+
+	return map[string]string{helpers.LabelTaskRevision: strconv.Itoa(task.Revision)}
+}
 
-	configMap, err := helpers.GetConfigMap(j.config.Kube, name)
+// normaliseExtraVars fills the schema defaults and coerces the types of the job input,
+// the normalised document is the one passed to the job.
+func normaliseExtraVars(task *models.Task, extraVars string) (string, error) {
+	if task.Schema == nil {
+		return extraVars, nil
+	}
+
+	var input interface{} = map[string]interface{}{}
+	if strings.TrimSpace(extraVars) != "" {
+		// numbers are kept as they are, float64 would lose the precision of large integers
+		decoder := json.NewDecoder(strings.NewReader(extraVars))
+		decoder.UseNumber()
+		err := decoder.Decode(&input)
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON input: %w", err)
+		}
+	}
+
+	normalised, err := helpers.NormaliseInput(task.Schema, input, task.UnknownProperties)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(normalised)
+	if err != nil {
+		return "", err
+	}
+
+	err = helpers.ValidateJSON(task.Schema, data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// normaliseValues is normaliseExtraVars for decoded extra vars, e.g. the ones of a cronjob.
+func normaliseValues(task *models.Task, extraVars map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(extraVars)
+	if err != nil {
+		return nil, err
+	}
+	if extraVars == nil {
+		data = nil
+	}
+
+	normalised, err := normaliseExtraVars(task, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret map[string]interface{}
+	err = json.Unmarshal([]byte(normalised), &ret)
 	if err != nil {
 		return nil, err
 	}
-	if configMap.Data["runner"] == "" {
-		return nil, fmt.Errorf("task %s not found", name)
+
+	return ret, nil
+}
+
+// GetOpenAPI generates an OpenAPI 3.1 document with an operation for every task in authList,
+// request bodies are the task schemas and job data is described by their output schemas.
+func (j *JobServiceImpl) GetOpenAPI(authList []string, serverURL string) (map[string]interface{}, error) {
+	paths := map[string]interface{}{
+		"/jobs/{id}": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getJob",
+				"summary":     "Get the status and output of a job",
+				"tags":        []string{"jobs"},
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":     "id",
+						"in":       "path",
+						"required": true,
+						"schema":   map[string]interface{}{"type": "string"},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200":     jsonResponse("Job status", map[string]interface{}{"$ref": "#/components/schemas/Job"}),
+					"default": jsonResponse("Error", map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+				},
+			},
+		},
+	}
+	schemas := map[string]interface{}{
+		"Job": jobSchema(nil),
+		"JobCreated": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"msg": map[string]interface{}{"type": "string"},
+				"id":  map[string]interface{}{"type": "string"},
+			},
+		},
+		"Error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{"type": "string"},
+			},
+		},
 	}
 
-	if configMap.Data["schema"] != "" {
-		err = json.Unmarshal([]byte(configMap.Data["schema"]), &data)
+	if len(authList) != 0 {
+		objs, err := helpers.ListResources(j.config.Kube, helpers.KindTask, "")
 		if err != nil {
 			return nil, err
 		}
+
+		for i := range objs {
+			if authList[0] != "*" && !slices.Contains(authList, objs[i].GetName()) {
+				continue
+			}
+			var task models.Task
+			err = helpers.ResourceToModel(&objs[i], &task)
+			if err != nil {
+				return nil, err
+			}
+
+			paths["/jobs/"+task.Name] = map[string]interface{}{
+				"post": taskOperation(&task, operationID(task.Name, schemas), schemas),
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Kriten tasks",
+			"description": "Tasks that can be executed as jobs by the current user",
+			"version":     "1.0.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": serverURL},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"Bearer": []string{}},
+			map[string]interface{}{"Token": []string{}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"Bearer": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"Token": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "Token",
+				},
+			},
+		},
+	}, nil
+}
+
+// operationID returns the identifier of the operation and components of a task. They're used as identifiers
+// by SDK generators, names differing only by "-" and "." are numbered so they don't collide once sanitised.
+func operationID(name string, schemas map[string]interface{}) string {
+	id := strings.NewReplacer("-", "_", ".", "_").Replace(name)
+
+	unique := id
+	for i := 2; schemas[unique+"_input"] != nil; i++ {
+		unique = fmt.Sprintf("%s_%d", id, i)
+	}
+
+	return unique
+}
+
+func taskOperation(task *models.Task, id string, schemas map[string]interface{}) map[string]interface{} {
+	// local references of the task schemas are relative to the components they're moved to
+	input := map[string]interface{}{"type": "object"}
+	if task.Schema != nil {
+		input = rebaseRefs(task.Schema, "#/components/schemas/"+id+"_input").(map[string]interface{})
+	}
+	schemas[id+"_input"] = input
+
+	var response map[string]interface{}
+	if task.Synchronous {
+		// synchronous jobs return their output, unless they take too long to complete
+		var output map[string]interface{}
+		if task.OutputSchema != nil {
+			base := "#/components/schemas/" + id + "_result/properties/json_data"
+			output = rebaseRefs(task.OutputSchema, base).(map[string]interface{})
+		}
+		schemas[id+"_result"] = jobSchema(output)
+		response = jsonResponse("Job result, or the job ID if it's still running", map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"$ref": "#/components/schemas/" + id + "_result"},
+				map[string]interface{}{"$ref": "#/components/schemas/JobCreated"},
+			},
+		})
+	} else {
+		response = jsonResponse("Job created", map[string]interface{}{"$ref": "#/components/schemas/JobCreated"})
+	}
+
+	return map[string]interface{}{
+		"operationId": "run_" + id,
+		"summary":     "Run task " + task.Name,
+		"tags":        []string{task.Runner},
+		"requestBody": map[string]interface{}{
+			"required": task.Schema != nil,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/" + id + "_input"},
+				},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200":     response,
+			"default": jsonResponse("Error", map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		},
+	}
+}
+
+// rebaseRefs returns a copy of a JSON schema whose local references, e.g. "#/$defs/host",
+// point to the same location once the schema is moved to base.
+func rebaseRefs(schema interface{}, base string) interface{} {
+	switch value := schema.(type) {
+	case map[string]interface{}:
+		rebased := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			if ref, ok := v.(string); ok && k == "$ref" && (ref == "#" || strings.HasPrefix(ref, "#/")) {
+				rebased[k] = base + strings.TrimPrefix(ref, "#")
+				continue
+			}
+			rebased[k] = rebaseRefs(v, base)
+		}
+		return rebased
+	case []interface{}:
+		rebased := make([]interface{}, len(value))
+		for i, v := range value {
+			rebased[i] = rebaseRefs(v, base)
+		}
+		return rebased
+	default:
+		return value
 	}
+}
 
-	return data, nil
+// jobSchema describes models.Job, json_data follows the output schema when it's set.
+func jobSchema(output map[string]interface{}) map[string]interface{} {
+	if output == nil {
+		output = map[string]interface{}{"type": "object"}
+	}
+
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":              map[string]interface{}{"type": "string"},
+			"owner":           map[string]interface{}{"type": "string"},
+			"start_time":      map[string]interface{}{"type": "string"},
+			"completion_time": map[string]interface{}{"type": "string"},
+			"failed":          map[string]interface{}{"type": "integer"},
+			"completed":       map[string]interface{}{"type": "integer"},
+			"task_revision":   map[string]interface{}{"type": "integer"},
+			"stdout":          map[string]interface{}{"type": "string"},
+			"json_data":       output,
+		},
+	}
+}
+
+func jsonResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
 }
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+)
+
+const (
+	MaintenanceAllow = "allow"
+	MaintenanceDeny  = "deny"
+)
+
+// MaintenanceWindowError is returned when maintenance windows don't let a task run.
+type MaintenanceWindowError struct {
+	Task   string
+	Effect string
+	Window string // names of the windows blocking the task
+}
+
+func (e *MaintenanceWindowError) Error() string {
+	if e.Effect == MaintenanceAllow {
+		return fmt.Sprintf("task %s can only run during maintenance windows: %s", e.Task, e.Window)
+	}
+	return fmt.Sprintf("task %s is blocked by maintenance window: %s", e.Task, e.Window)
+}
+
+type MaintenanceWindowService interface {
+	ListMaintenanceWindows([]string) ([]models.MaintenanceWindow, error)
+	GetMaintenanceWindow(string) (models.MaintenanceWindow, error)
+	CreateMaintenanceWindow(models.MaintenanceWindow) (models.MaintenanceWindow, error)
+	UpdateMaintenanceWindow(models.MaintenanceWindow) (models.MaintenanceWindow, error)
+	DeleteMaintenanceWindow(string) error
+	CheckMaintenanceWindows(*models.Task, time.Time) error
+}
+
+type MaintenanceWindowServiceImpl struct {
+	db     *gorm.DB
+	config config.Config
+}
+
+func NewMaintenanceWindowService(database *gorm.DB, config config.Config) MaintenanceWindowService {
+	return &MaintenanceWindowServiceImpl{
+		db:     database,
+		config: config,
+	}
+}
+
+func (m *MaintenanceWindowServiceImpl) ListMaintenanceWindows(authList []string) ([]models.MaintenanceWindow, error) {
+	var windows []models.MaintenanceWindow
+	var res *gorm.DB
+
+	if len(authList) == 0 {
+		return windows, nil
+	} else if slices.Contains(authList, "*") {
+		res = m.db.Order("name").Find(&windows)
+	} else {
+		res = m.db.Where("name IN ?", authList).Order("name").Find(&windows)
+	}
+
+	return windows, res.Error
+}
+
+func (m *MaintenanceWindowServiceImpl) GetMaintenanceWindow(name string) (models.MaintenanceWindow, error) {
+	var window models.MaintenanceWindow
+	res := m.db.Where("name = ?", name).Find(&window)
+	if res.Error != nil {
+		return models.MaintenanceWindow{}, res.Error
+	}
+
+	if window.Name == "" {
+		return models.MaintenanceWindow{}, fmt.Errorf("maintenance window %s not found, please check name", name)
+	}
+
+	return window, nil
+}
+
+func (m *MaintenanceWindowServiceImpl) CreateMaintenanceWindow(window models.MaintenanceWindow) (models.MaintenanceWindow, error) {
+	err := checkMaintenanceWindow(window)
+	if err != nil {
+		return window, err
+	}
+
+	res := m.db.Create(&window)
+	return window, res.Error
+}
+
+func (m *MaintenanceWindowServiceImpl) UpdateMaintenanceWindow(window models.MaintenanceWindow) (models.MaintenanceWindow, error) {
+	err := checkMaintenanceWindow(window)
+	if err != nil {
+		return window, err
+	}
+
+	current, err := m.GetMaintenanceWindow(window.Name)
+	if err != nil {
+		return window, err
+	}
+	window.ID = current.ID
+	window.CreatedAt = current.CreatedAt
+
+	// Save also clears the fields that aren't set, e.g. switching from one-off to recurring
+	res := m.db.Save(&window)
+	if res.Error != nil {
+		return models.MaintenanceWindow{}, res.Error
+	}
+
+	return m.GetMaintenanceWindow(window.Name)
+}
+
+func (m *MaintenanceWindowServiceImpl) DeleteMaintenanceWindow(name string) error {
+	window, err := m.GetMaintenanceWindow(name)
+	if err != nil {
+		return err
+	}
+
+	return m.db.Unscoped().Delete(&window).Error
+}
+
+// CheckMaintenanceWindows returns a MaintenanceWindowError when a task can't run at a given time:
+// a deny window is active, or allow windows apply to the task and none of them is active.
+func (m *MaintenanceWindowServiceImpl) CheckMaintenanceWindows(task *models.Task, at time.Time) error {
+	var windows []models.MaintenanceWindow
+	res := m.db.Where("disable = ?", false).Order("name").Find(&windows)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var allowWindows []string
+	allowed := false
+	for _, window := range windows {
+		if !windowApplies(window, task) {
+			continue
+		}
+
+		active, err := windowActive(window, at)
+		if err != nil {
+			// Windows are validated when they're saved, one that can't be parsed anymore
+			// (e.g. a time zone removed from tzdata) fails closed: deny windows stay active.
+			log.Printf("Invalid maintenance window %s, applying it as %s: %v\n", window.Name, window.Effect, err)
+			active = window.Effect == MaintenanceDeny
+		}
+
+		switch window.Effect {
+		case MaintenanceDeny:
+			if active {
+				return &MaintenanceWindowError{Task: task.Name, Effect: MaintenanceDeny, Window: window.Name}
+			}
+		case MaintenanceAllow:
+			allowWindows = append(allowWindows, window.Name)
+			allowed = allowed || active
+		}
+	}
+
+	if len(allowWindows) > 0 && !allowed {
+		return &MaintenanceWindowError{
+			Task:   task.Name,
+			Effect: MaintenanceAllow,
+			Window: strings.Join(allowWindows, ", "),
+		}
+	}
+
+	return nil
+}
+
+func checkMaintenanceWindow(window models.MaintenanceWindow) error {
+	if window.Effect != MaintenanceAllow && window.Effect != MaintenanceDeny {
+		return fmt.Errorf("invalid effect %s, allowed: allow, deny", window.Effect)
+	}
+
+	oneOff := window.Start != nil || window.End != nil
+	recurring := window.Schedule != "" || window.Duration != ""
+	switch {
+	case oneOff && recurring:
+		return errors.New("a maintenance window is either one-off (start, end) or recurring (schedule, duration)")
+	case oneOff:
+		if window.Start == nil || window.End == nil || !window.End.After(*window.Start) {
+			return errors.New("one-off maintenance windows need a start and a later end")
+		}
+	case recurring:
+		if window.Schedule == "" || window.Duration == "" {
+			return errors.New("recurring maintenance windows need a schedule and a duration")
+		}
+		_, _, _, err := parseSchedule(window)
+		return err
+	default:
+		return errors.New("maintenance window has neither start and end nor schedule and duration")
+	}
+
+	return nil
+}
+
+func windowApplies(window models.MaintenanceWindow, task *models.Task) bool {
+	if len(window.Tasks) == 0 && len(window.Runners) == 0 && len(window.Tags) == 0 {
+		return true
+	}
+
+	return slices.Contains(window.Tasks, task.Name) ||
+		slices.Contains(window.Runners, task.Runner) ||
+		slices.ContainsFunc(task.Tags, func(tag string) bool { return slices.Contains(window.Tags, tag) })
+}
+
+func windowActive(window models.MaintenanceWindow, at time.Time) (bool, error) {
+	if window.Schedule == "" {
+		if window.Start == nil || window.End == nil {
+			return false, errors.New("one-off maintenance windows need a start and an end")
+		}
+		return !at.Before(*window.Start) && at.Before(*window.End), nil
+	}
+
+	schedule, duration, location, err := parseSchedule(window)
+	if err != nil {
+		return false, err
+	}
+
+	// The window is active when an occurrence started less than its duration ago
+	start := schedule.Next(at.In(location).Add(-duration))
+	return !start.IsZero() && !start.After(at), nil
+}
+
+// parseSchedule parses the schedule, duration and time zone of a recurring window.
+func parseSchedule(window models.MaintenanceWindow) (cron.Schedule, time.Duration, *time.Location, error) {
+	location := time.UTC
+	if window.TimeZone != "" {
+		var err error
+		location, err = time.LoadLocation(window.TimeZone)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("invalid time zone %s: %w", window.TimeZone, err)
+		}
+	}
+
+	schedule, err := cron.ParseStandard(window.Schedule)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("invalid schedule %s: %w", window.Schedule, err)
+	}
+
+	duration, err := time.ParseDuration(window.Duration)
+	if err != nil || duration <= 0 {
+		return nil, 0, nil, fmt.Errorf("invalid duration %s", window.Duration)
+	}
+
+	return schedule, duration, location, nil
+}
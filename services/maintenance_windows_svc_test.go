@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/lib/pq"
+)
+
+func testMaintenanceWindowService(t *testing.T) *MaintenanceWindowServiceImpl {
+	return &MaintenanceWindowServiceImpl{db: testDB(t, &models.MaintenanceWindow{})}
+}
+
+func TestCheckMaintenanceWindow(t *testing.T) {
+	start := time.Date(2026, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+
+	tests := []struct {
+		name   string
+		window models.MaintenanceWindow
+		valid  bool
+	}{
+		{"one-off", models.MaintenanceWindow{Effect: MaintenanceDeny, Start: &start, End: &end}, true},
+		{"recurring", models.MaintenanceWindow{Effect: MaintenanceAllow, Schedule: "0 22 * * 6", Duration: "4h", TimeZone: "Europe/London"}, true},
+		{"invalid effect", models.MaintenanceWindow{Effect: "block", Start: &start, End: &end}, false},
+		{"end before start", models.MaintenanceWindow{Effect: MaintenanceDeny, Start: &end, End: &start}, false},
+		{"both kinds", models.MaintenanceWindow{Effect: MaintenanceDeny, Start: &start, End: &end, Schedule: "0 22 * * 6", Duration: "4h"}, false},
+		{"neither kind", models.MaintenanceWindow{Effect: MaintenanceDeny}, false},
+		{"missing duration", models.MaintenanceWindow{Effect: MaintenanceDeny, Schedule: "0 22 * * 6"}, false},
+		{"invalid schedule", models.MaintenanceWindow{Effect: MaintenanceDeny, Schedule: "every saturday", Duration: "4h"}, false},
+		{"invalid duration", models.MaintenanceWindow{Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "4 hours"}, false},
+		{"negative duration", models.MaintenanceWindow{Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "-4h"}, false},
+		{"invalid time zone", models.MaintenanceWindow{Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "4h", TimeZone: "Mars/Olympus"}, false},
+	}
+
+	for _, test := range tests {
+		err := checkMaintenanceWindow(test.window)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestSaveMaintenanceWindowValidatesSchedule(t *testing.T) {
+	m := testMaintenanceWindowService(t)
+
+	window := models.MaintenanceWindow{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 8", Duration: "4h"}
+	if _, err := m.CreateMaintenanceWindow(window); err == nil {
+		t.Error("expected a window with an invalid schedule to be rejected")
+	}
+
+	window.Schedule = "0 22 * * 6"
+	if _, err := m.CreateMaintenanceWindow(window); err != nil {
+		t.Fatal(err)
+	}
+	window.Schedule = "0 25 * * 6"
+	if _, err := m.UpdateMaintenanceWindow(window); err == nil {
+		t.Error("expected an update with an invalid schedule to be rejected")
+	}
+	if stored, _ := m.GetMaintenanceWindow("freeze"); stored.Schedule != "0 22 * * 6" {
+		t.Errorf("invalid schedule stored: %s", stored.Schedule)
+	}
+}
+
+func TestCheckMaintenanceWindows(t *testing.T) {
+	// Saturday 23:00 UTC
+	at := time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC)
+	task := &models.Task{Name: "backup", Runner: "ansible", Tags: []string{"database"}}
+
+	tests := []struct {
+		name    string
+		windows []models.MaintenanceWindow
+		blocked string
+	}{
+		{"no windows", nil, ""},
+		{"active deny", []models.MaintenanceWindow{
+			{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "4h"},
+		}, MaintenanceDeny},
+		{"inactive deny", []models.MaintenanceWindow{
+			{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 0", Duration: "4h"},
+		}, ""},
+		{"deny for other tasks", []models.MaintenanceWindow{
+			{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "4h", Tags: pq.StringArray{"network"}},
+		}, ""},
+		{"disabled deny", []models.MaintenanceWindow{
+			{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 6", Duration: "4h", Disable: true},
+		}, ""},
+		{"active allow", []models.MaintenanceWindow{
+			{Name: "weekend", Effect: MaintenanceAllow, Schedule: "0 20 * * 6", Duration: "8h", Runners: pq.StringArray{"ansible"}},
+			{Name: "nights", Effect: MaintenanceAllow, Schedule: "0 2 * * *", Duration: "2h"},
+		}, ""},
+		{"inactive allow", []models.MaintenanceWindow{
+			{Name: "nights", Effect: MaintenanceAllow, Schedule: "0 2 * * *", Duration: "2h", Tasks: pq.StringArray{"backup"}},
+		}, MaintenanceAllow},
+		{"invalid deny", []models.MaintenanceWindow{
+			{Name: "freeze", Effect: MaintenanceDeny, Schedule: "0 22 * * 0", Duration: "4h", TimeZone: "Mars/Olympus"},
+		}, MaintenanceDeny},
+		{"invalid allow", []models.MaintenanceWindow{
+			{Name: "weekend", Effect: MaintenanceAllow, Schedule: "every saturday", Duration: "8h"},
+		}, MaintenanceAllow},
+	}
+
+	for _, test := range tests {
+		m := testMaintenanceWindowService(t)
+		for _, window := range test.windows {
+			// Stored as they are, e.g. saved by an earlier version
+			if err := m.db.Create(&window).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := m.CheckMaintenanceWindows(task, at)
+		var windowErr *MaintenanceWindowError
+		if test.blocked == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.blocked != "" && (!errors.As(err, &windowErr) || windowErr.Effect != test.blocked) {
+			t.Errorf("%s: expected the task to be blocked by %s windows, got %v", test.name, test.blocked, err)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+const (
+	ChannelSlack = "slack"
+	ChannelTeams = "teams"
+	ChannelEmail = "email"
+)
+
+const (
+	JobStarted   = "started"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+const (
+	defaultChannelLogLines = 20
+	// Slack truncates longer messages, Teams rejects them
+	maxChannelLogSize = 3000
+)
+
+var jobStatuses = []string{JobStarted, JobSucceeded, JobFailed}
+
+// defaultChannelTemplate is used by channels without a template, the first line is the subject of emails.
+const defaultChannelTemplate = `Kriten job {{.Job}} {{.Status}}
+Task: {{.Task}}{{if .CronJob}} (cronjob {{.CronJob}}){{end}}
+Owner: {{.Owner}}
+{{- if .Duration}}
+Duration: {{.Duration}}{{end}}
+{{- if .Reason}}
+Reason: {{.Reason}}{{end}}
+{{- if .Link}}
+Link: {{.Link}}{{end}}
+{{- if .Log}}
+` + "```" + `
+{{.Log}}
+` + "```" + `{{end}}`
+
+func (n *NotificationServiceImpl) ListChannels(authList []string) ([]models.NotificationChannel, error) {
+	var channels []models.NotificationChannel
+	var res *gorm.DB
+
+	if len(authList) == 0 {
+		return channels, nil
+	} else if slices.Contains(authList, "*") {
+		res = n.db.Order("name").Find(&channels)
+	} else {
+		res = n.db.Where("name IN ?", authList).Order("name").Find(&channels)
+	}
+
+	return channels, res.Error
+}
+
+func (n *NotificationServiceImpl) GetChannel(name string) (models.NotificationChannel, error) {
+	var channel models.NotificationChannel
+	res := n.db.Where("name = ?", name).Find(&channel)
+	if res.Error != nil {
+		return models.NotificationChannel{}, res.Error
+	}
+
+	if channel.Name == "" {
+		return models.NotificationChannel{}, fmt.Errorf("notification channel %s not found, please check name", name)
+	}
+
+	return channel, nil
+}
+
+func (n *NotificationServiceImpl) CreateChannel(channel models.NotificationChannel) (models.NotificationChannel, error) {
+	err := checkChannel(channel, n.allowedHosts)
+	if err != nil {
+		return channel, err
+	}
+
+	res := n.db.Create(&channel)
+	return channel, res.Error
+}
+
+// UpdateChannel replaces a channel, its URL is kept when not set.
+func (n *NotificationServiceImpl) UpdateChannel(channel models.NotificationChannel) (models.NotificationChannel, error) {
+	current, err := n.GetChannel(channel.Name)
+	if err != nil {
+		return channel, err
+	}
+	channel.ID = current.ID
+	channel.Owner = current.Owner
+	channel.CreatedAt = current.CreatedAt
+	if channel.URL == "" {
+		channel.URL = current.URL
+	}
+
+	err = checkChannel(channel, n.allowedHosts)
+	if err != nil {
+		return channel, err
+	}
+
+	res := n.db.Save(&channel)
+	if res.Error != nil {
+		return models.NotificationChannel{}, res.Error
+	}
+
+	return n.GetChannel(channel.Name)
+}
+
+func (n *NotificationServiceImpl) DeleteChannel(name string) error {
+	channel, err := n.GetChannel(name)
+	if err != nil {
+		return err
+	}
+
+	err = n.db.Where("channel = ?", channel.ID).Delete(&models.ChannelMessage{}).Error
+	if err != nil {
+		return err
+	}
+	return n.db.Unscoped().Delete(&channel).Error
+}
+
+func (n *NotificationServiceImpl) ListChannelMessages(name string) ([]models.ChannelMessage, error) {
+	var messages []models.ChannelMessage
+
+	channel, err := n.GetChannel(name)
+	if err != nil {
+		return messages, err
+	}
+
+	res := n.db.Where("channel = ?", channel.ID).Order("created_at DESC").Find(&messages)
+	return messages, res.Error
+}
+
+// notifyChannels queues a message for the channels of a job, once per job status, when the channel
+// owner can read the jobs of its task. The job log is only read when a channel needs it.
+func (n *NotificationServiceImpl) notifyChannels(channels []models.NotificationChannel, roles ownerRoles,
+	job *batchv1.Job, status string, reason string, end *time.Time) {
+	notification := models.JobNotification{
+		Job:     job.Name,
+		Task:    job.Labels["task-name"],
+		CronJob: jobModel(job).CronJob,
+		Owner:   job.Labels["owner"],
+		Status:  status,
+		Reason:  reason,
+		EndTime: end,
+	}
+	if job.Status.StartTime != nil {
+		notification.StartTime = &job.Status.StartTime.Time
+		if end != nil {
+			notification.Duration = end.Sub(job.Status.StartTime.Time).Round(time.Second)
+		}
+	}
+	if n.config.ExternalURL != "" {
+		notification.Link = strings.TrimSuffix(n.config.ExternalURL, "/") + "/api/v1/jobs/" + job.Name
+	}
+
+	eventID := job.Name + "/" + status
+	var jobLog *string
+
+	for _, channel := range channels {
+		if !channelApplies(channel, notification) {
+			continue
+		}
+
+		var count int64
+		res := n.db.Model(&models.ChannelMessage{}).Where("channel = ? AND event_id = ?", channel.ID, eventID).Count(&count)
+		if res.Error != nil {
+			log.Printf("Failed to notify channel %s: %v\n", channel.Name, res.Error)
+			continue
+		}
+		if count > 0 || !roles.canRead(channel.Owner, "jobs", notification.Task) {
+			continue
+		}
+
+		if status != JobStarted && jobLog == nil {
+			output, err := n.JobService.GetLog("", job.Name)
+			if err != nil {
+				output = fmt.Sprintf("failed to read logs: %v", err)
+			}
+			jobLog = &output
+		}
+		notification.Channel = channel.Name
+		if jobLog != nil {
+			notification.Log = logTail(*jobLog, channel.LogLines)
+		}
+
+		message := models.ChannelMessage{
+			Channel:       channel.ID,
+			EventID:       eventID,
+			Job:           job.Name,
+			JobStatus:     status,
+			Status:        NotificationPending,
+			NextAttemptAt: time.Now(),
+		}
+		var err error
+		message.Subject, message.Text, err = renderChannelMessage(channel, notification)
+		if err != nil {
+			message.Status = NotificationFailed
+			message.Error = err.Error()
+		}
+
+		res = n.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&message)
+		if res.Error != nil {
+			log.Printf("Failed to notify channel %s: %v\n", channel.Name, res.Error)
+		}
+	}
+}
+
+// DeliverChannelMessages posts the messages due to the channels, like DeliverNotifications.
+func (n *NotificationServiceImpl) DeliverChannelMessages() error {
+	var messages []models.ChannelMessage
+
+	// Messages are claimed by moving their next attempt, they're posted once the claim is committed
+	err := n.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", NotificationPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(notificationsBatch).
+			Find(&messages)
+		if res.Error != nil || len(messages) == 0 {
+			return res.Error
+		}
+
+		ids := make([]uuid.UUID, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		return tx.Model(&models.ChannelMessage{}).
+			Where("message_id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(notificationLease)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		message := &messages[i]
+
+		var channel models.NotificationChannel
+		res := n.db.Where("channel_id = ?", message.Channel).Find(&channel)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		err := n.sendChannelMessage(channel, *message)
+		message.Attempts++
+		switch {
+		case err == nil:
+			message.Status = NotificationDelivered
+			message.Error = ""
+		case message.Attempts >= n.config.NotificationMaxAttempts || channel.Name == "":
+			message.Status = NotificationFailed
+			message.Error = err.Error()
+		default:
+			message.Error = err.Error()
+			message.NextAttemptAt = time.Now().Add(notificationBackoff(message.Attempts))
+		}
+
+		err = n.db.Model(message).
+			Select("status", "attempts", "next_attempt_at", "error").
+			Updates(message).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NotificationServiceImpl) sendChannelMessage(channel models.NotificationChannel, message models.ChannelMessage) error {
+	if channel.Name == "" {
+		return errors.New("notification channel not found")
+	}
+	if channel.Disable {
+		return errors.New("notification channel disabled")
+	}
+
+	switch channel.Type {
+	case ChannelSlack:
+		return n.postJSON(channel.URL, map[string]interface{}{"text": message.Text})
+	case ChannelTeams:
+		return n.postJSON(channel.URL, map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  message.Subject,
+			"text":     teamsText(message.Text),
+		})
+	case ChannelEmail:
+		return n.sendEmail(channel.Recipients, message)
+	}
+
+	return fmt.Errorf("unknown notification channel type %s", channel.Type)
+}
+
+func (n *NotificationServiceImpl) postJSON(endpoint string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return n.do(req)
+}
+
+// sendEmail sends a plain text email, the body is the message without its first line, the subject.
+func (n *NotificationServiceImpl) sendEmail(recipients []string, message models.ChannelMessage) error {
+	conf := n.config.SMTP
+	if conf.Host == "" {
+		return errors.New("no SMTP server configured, please set SMTP_HOST")
+	}
+
+	var auth smtp.Auth
+	if conf.User != "" {
+		auth = smtp.PlainAuth("", conf.User, conf.Password, conf.Host)
+	}
+
+	_, body, _ := strings.Cut(message.Text, "\n")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)), auth, conf.From, recipients, msg.Bytes())
+}
+
+func checkChannel(channel models.NotificationChannel, allowedHosts []string) error {
+	switch channel.Type {
+	case ChannelSlack, ChannelTeams:
+		endpoint, err := url.Parse(channel.URL)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("%s channels need the http or https url of an incoming webhook", channel.Type)
+		}
+		if err := checkDestination(endpoint, allowedHosts); err != nil {
+			return err
+		}
+	case ChannelEmail:
+		if len(channel.Recipients) == 0 {
+			return errors.New("email channels need at least one recipient")
+		}
+		for _, recipient := range channel.Recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				return fmt.Errorf("invalid recipient %s: %w", recipient, err)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid channel type %s, allowed: slack, teams, email", channel.Type)
+	}
+
+	for _, status := range channel.On {
+		if !slices.Contains(jobStatuses, status) {
+			return fmt.Errorf("invalid job status %s, allowed: %s", status, strings.Join(jobStatuses, ", "))
+		}
+	}
+	if channel.LogLines < 0 {
+		return errors.New("log_lines can't be negative")
+	}
+	if _, err := channelTemplate(channel); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+
+	return nil
+}
+
+func channelApplies(channel models.NotificationChannel, notification models.JobNotification) bool {
+	on := channel.On
+	if len(on) == 0 {
+		on = []string{JobFailed}
+	}
+	if channel.Disable || !slices.Contains(on, notification.Status) {
+		return false
+	}
+	if len(channel.Tasks) == 0 && len(channel.CronJobs) == 0 {
+		return true
+	}
+
+	return slices.Contains(channel.Tasks, notification.Task) ||
+		(notification.CronJob != "" && slices.Contains(channel.CronJobs, notification.CronJob))
+}
+
+func channelTemplate(channel models.NotificationChannel) (*template.Template, error) {
+	text := channel.Template
+	if text == "" {
+		text = defaultChannelTemplate
+	}
+	return template.New(channel.Name).Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderChannelMessage executes the channel template, returning the subject and the whole message.
+func renderChannelMessage(channel models.NotificationChannel, notification models.JobNotification) (string, string, error) {
+	tmpl, err := channelTemplate(channel)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, notification); err != nil {
+		return "", "", fmt.Errorf("template failed: %w", err)
+	}
+
+	text := strings.TrimSpace(out.String())
+	subject, _, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(subject), text, nil
+}
+
+// teamsText keeps the lines of a message apart, Teams only renders line breaks between paragraphs
+// outside of code blocks.
+func teamsText(text string) string {
+	lines := strings.Split(text, "\n")
+	code := false
+	for i, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "```") {
+			code = !code
+		}
+		if !code && !strings.HasPrefix(lines[i+1], "```") {
+			lines[i] += "\n"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// logTail returns the last lines of a job log, cut to a size chat services accept.
+func logTail(jobLog string, lines int) string {
+	if lines == 0 {
+		lines = defaultChannelLogLines
+	}
+
+	all := strings.Split(strings.TrimRight(jobLog, "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	tail := strings.Join(all, "\n")
+	if len(tail) > maxChannelLogSize {
+		tail = "..." + tail[len(tail)-maxChannelLogSize:]
+	}
+
+	return tail
+}
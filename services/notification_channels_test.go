@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+)
+
+// smtpServer is a local SMTP stand-in accepting a single message.
+type smtpServer struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSendChannelMessageSlack(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type %s", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	n := &NotificationServiceImpl{client: server.Client()}
+	channel := models.NotificationChannel{Name: "ops", Type: ChannelSlack, URL: server.URL}
+	message := models.ChannelMessage{Subject: "Kriten job test-abc failed", Text: "Kriten job test-abc failed\nTask: test"}
+
+	if err := n.sendChannelMessage(channel, message); err != nil {
+		t.Fatal(err)
+	}
+	if body["text"] != message.Text {
+		t.Errorf("unexpected text %q", body["text"])
+	}
+}
+
+func TestSendChannelMessageTeams(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	n := &NotificationServiceImpl{client: server.Client()}
+	channel := models.NotificationChannel{Name: "ops", Type: ChannelTeams, URL: server.URL}
+	message := models.ChannelMessage{
+		Subject: "Kriten job test-abc failed",
+		Text:    "Kriten job test-abc failed\nTask: test\n```\nline 1\nline 2\n```",
+	}
+
+	if err := n.sendChannelMessage(channel, message); err != nil {
+		t.Fatal(err)
+	}
+	if body["@type"] != "MessageCard" || body["summary"] != message.Subject {
+		t.Errorf("unexpected card %v", body)
+	}
+	expected := "Kriten job test-abc failed\n\nTask: test\n```\nline 1\nline 2\n```"
+	if body["text"] != expected {
+		t.Errorf("unexpected text %q", body["text"])
+	}
+}
+
+func TestSendChannelMessageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	n := &NotificationServiceImpl{client: server.Client()}
+	channel := models.NotificationChannel{Name: "ops", Type: ChannelSlack, URL: server.URL}
+
+	if err := n.sendChannelMessage(channel, models.ChannelMessage{Text: "test"}); err == nil {
+		t.Error("expected an error on a 403 response")
+	}
+
+	channel.Disable = true
+	if err := n.sendChannelMessage(channel, models.ChannelMessage{Text: "test"}); err == nil {
+		t.Error("expected an error on a disabled channel")
+	}
+}
+
+func TestSendChannelMessageEmail(t *testing.T) {
+	server := newSMTPServer(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	n := &NotificationServiceImpl{config: config.Config{
+		SMTP: config.SMTPConfig{Host: host, Port: portNumber, From: "kriten@example.com"},
+	}}
+	channel := models.NotificationChannel{
+		Name:       "ops",
+		Type:       ChannelEmail,
+		Recipients: []string{"ops@example.com", "oncall@example.com"},
+	}
+	message := models.ChannelMessage{Subject: "Kriten job test-abc failed", Text: "Kriten job test-abc failed\nTask: test\nOwner: admin"}
+
+	if err := n.sendChannelMessage(channel, message); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "kriten@example.com" {
+		t.Errorf("unexpected sender %s", server.from)
+	}
+	if strings.Join(server.to, ",") != "ops@example.com,oncall@example.com" {
+		t.Errorf("unexpected recipients %v", server.to)
+	}
+	headers, body, _ := strings.Cut(server.data, "\r\n\r\n")
+	if !strings.Contains(headers, "Subject: Kriten job test-abc failed\r\n") {
+		t.Errorf("missing subject in %q", headers)
+	}
+	if body != "Task: test\r\nOwner: admin\r\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestSendChannelMessageEmailWithoutServer(t *testing.T) {
+	n := &NotificationServiceImpl{}
+	channel := models.NotificationChannel{Name: "ops", Type: ChannelEmail, Recipients: []string{"ops@example.com"}}
+
+	if err := n.sendChannelMessage(channel, models.ChannelMessage{Text: "test"}); err == nil {
+		t.Error("expected an error without SMTP_HOST")
+	}
+}
+
+func TestChannelApplies(t *testing.T) {
+	failed := models.JobNotification{Task: "backup", CronJob: "nightly", Status: JobFailed}
+	succeeded := models.JobNotification{Task: "backup", Status: JobSucceeded}
+
+	tests := []struct {
+		name         string
+		channel      models.NotificationChannel
+		notification models.JobNotification
+		expected     bool
+	}{
+		{"failures by default", models.NotificationChannel{}, failed, true},
+		{"successes not by default", models.NotificationChannel{}, succeeded, false},
+		{"status", models.NotificationChannel{On: []string{JobSucceeded}}, succeeded, true},
+		{"disabled", models.NotificationChannel{Disable: true}, failed, false},
+		{"task", models.NotificationChannel{Tasks: []string{"backup"}}, failed, true},
+		{"other task", models.NotificationChannel{Tasks: []string{"restore"}}, failed, false},
+		{"cronjob", models.NotificationChannel{CronJobs: []string{"nightly"}}, failed, true},
+		{"job without cronjob", models.NotificationChannel{CronJobs: []string{"nightly"}, On: []string{JobSucceeded}},
+			succeeded, false},
+	}
+
+	for _, test := range tests {
+		if channelApplies(test.channel, test.notification) != test.expected {
+			t.Errorf("%s: expected %v", test.name, test.expected)
+		}
+	}
+}
+
+func TestRenderChannelMessage(t *testing.T) {
+	notification := models.JobNotification{
+		Job:      "backup-abc",
+		Task:     "backup",
+		Owner:    "admin",
+		Status:   JobFailed,
+		Reason:   "BackoffLimitExceeded",
+		Duration: 90 * time.Second,
+		Log:      "error: disk full",
+	}
+
+	subject, text, err := renderChannelMessage(models.NotificationChannel{Name: "ops"}, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Kriten job backup-abc failed" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	expected := "Kriten job backup-abc failed\nTask: backup\nOwner: admin\nDuration: 1m30s\n" +
+		"Reason: BackoffLimitExceeded\n```\nerror: disk full\n```"
+	if text != expected {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	channel := models.NotificationChannel{Name: "ops", Template: "{{.Job}} {{.Missing}}"}
+	if _, _, err := renderChannelMessage(channel, notification); err == nil {
+		t.Error("expected an error on a missing field")
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	if tail := logTail("1\n2\n3\n4\n", 2); tail != "3\n4" {
+		t.Errorf("unexpected tail %q", tail)
+	}
+	if tail := logTail("1\n2\n", 0); tail != "1\n2" {
+		t.Errorf("unexpected tail %q", tail)
+	}
+
+	tail := logTail(strings.Repeat("x", maxChannelLogSize*2), 1)
+	if len(tail) != maxChannelLogSize+3 || !strings.HasPrefix(tail, "...") {
+		t.Errorf("log not cut, %d bytes", len(tail))
+	}
+}
@@ -0,0 +1,686 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	NotificationPending   = "pending"
+	NotificationDelivered = "delivered"
+	NotificationFailed    = "failed"
+)
+
+const (
+	// Prefix of the CloudEvents types, subscriptions use the types without it, e.g. job.failed
+	eventTypePrefix = "io.kriten."
+	// Maximum number of notifications delivered by a replica on every check
+	notificationsBatch = 20
+	// Jobs are notified about when they started or completed within this window
+	jobEventsWindow = 10 * time.Minute
+	// Claimed notifications are retried after this delay when the replica sending them stops
+	notificationLease = 5 * time.Minute
+)
+
+// Shared address space of carrier-grade NATs, RFC 6598
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// Audit events turned into notifications, the type is the resource followed by the action,
+// e.g. a task update is task.updated. Reads aren't notified about.
+var (
+	auditResources = map[string]string{
+		"apiTokens":             "api_token",
+		"bundle":                "bundle",
+		"cronjobs":              "cronjob",
+		"encryption":            "encryption",
+		"event_rules":           "event_rule",
+		"groups":                "group",
+		"jobs":                  "job",
+		"maintenance_windows":   "maintenance_window",
+		"notification_channels": "notification_channel",
+		"roles":                 "role",
+		"runners":               "runner",
+		"scheduled_jobs":        "scheduled_job",
+		"subscriptions":         "subscription",
+		"tasks":                 "task",
+		"triggers":              "trigger",
+		"users":                 "user",
+		"webHooks":              "webhook",
+	}
+	auditActions = map[string]string{
+		"create":   "created",
+		"update":   "updated",
+		"delete":   "deleted",
+		"cancel":   "cancelled",
+		"rollback": "rolled_back",
+		"rotate":   "rotated",
+		"sync":     "synced",
+		"import":   "imported",
+		"export":   "exported",
+	}
+)
+
+type NotificationService interface {
+	ListSubscriptions([]string) ([]models.Subscription, error)
+	GetSubscription(string) (models.Subscription, error)
+	CreateSubscription(models.Subscription) (models.Subscription, error)
+	UpdateSubscription(models.Subscription) (models.Subscription, error)
+	DeleteSubscription(string) error
+	ListNotificationDeliveries(string) ([]models.NotificationDelivery, error)
+	Notify(string, string, string, interface{}, string, string)
+	NotifyAudit(models.AuditLog)
+	NotifyJobs() error
+	DeliverNotifications() error
+	ListChannels([]string) ([]models.NotificationChannel, error)
+	GetChannel(string) (models.NotificationChannel, error)
+	CreateChannel(models.NotificationChannel) (models.NotificationChannel, error)
+	UpdateChannel(models.NotificationChannel) (models.NotificationChannel, error)
+	DeleteChannel(string) error
+	ListChannelMessages(string) ([]models.ChannelMessage, error)
+	DeliverChannelMessages() error
+}
+
+type NotificationServiceImpl struct {
+	JobService   JobService
+	AuthService  AuthService
+	db           *gorm.DB
+	client       *http.Client
+	allowedHosts []string
+	config       config.Config
+}
+
+func NewNotificationService(database *gorm.DB, js JobService, as AuthService, config config.Config) NotificationService {
+	var allowedHosts []string
+	if config.NotificationAllowedHosts != "" {
+		allowedHosts = strings.Split(config.NotificationAllowedHosts, ",")
+	}
+
+	return &NotificationServiceImpl{
+		JobService:   js,
+		AuthService:  as,
+		db:           database,
+		client:       newNotificationClient(allowedHosts),
+		allowedHosts: allowedHosts,
+		config:       config,
+	}
+}
+
+func (n *NotificationServiceImpl) ListSubscriptions(authList []string) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	var res *gorm.DB
+
+	if len(authList) == 0 {
+		return subscriptions, nil
+	} else if slices.Contains(authList, "*") {
+		res = n.db.Order("name").Find(&subscriptions)
+	} else {
+		res = n.db.Where("name IN ?", authList).Order("name").Find(&subscriptions)
+	}
+
+	return subscriptions, res.Error
+}
+
+func (n *NotificationServiceImpl) GetSubscription(name string) (models.Subscription, error) {
+	var subscription models.Subscription
+	res := n.db.Where("name = ?", name).Find(&subscription)
+	if res.Error != nil {
+		return models.Subscription{}, res.Error
+	}
+
+	if subscription.Name == "" {
+		return models.Subscription{}, fmt.Errorf("subscription %s not found, please check name", name)
+	}
+
+	return subscription, nil
+}
+
+// CreateSubscription creates a subscription, a random secret is generated when it isn't set.
+func (n *NotificationServiceImpl) CreateSubscription(subscription models.Subscription) (models.Subscription, error) {
+	err := checkSubscription(subscription, n.allowedHosts)
+	if err != nil {
+		return subscription, err
+	}
+
+	if subscription.Secret == "" {
+		subscription.Secret, err = GenerateToken(40)
+		if err != nil {
+			return subscription, err
+		}
+	}
+
+	res := n.db.Create(&subscription)
+	return subscription, res.Error
+}
+
+// UpdateSubscription replaces a subscription, its owner is kept, and its secret when not set.
+func (n *NotificationServiceImpl) UpdateSubscription(subscription models.Subscription) (models.Subscription, error) {
+	err := checkSubscription(subscription, n.allowedHosts)
+	if err != nil {
+		return subscription, err
+	}
+
+	current, err := n.GetSubscription(subscription.Name)
+	if err != nil {
+		return subscription, err
+	}
+	subscription.ID = current.ID
+	subscription.Owner = current.Owner
+	subscription.CreatedAt = current.CreatedAt
+	if subscription.Secret == "" {
+		subscription.Secret = current.Secret
+	}
+
+	res := n.db.Save(&subscription)
+	if res.Error != nil {
+		return models.Subscription{}, res.Error
+	}
+
+	return n.GetSubscription(subscription.Name)
+}
+
+func (n *NotificationServiceImpl) DeleteSubscription(name string) error {
+	subscription, err := n.GetSubscription(name)
+	if err != nil {
+		return err
+	}
+
+	err = n.db.Where("subscription = ?", subscription.ID).Delete(&models.NotificationDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	return n.db.Unscoped().Delete(&subscription).Error
+}
+
+func (n *NotificationServiceImpl) ListNotificationDeliveries(name string) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+
+	subscription, err := n.GetSubscription(name)
+	if err != nil {
+		return deliveries, err
+	}
+
+	res := n.db.Where("subscription = ?", subscription.ID).Order("created_at DESC").Find(&deliveries)
+	return deliveries, res.Error
+}
+
+// Notify queues an event for the subscriptions to its type whose owner can read the resource it's about,
+// e.g. the jobs of a task. The ID identifies the event, the same event notified again, e.g. by another
+// replica, is only delivered once.
+func (n *NotificationServiceImpl) Notify(eventType string, id string, subject string, data interface{},
+	resource string, resourceID string) {
+	if n.config.NotificationInterval <= 0 {
+		return
+	}
+
+	subscriptions, err := n.enabledSubscriptions()
+	if err != nil {
+		log.Printf("Failed to notify %s: %v\n", eventType, err)
+		return
+	}
+
+	n.queue(subscriptions, eventType, id, subject, data, func(owner uuid.UUID) bool {
+		return n.ownerCanRead(owner, resource, resourceID)
+	})
+}
+
+func (n *NotificationServiceImpl) enabledSubscriptions() ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	res := n.db.Select("subscription_id", "events", "owner").Where("disable = ?", false).Find(&subscriptions)
+	return subscriptions, res.Error
+}
+
+// queue stores an event for delivery to the subscriptions to its type whose owner can read it.
+func (n *NotificationServiceImpl) queue(subscriptions []models.Subscription, eventType string, id string,
+	subject string, data interface{}, canRead func(owner uuid.UUID) bool) {
+	event := models.CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          "/kriten/" + n.config.Kube.Namespace,
+		Type:            eventTypePrefix + eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to notify %s: %v\n", eventType, err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscribed(subscription.Events, eventType) || !canRead(subscription.Owner) {
+			continue
+		}
+
+		res := n.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.NotificationDelivery{
+			Subscription:  subscription.ID,
+			EventID:       id,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        NotificationPending,
+			NextAttemptAt: time.Now(),
+		})
+		if res.Error != nil {
+			log.Printf("Failed to notify %s: %v\n", eventType, res.Error)
+		}
+	}
+}
+
+// NotifyAudit notifies about an audit event, e.g. task.updated. Failed logins are the only failures notified about.
+// Audit events are only delivered to subscriptions whose owner can read the audit log.
+func (n *NotificationServiceImpl) NotifyAudit(audit models.AuditLog) {
+	var eventType string
+
+	switch {
+	case audit.EventCategory == "authentication" && audit.EventType == "login":
+		eventType = "login.succeeded"
+		if audit.Status != "success" {
+			eventType = "login.failed"
+		}
+	case audit.Status != "success":
+		return
+	default:
+		resource, ok := auditResources[audit.EventCategory]
+		if !ok || audit.EventType == "list" || audit.EventType == "get" {
+			return
+		}
+		action, ok := auditActions[audit.EventType]
+		if !ok {
+			action = audit.EventType
+		}
+		eventType = resource + "." + action
+	}
+
+	n.Notify(eventType, uuid.NewV4().String(), audit.EventTarget, map[string]interface{}{
+		"user":     audit.UserName,
+		"provider": audit.Provider,
+		"target":   audit.EventTarget,
+		"action":   audit.EventType,
+		"status":   audit.Status,
+	}, "audit", "*")
+}
+
+// NotifyJobs notifies subscriptions and channels about jobs that recently started, succeeded or failed.
+// Job events have stable IDs, so they're only delivered once whatever the number of replicas.
+// Subscriptions, channels and the roles of their owners are loaded once, every event is checked in memory.
+func (n *NotificationServiceImpl) NotifyJobs() error {
+	jobs, err := helpers.ListJobs(n.config.Kube, []string{"task-name"})
+	if err != nil {
+		return err
+	}
+
+	var channels []models.NotificationChannel
+	res := n.db.Where("disable = ?", false).Find(&channels)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var subscriptions []models.Subscription
+	if n.config.NotificationInterval > 0 {
+		subscriptions, err = n.enabledSubscriptions()
+		if err != nil {
+			return err
+		}
+	}
+
+	var owners []uuid.UUID
+	for _, subscription := range subscriptions {
+		owners = append(owners, subscription.Owner)
+	}
+	for _, channel := range channels {
+		owners = append(owners, channel.Owner)
+	}
+	roles, err := n.loadOwnerRoles(owners)
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().Add(-jobEventsWindow)
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+
+		if job.Status.StartTime != nil && job.Status.StartTime.After(since) {
+			n.notifyJob(subscriptions, channels, roles, job, JobStarted, "", nil)
+		}
+
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue || !condition.LastTransitionTime.After(since) {
+				continue
+			}
+			end := condition.LastTransitionTime.Time
+			switch condition.Type {
+			case batchv1.JobComplete:
+				n.notifyJob(subscriptions, channels, roles, job, JobSucceeded, "", &end)
+			case batchv1.JobFailed:
+				n.notifyJob(subscriptions, channels, roles, job, JobFailed, condition.Message, &end)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n *NotificationServiceImpl) notifyJob(subscriptions []models.Subscription, channels []models.NotificationChannel,
+	roles ownerRoles, job *batchv1.Job, status string, reason string, end *time.Time) {
+	task := job.Labels["task-name"]
+	n.queue(subscriptions, "job."+status, job.Name+"/"+status, job.Name, jobEventData(job, reason),
+		func(owner uuid.UUID) bool { return roles.canRead(owner, "jobs", task) })
+	n.notifyChannels(channels, roles, job, status, reason, end)
+}
+
+// DeliverNotifications posts the notifications due to the subscriptions, failed ones are retried with an
+// exponential backoff. Notifications are claimed with SKIP LOCKED so concurrent replicas don't deliver them twice,
+// and posted once the claim is committed, an unreachable endpoint doesn't hold the rows locked.
+func (n *NotificationServiceImpl) DeliverNotifications() error {
+	var deliveries []models.NotificationDelivery
+
+	err := n.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", NotificationPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(notificationsBatch).
+			Find(&deliveries)
+		if res.Error != nil || len(deliveries) == 0 {
+			return res.Error
+		}
+
+		ids := make([]uuid.UUID, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&models.NotificationDelivery{}).
+			Where("notification_id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(notificationLease)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		var subscription models.Subscription
+		res := n.db.Where("subscription_id = ?", delivery.Subscription).Find(&subscription)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		err := n.post(subscription, delivery.Payload)
+		delivery.Attempts++
+		switch {
+		case err == nil:
+			delivery.Status = NotificationDelivered
+			delivery.Error = ""
+		case delivery.Attempts >= n.config.NotificationMaxAttempts || subscription.Name == "":
+			delivery.Status = NotificationFailed
+			delivery.Error = err.Error()
+		default:
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = time.Now().Add(notificationBackoff(delivery.Attempts))
+		}
+
+		err = n.db.Model(delivery).
+			Select("status", "attempts", "next_attempt_at", "error").
+			Updates(delivery).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NotificationServiceImpl) post(subscription models.Subscription, payload string) error {
+	if subscription.Name == "" {
+		return errors.New("subscription not found")
+	}
+	if subscription.Disable {
+		return errors.New("subscription disabled")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	if subscription.Secret != "" {
+		signature := computeHMAC(sha256.New, subscription.Secret, []byte(payload))
+		req.Header.Set("X-Kriten-Signature", "sha256="+hex.EncodeToString(signature))
+	}
+
+	return n.do(req)
+}
+
+// do sends a request, responses other than 2xx are an error. Response bodies are discarded,
+// they aren't kept in deliveries as endpoints may not be what they claim.
+func (n *NotificationServiceImpl) do(req *http.Request) error {
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// ownerCanRead tells whether the owner of a subscription or channel can read a resource. Roles can change
+// after they're created, so the owner is checked on every event.
+func (n *NotificationServiceImpl) ownerCanRead(owner uuid.UUID, resource string, resourceID string) bool {
+	var user models.User
+	res := n.db.Where("user_id = ?", owner).Find(&user)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false
+	}
+
+	authorised, err := n.AuthService.IsAutorised(&models.Authorization{
+		UserID:     user.ID,
+		Provider:   user.Provider,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Access:     "read",
+	})
+	return err == nil && authorised
+}
+
+// ownerRoles holds the roles of subscription and channel owners, owners not found have none.
+type ownerRoles map[uuid.UUID][]models.Role
+
+// loadOwnerRoles reads the roles of the owners with a query for the users and one for the roles bound to their groups.
+func (n *NotificationServiceImpl) loadOwnerRoles(owners []uuid.UUID) (ownerRoles, error) {
+	roles := ownerRoles{}
+	if len(owners) == 0 {
+		return roles, nil
+	}
+
+	var users []models.User
+	res := n.db.Where("user_id IN ?", owners).Find(&users)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	var groups []string
+	for _, user := range users {
+		groups = append(groups, user.Groups...)
+	}
+	if len(groups) == 0 {
+		return roles, nil
+	}
+
+	var bound []struct {
+		models.Role
+		SubjectID       string
+		SubjectProvider string
+	}
+	res = n.db.Model(&models.Role{}).
+		Select("roles.*, role_bindings.subject_id, role_bindings.subject_provider").
+		Joins("join role_bindings on roles.role_id = role_bindings.role_id").
+		Where("role_bindings.subject_id IN ?", groups).
+		Scan(&bound)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	// Same roles as UserService.GetUserRoles: the ones bound to the user groups of the user provider
+	for _, user := range users {
+		for _, binding := range bound {
+			if binding.SubjectProvider == user.Provider && slices.Contains(user.Groups, binding.SubjectID) {
+				roles[user.ID] = append(roles[user.ID], binding.Role)
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+func (r ownerRoles) canRead(owner uuid.UUID, resource string, resourceID string) bool {
+	return rolesGrant(r[owner], resource, resourceID, "read")
+}
+
+func checkSubscription(subscription models.Subscription, allowedHosts []string) error {
+	endpoint, err := url.Parse(subscription.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid url %s, an http or https url is expected", subscription.URL)
+	}
+	if err := checkDestination(endpoint, allowedHosts); err != nil {
+		return err
+	}
+	if len(subscription.Events) == 0 {
+		return errors.New("a subscription needs at least one event type, e.g. job.failed or *")
+	}
+
+	return nil
+}
+
+// newNotificationClient returns a client posting to the allowed hosts, or to any host with a public address
+// when none are. Addresses are checked when connecting, so a host can't resolve to an internal address
+// once its URL is checked, and redirects are checked too.
+func newNotificationClient(allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	publicDialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("address %s isn't public, please add the host to NOTIFICATION_ALLOWED_HOSTS", host)
+			}
+			return nil
+		},
+	}
+
+	// Requests aren't sent through HTTP(S)_PROXY, the dialer would check the proxy instead of the endpoint
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if len(allowedHosts) == 0 {
+			return publicDialer.DialContext(ctx, network, address)
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if !hostAllowed(allowedHosts, host) {
+			return nil, fmt.Errorf("host %s isn't in NOTIFICATION_ALLOWED_HOSTS", host)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// checkDestination rejects the URLs notifications can't be posted to, hosts with a non public
+// address are only rejected here when they're an IP, others are rejected when connecting.
+func checkDestination(endpoint *url.URL, allowedHosts []string) error {
+	host := endpoint.Hostname()
+	if len(allowedHosts) > 0 {
+		if !hostAllowed(allowedHosts, host) {
+			return fmt.Errorf("host %s isn't in NOTIFICATION_ALLOWED_HOSTS", host)
+		}
+		return nil
+	}
+
+	if ip := net.ParseIP(host); (ip != nil && !publicAddress(ip)) || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("host %s isn't public, please add it to NOTIFICATION_ALLOWED_HOSTS", host)
+	}
+	return nil
+}
+
+// hostAllowed matches a host against the allowed ones, *.example.com matches the subdomains of example.com.
+func hostAllowed(allowedHosts []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return slices.ContainsFunc(allowedHosts, func(allowed string) bool {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if domain, found := strings.CutPrefix(allowed, "*."); found {
+			return strings.HasSuffix(host, "."+domain)
+		}
+		return host == allowed
+	})
+}
+
+// publicAddress tells whether an address is reachable from the internet, loopback, private, link-local,
+// e.g. cloud metadata services, and shared addresses aren't.
+func publicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddresses.Contains(ip)
+}
+
+func subscribed(events []string, eventType string) bool {
+	return slices.ContainsFunc(events, func(event string) bool {
+		if prefix, found := strings.CutSuffix(event, "*"); found {
+			return strings.HasPrefix(eventType, prefix)
+		}
+		return event == eventType
+	})
+}
+
+// notificationBackoff doubles the delay after every attempt, from 30 seconds up to an hour.
+func notificationBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+func jobEventData(job *batchv1.Job, reason string) map[string]interface{} {
+	data := map[string]interface{}{
+		"job":   jobModel(job),
+		"task":  job.Labels["task-name"],
+		"owner": job.Labels["owner"],
+	}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	return data
+}
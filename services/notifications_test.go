@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNotificationClientRejectsLoopback(t *testing.T) {
+	var received bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	n := &NotificationServiceImpl{client: newNotificationClient(nil)}
+	subscription := models.Subscription{Name: "internal", URL: server.URL}
+	if err := n.post(subscription, `{}`); err == nil {
+		t.Error("expected posting to a loopback endpoint to be rejected")
+	}
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	localhost := models.Subscription{Name: "localhost", URL: "http://localhost:" + port}
+	if err := n.post(localhost, `{}`); err == nil {
+		t.Error("expected posting to localhost to be rejected")
+	}
+	if received {
+		t.Error("loopback endpoint received a notification")
+	}
+}
+
+// TestNotificationClientIgnoresProxy checks the endpoint is dialed, a proxy would be checked instead of it.
+func TestNotificationClientIgnoresProxy(t *testing.T) {
+	var proxied bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+
+	client := newNotificationClient([]string{"127.0.0.1"})
+	if client.Transport.(*http.Transport).Proxy != nil {
+		t.Error("notification client uses a proxy")
+	}
+
+	n := &NotificationServiceImpl{client: client}
+	if err := n.post(models.Subscription{Name: "metadata", URL: "http://metadata.internal/"}, `{}`); err == nil {
+		t.Error("expected a host outside NOTIFICATION_ALLOWED_HOSTS to be rejected")
+	}
+	if proxied {
+		t.Error("notification sent through the proxy")
+	}
+}
+
+func TestNotificationClientAllowedHosts(t *testing.T) {
+	var received bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	n := &NotificationServiceImpl{client: newNotificationClient([]string{"127.0.0.1"})}
+	if err := n.post(models.Subscription{Name: "internal", URL: server.URL}, `{}`); err != nil {
+		t.Fatal(err)
+	}
+	if !received {
+		t.Error("allowed endpoint didn't receive the notification")
+	}
+
+	n = &NotificationServiceImpl{client: newNotificationClient([]string{"hooks.example.com"})}
+	if err := n.post(models.Subscription{Name: "internal", URL: server.URL}, `{}`); err == nil {
+		t.Error("expected a host outside NOTIFICATION_ALLOWED_HOSTS to be rejected")
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowedHosts []string
+		valid        bool
+	}{
+		{"https://hooks.example.com/kriten", nil, true},
+		{"http://127.0.0.1:8080", nil, false},
+		{"http://localhost:8080", nil, false},
+		{"http://169.254.169.254/latest/meta-data", nil, false},
+		{"http://10.0.0.1", nil, false},
+		{"http://[::1]", nil, false},
+		{"http://10.0.0.1", []string{"10.0.0.1"}, true},
+		{"https://api.hooks.example.com", []string{"*.example.com"}, true},
+		{"https://example.com", []string{"*.example.com"}, false},
+		{"https://hooks.example.org", []string{"hooks.example.com"}, false},
+	}
+
+	for _, test := range tests {
+		endpoint, _ := url.Parse(test.url)
+		err := checkDestination(endpoint, test.allowedHosts)
+		if (err == nil) != test.valid {
+			t.Errorf("%s %v: expected valid %v, got %v", test.url, test.allowedHosts, test.valid, err)
+		}
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"203.0.113.10":    true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+	}
+
+	for address, public := range tests {
+		if publicAddress(net.ParseIP(address)) != public {
+			t.Errorf("%s: expected public %v", address, public)
+		}
+	}
+}
+
+// testOwners stores users whose groups are bound to roles on the jobs of a task.
+func testOwners(t *testing.T, db *gorm.DB) map[string]models.User {
+	t.Helper()
+
+	users := map[string]models.User{}
+	for _, owner := range []struct{ name, provider, task string }{
+		{"alice", "local", "backup"},
+		{"bob", "local", "report"},
+		// Bound to a group of another provider
+		{"carol", "active_directory", ""},
+	} {
+		group := models.Group{ID: uuid.NewV4(), Name: owner.name, Provider: "local"}
+		db.Create(&group)
+		user := models.User{ID: uuid.NewV4(), Username: owner.name, Provider: owner.provider,
+			Groups: pq.StringArray{group.ID.String()}}
+		db.Create(&user)
+		users[owner.name] = user
+
+		task := owner.task
+		if task == "" {
+			task = "backup"
+		}
+		role := models.Role{ID: uuid.NewV4(), Name: owner.name + "-jobs", Resource: "jobs",
+			Resource_IDs: pq.StringArray{task}, Access: "read"}
+		db.Create(&role)
+		db.Create(&models.RoleBinding{ID: uuid.NewV4(), Name: owner.name + "-jobs", RoleID: role.ID, RoleName: role.Name,
+			SubjectKind: "groups", SubjectID: group.ID, SubjectName: group.Name, SubjectProvider: "local"})
+	}
+
+	return users
+}
+
+func TestLoadOwnerRoles(t *testing.T) {
+	db := testDB(t, &models.User{}, &models.Group{}, &models.Role{}, &models.RoleBinding{})
+	users := testOwners(t, db)
+	n := &NotificationServiceImpl{db: db}
+	us := NewUserService(db, config.Config{})
+
+	owners := []uuid.UUID{uuid.NewV4()}
+	for _, user := range users {
+		owners = append(owners, user.ID)
+	}
+	roles, err := n.loadOwnerRoles(owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The roles loaded at once grant the same as the ones of every user
+	for name, user := range users {
+		userRoles, err := us.GetUserRoles(user.ID.String(), user.Provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range []string{"backup", "report"} {
+			if roles.canRead(user.ID, "jobs", task) != rolesGrant(userRoles, "jobs", task, "read") {
+				t.Errorf("%s: roles on %s differ from GetUserRoles", name, task)
+			}
+		}
+	}
+	if !roles.canRead(users["alice"].ID, "jobs", "backup") || roles.canRead(users["alice"].ID, "jobs", "report") {
+		t.Error("unexpected roles of alice")
+	}
+	if roles.canRead(users["carol"].ID, "jobs", "backup") || roles.canRead(owners[0], "jobs", "backup") {
+		t.Error("roles granted to owners without bindings")
+	}
+}
+
+func TestNotifyJobs(t *testing.T) {
+	db := testDB(t, &models.User{}, &models.Group{}, &models.Role{}, &models.RoleBinding{},
+		&models.Subscription{}, &models.NotificationDelivery{}, &models.NotificationChannel{})
+	users := testOwners(t, db)
+	for name, user := range users {
+		db.Create(&models.Subscription{ID: uuid.NewV4(), Name: name, URL: "https://hooks.example.com/" + name,
+			Owner: user.ID, Events: pq.StringArray{"job.*"}})
+	}
+
+	client := fake.NewClientset()
+	finished := metav1.NewTime(time.Now().Add(-time.Minute))
+	for i, task := range []string{"backup", "backup", "report", "cleanup"} {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: task + "-" + string(rune('a'+i)), Labels: map[string]string{"task-name": task}},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: finished},
+			}},
+		}
+		if _, err := client.BatchV1().Jobs("kriten").Create(context.TODO(), job, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n := &NotificationServiceImpl{db: db, config: config.Config{
+		Kube:                 config.KubeConfig{Clientset: client, Namespace: "kriten"},
+		NotificationInterval: 10,
+	}}
+
+	// Reads don't grow with the number of jobs and subscriptions
+	queries := 0
+	db.Callback().Query().Before("gorm:query").Register("count_queries", func(*gorm.DB) { queries++ })
+	db.Callback().Row().Before("gorm:row").Register("count_rows", func(*gorm.DB) { queries++ })
+
+	if err := n.NotifyJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if queries > 4 {
+		t.Errorf("expected channels, subscriptions, users and roles to be read once, got %d queries", queries)
+	}
+
+	var deliveries []models.NotificationDelivery
+	db.Order("event_id").Find(&deliveries)
+	expected := map[uuid.UUID][]string{}
+	for _, delivery := range deliveries {
+		expected[delivery.Subscription] = append(expected[delivery.Subscription], delivery.EventID)
+	}
+
+	var subscriptions []models.Subscription
+	db.Find(&subscriptions)
+	for _, subscription := range subscriptions {
+		events := expected[subscription.ID]
+		switch subscription.Name {
+		case "alice":
+			if len(events) != 2 || events[0] != "backup-a/succeeded" || events[1] != "backup-b/succeeded" {
+				t.Errorf("alice: unexpected events %v", events)
+			}
+		case "bob":
+			if len(events) != 1 || events[0] != "report-c/succeeded" {
+				t.Errorf("bob: unexpected events %v", events)
+			}
+		default:
+			if len(events) != 0 {
+				t.Errorf("%s: unexpected events %v", subscription.Name, events)
+			}
+		}
+	}
+}
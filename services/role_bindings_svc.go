@@ -99,7 +99,8 @@ func (r *RoleBindingServiceImpl) UpdateRoleBinding(roleBinding models.RoleBindin
 		return models.RoleBinding{}, res.Error
 	}
 
-	newRoleBinding, err := r.GetRoleBinding(roleBinding.ID.String())
+	// role bindings are looked up by name, IDs aren't accepted
+	newRoleBinding, err := r.GetRoleBinding(roleBinding.Name)
 	if err != nil {
 		return models.RoleBinding{}, err
 	}
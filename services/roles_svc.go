@@ -90,7 +90,8 @@ func (r *RoleServiceImpl) UpdateRole(role models.Role) (models.Role, error) {
 		return models.Role{}, res.Error
 	}
 
-	newRole, err := r.GetRole(role.ID.String())
+	// roles are looked up by name, IDs aren't accepted
+	newRole, err := r.GetRole(role.Name)
 	if err != nil {
 		return models.Role{}, err
 	}
@@ -147,25 +148,29 @@ func (r *RoleServiceImpl) CheckRole(role models.Role) error {
 				return err
 			}
 		}
-	} else {
+	} else if role.Resource == "runners" || role.Resource == "tasks" || role.Resource == "jobs" {
+		// runners and tasks are Kriten custom resources, jobs roles are scoped by task name.
+		kind := helpers.KindTask
+		if role.Resource == "runners" {
+			kind = helpers.KindRunner
+		}
 		for _, c := range role.Resource_IDs {
-			configMap, err := helpers.GetConfigMap(r.config.Kube, c)
+			if c == "*" {
+				continue
+			}
+			_, err := helpers.GetResource(r.config.Kube, kind, c)
 			if err != nil {
-				return err
+				return fmt.Errorf("%s %s not found", kind, c)
 			}
-			// configmaps are all stored in the same namespace, so we need to identify the resource
-			if role.Resource == "runners" {
-				if configMap.Data["image"] == "" {
-					return fmt.Errorf("runner %s not found", c)
-				}
-			} else if role.Resource == "tasks" {
-				if configMap.Data["runner"] == "" {
-					return fmt.Errorf("task %s not found", c)
-				}
-			} else if role.Resource == "jobs" {
-				if configMap.Data["runner"] == "" {
-					return fmt.Errorf("job %s not found", c)
-				}
+		}
+	} else if role.Resource == "kubernetes" {
+		// kubernetes roles are scoped by resource, e.g. v1/nodes, granting triggers on it
+		for _, resource := range role.Resource_IDs {
+			if resource == "*" {
+				continue
+			}
+			if _, err := helpers.ParseGVR(resource); err != nil {
+				return err
 			}
 		}
 	}
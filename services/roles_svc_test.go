@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Roles and role bindings are read by name, updates return the stored resource.
+func TestUpdateRoleAndRoleBinding(t *testing.T) {
+	db := testDB(t, &models.Role{}, &models.RoleBinding{}, &models.Group{})
+	conf := config.Config{}
+	var rbs RoleBindingService
+	rs := NewRoleService(db, conf, &rbs, nil)
+	gs := NewGroupService(db, nil, conf)
+	rbs = NewRoleBindingService(db, conf, rs, gs)
+
+	for _, group := range []string{"ops", "netops"} {
+		db.Create(&models.Group{ID: uuid.NewV4(), Name: group, Provider: "local"})
+	}
+	role := models.Role{ID: uuid.NewV4(), Name: "windows", Resource: "maintenance_windows",
+		Resource_IDs: pq.StringArray{"*"}, Access: "read"}
+	if _, err := rs.CreateRole(role); err != nil {
+		t.Fatal(err)
+	}
+
+	role.Access = "write"
+	updated, err := rs.UpdateRole(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != role.ID || updated.Access != "write" {
+		t.Errorf("unexpected role %+v", updated)
+	}
+
+	roleBinding := models.RoleBinding{ID: uuid.NewV4(), Name: "ops-windows", RoleName: "windows",
+		SubjectKind: "groups", SubjectName: "ops", SubjectProvider: "local"}
+	if _, err := rbs.CreateRoleBinding(roleBinding); err != nil {
+		t.Fatal(err)
+	}
+
+	roleBinding.SubjectName = "netops"
+	updatedBinding, err := rbs.UpdateRoleBinding(roleBinding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netops, _ := gs.GetGroup("netops")
+	if updatedBinding.ID != roleBinding.ID || updatedBinding.SubjectName != "netops" || updatedBinding.SubjectID != netops.ID {
+		t.Errorf("unexpected role binding %+v", updatedBinding)
+	}
+}
@@ -1,8 +1,9 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/kriten-io/kriten/config"
@@ -10,11 +11,18 @@ import (
 	"github.com/kriten-io/kriten/models"
 
 	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ManifestFile    = "kriten.yaml"
+	ManifestVersion = "v1"
 )
 
 type RunnerService interface {
-	ListRunners([]string) ([]map[string]string, error)
+	ListRunners([]string) ([]models.Runner, error)
 	GetRunner(string) (*models.Runner, error)
 	CreateRunner(models.Runner) (*models.Runner, error)
 	UpdateRunner(models.Runner) (*models.Runner, error)
@@ -24,67 +32,83 @@ type RunnerService interface {
 	GetSecret(string) (map[string]string, error)
 	UpdateSecret(string, map[string]string) (map[string]string, error)
 	DeleteSecret(string) error
+	SyncRunner(string, bool) (models.RunnerSync, error)
+	SyncRunners()
 }
 
 type RunnerServiceImpl struct {
-	config config.Config
+	db          *gorm.DB
+	TaskService TaskService
+	config      config.Config
 }
 
-func NewRunnerService(config config.Config) RunnerService {
+func NewRunnerService(database *gorm.DB, ts TaskService, config config.Config) RunnerService {
 	return &RunnerServiceImpl{
-		config: config,
+		db:          database,
+		TaskService: ts,
+		config:      config,
 	}
 }
 
-func (r *RunnerServiceImpl) ListRunners(authList []string) ([]map[string]string, error) {
-	var runnersList []map[string]string
+func (r *RunnerServiceImpl) ListRunners(authList []string) ([]models.Runner, error) {
+	var runnersList []models.Runner
 
 	if len(authList) == 0 {
 		return runnersList, nil
 	}
 
-	configMaps, err := helpers.ListConfigMaps(r.config.Kube)
+	runners, err := helpers.ListResources(r.config.Kube, helpers.KindRunner, "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to validate Kubernetes ConfigMap name: %w", err)
-	}
-
-	for _, configMap := range configMaps.Items {
-		// TODO: we don't currently have a way to identify what is a Runner configmap so I'm checking if it has an Image field
-		// This will be changed when runners will live in a separate namespace
-		if configMap.Data["image"] != "" {
-			if authList[0] != "*" {
-				if slices.Contains(authList, configMap.Data["name"]) {
-					runnersList = append(runnersList, configMap.Data)
-				}
-				continue
-			}
-			runnersList = append(runnersList, configMap.Data)
+		return nil, fmt.Errorf("failed to list runners: %w", err)
+	}
+
+	for i := range runners {
+		if authList[0] != "*" && !slices.Contains(authList, runners[i].GetName()) {
+			continue
 		}
+		var runner models.Runner
+		err = helpers.ResourceToModel(&runners[i], &runner)
+		if err != nil {
+			return nil, err
+		}
+		runnersList = append(runnersList, runner)
 	}
 
 	return runnersList, nil
 }
 
-func (r *RunnerServiceImpl) GetRunner(name string) (*models.Runner, error) {
-	var runnerData models.Runner
-	configMap, err := helpers.GetConfigMap(r.config.Kube, name)
+// getRunnerSpec returns the runner as stored in the cluster, without secrets.
+func getRunnerSpec(kube config.KubeConfig, name string) (*models.Runner, error) {
+	var runner models.Runner
 
+	obj, err := helpers.GetResource(kube, helpers.KindRunner, name)
 	if err != nil {
-		return &runnerData, err
+		return nil, err
 	}
 
-	if configMap.Data["image"] == "" {
-		return nil, fmt.Errorf("runner %s not found", name)
+	err = helpers.ResourceToModel(obj, &runner)
+	if err != nil {
+		return nil, err
+	}
+
+	if runner.Branch == "" {
+		runner.Branch = "main"
 	}
 
-	b, _ := json.Marshal(configMap.Data)
-	_ = json.Unmarshal(b, &runnerData)
+	return &runner, nil
+}
+
+func (r *RunnerServiceImpl) GetRunner(name string) (*models.Runner, error) {
+	runnerData, err := getRunnerSpec(r.config.Kube, name)
+	if err != nil {
+		return &models.Runner{}, err
+	}
 
 	tokenObjName := name + "-token"
 	token, err := r.GetSecret(tokenObjName)
 	if err != nil {
 		if !errors.IsNotFound(err) {
-			return &runnerData, err
+			return runnerData, err
 		}
 	} else {
 		runnerData.Token = token["token"]
@@ -93,13 +117,13 @@ func (r *RunnerServiceImpl) GetRunner(name string) (*models.Runner, error) {
 	secretCleared, err := r.GetSecret(name)
 	if err != nil {
 		if !errors.IsNotFound(err) {
-			return &runnerData, err
+			return runnerData, err
 		}
 	} else {
 		runnerData.Secret = secretCleared
 	}
 
-	return &runnerData, nil
+	return runnerData, nil
 }
 
 func (r *RunnerServiceImpl) CreateRunner(runner models.Runner) (*models.Runner, error) {
@@ -108,17 +132,21 @@ func (r *RunnerServiceImpl) CreateRunner(runner models.Runner) (*models.Runner,
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	b, _ := json.Marshal(runner)
-	var data map[string]string
-	_ = json.Unmarshal(b, &data)
-	delete(data, "token")
-	delete(data, "secret")
+	if runner.Branch == "" {
+		runner.Branch = "main"
+	}
+
+	err = r.validateSecretProvider(&runner)
+	if err != nil {
+		return nil, err
+	}
 
-	if data["branch"] == "" {
-		data["branch"] = "main"
+	spec, err := helpers.ResourceSpec(runner, "token", "secret")
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = helpers.CreateOrUpdateConfigMap(r.config.Kube, data, "create")
+	obj, err := helpers.CreateOrUpdateResource(r.config.Kube, helpers.KindRunner, runner.Name, spec, nil, "create")
 	if err != nil {
 		return nil, err
 	}
@@ -144,23 +172,29 @@ func (r *RunnerServiceImpl) CreateRunner(runner models.Runner) (*models.Runner,
 		}
 	}
 
+	_ = helpers.UpdateResourceStatus(r.config.Kube, helpers.KindRunner, obj, "Ready", "")
+
 	runnerData, err := r.GetRunner(runner.Name)
 	return runnerData, err
 }
 
 func (r *RunnerServiceImpl) UpdateRunner(runner models.Runner) (*models.Runner, error) {
-	_, err := helpers.GetConfigMap(r.config.Kube, runner.Name)
+	_, err := helpers.GetResource(r.config.Kube, helpers.KindRunner, runner.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	b, _ := json.Marshal(runner)
-	var data map[string]string
-	_ = json.Unmarshal(b, &data)
-	delete(data, "token")
-	delete(data, "secret")
+	err = r.validateSecretProvider(&runner)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = helpers.CreateOrUpdateConfigMap(r.config.Kube, data, "update")
+	spec, err := helpers.ResourceSpec(runner, "token", "secret")
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := helpers.CreateOrUpdateResource(r.config.Kube, helpers.KindRunner, runner.Name, spec, nil, "update")
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +233,8 @@ func (r *RunnerServiceImpl) UpdateRunner(runner models.Runner) (*models.Runner,
 		}
 	}
 
+	_ = helpers.UpdateResourceStatus(r.config.Kube, helpers.KindRunner, obj, "Ready", "")
+
 	updatedRunner, err := r.GetRunner(runner.Name)
 	if err != nil {
 		return nil, err
@@ -207,19 +243,21 @@ func (r *RunnerServiceImpl) UpdateRunner(runner models.Runner) (*models.Runner,
 }
 
 func (r *RunnerServiceImpl) DeleteRunner(name string) error {
-	configMaps, err := helpers.ListConfigMaps(r.config.Kube)
+	_, err := helpers.GetResource(r.config.Kube, helpers.KindRunner, name)
 	if err != nil {
 		return err
 	}
 
 	// Cheching for tasks associated to the runner before deleting it.
-	for _, configMap := range configMaps.Items {
-		runnerName := configMap.Data["runner"]
-		if runnerName == name {
-			return fmt.Errorf("runner is bound with task: %s , please delete that first", configMap.Data["name"])
-		}
+	tasks, err := helpers.ListResources(r.config.Kube, helpers.KindTask, helpers.LabelRunner+"="+name)
+	if err != nil {
+		return err
+	}
+	if len(tasks) != 0 {
+		return fmt.Errorf("runner is bound with task: %s , please delete that first", tasks[0].GetName())
 	}
-	err = helpers.DeleteConfigMap(r.config.Kube, name)
+
+	err = helpers.DeleteResource(r.config.Kube, helpers.KindRunner, name)
 
 	if err != nil {
 		return err
@@ -342,3 +380,99 @@ func (r *RunnerServiceImpl) DeleteSecret(name string) error {
 
 	return nil
 }
+
+// SyncRunner reads kriten.yaml from the runner repository and syncs the tasks it declares.
+func (r *RunnerServiceImpl) SyncRunner(name string, dryRun bool) (models.RunnerSync, error) {
+	var manifest models.RunnerManifest
+	result := models.RunnerSync{Runner: name, DryRun: dryRun}
+
+	runner, err := getRunnerSpec(r.config.Kube, name)
+	if err != nil {
+		return result, err
+	}
+	result.Branch = runner.Branch
+
+	gitURL := runner.GitURL
+	token, err := helpers.GetSecret(r.config.Kube, runner.Name+"-token")
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return result, err
+		}
+	} else if gitToken := string(token.Data["token"]); gitToken != "" {
+		gitURL = strings.Replace(gitURL, "://", "://"+gitToken+":@", 1)
+	}
+
+	content, commit, err := helpers.ReadGitFile(gitURL, runner.Branch, ManifestFile)
+	result.Commit = commit
+	if err != nil {
+		return result, err
+	}
+
+	err = yaml.Unmarshal(content, &manifest)
+	if err != nil {
+		return result, fmt.Errorf("invalid %s: %w", ManifestFile, err)
+	}
+	if manifest.Version != "" && manifest.Version != ManifestVersion {
+		return result, fmt.Errorf("unsupported %s version %q, expected %q", ManifestFile, manifest.Version, ManifestVersion)
+	}
+
+	result.Changes, err = r.TaskService.SyncManifest(runner.Name, manifest, dryRun)
+	return result, err
+}
+
+// SyncRunners syncs every runner with autoSync enabled, errors are only logged.
+// Every replica calls it, the sync runs under a lock and replicas skip it while another one holds it.
+func (r *RunnerServiceImpl) SyncRunners() {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext(?))", "runner_sync").Scan(&locked).Error
+		if err != nil || !locked {
+			return err
+		}
+
+		r.syncRunners()
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to lock runners sync: %v\n", err)
+	}
+}
+
+func (r *RunnerServiceImpl) syncRunners() {
+	runners, err := r.ListRunners([]string{"*"})
+	if err != nil {
+		log.Printf("failed to list runners to sync: %v\n", err)
+		return
+	}
+
+	for _, runner := range runners {
+		if !runner.AutoSync {
+			continue
+		}
+		result, err := r.SyncRunner(runner.Name, false)
+		if err != nil {
+			log.Printf("failed to sync runner %s: %v\n", runner.Name, err)
+			continue
+		}
+		for _, change := range result.Changes {
+			if change.Error != "" {
+				log.Printf("runner %s sync: failed to %s task %s: %s\n", runner.Name, change.Action, change.Task, change.Error)
+			} else if change.Action != "unchanged" {
+				log.Printf("runner %s sync: %s task %s\n", runner.Name, change.Action, change.Task)
+			}
+		}
+	}
+}
+
+func (r *RunnerServiceImpl) validateSecretProvider(runner *models.Runner) error {
+	provider, err := NewSecretProvider(r.config, runner.SecretProvider)
+	if err != nil {
+		return err
+	}
+
+	if runner.SecretProvider == SecretProviderVault && runner.VaultEngine == "" {
+		runner.VaultEngine = "kv"
+	}
+
+	return provider.Validate(runner)
+}
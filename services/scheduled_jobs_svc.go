@@ -0,0 +1,243 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ScheduledJobPending   = "pending"
+	ScheduledJobLaunching = "launching"
+	ScheduledJobLaunched  = "launched"
+	ScheduledJobFailed    = "failed"
+	ScheduledJobCancelled = "cancelled"
+)
+
+const (
+	// Maximum number of scheduled jobs launched by a replica on every check
+	scheduledJobsBatch = 20
+	// Jobs claimed for longer were being launched by a replica that stopped
+	scheduledJobsClaimTimeout = 5 * time.Minute
+)
+
+type ScheduledJobService interface {
+	ListScheduledJobs([]string, string) ([]models.ScheduledJob, error)
+	GetScheduledJob(string) (models.ScheduledJob, error)
+	ScheduleJob(uuid.UUID, string, string, string, time.Time) (models.ScheduledJob, error)
+	RescheduleJob(string, time.Time) (models.ScheduledJob, error)
+	CancelScheduledJob(string) (models.ScheduledJob, error)
+	LaunchScheduledJobs() error
+}
+
+type ScheduledJobServiceImpl struct {
+	db          *gorm.DB
+	JobService  JobService
+	AuthService AuthService
+	config      config.Config
+}
+
+func NewScheduledJobService(database *gorm.DB, js JobService, as AuthService, config config.Config) ScheduledJobService {
+	return &ScheduledJobServiceImpl{
+		db:          database,
+		JobService:  js,
+		AuthService: as,
+		config:      config,
+	}
+}
+
+// ListScheduledJobs lists scheduled jobs of the tasks in authList, optionally filtered by status.
+func (s *ScheduledJobServiceImpl) ListScheduledJobs(authList []string, status string) ([]models.ScheduledJob, error) {
+	var scheduledJobs []models.ScheduledJob
+
+	if len(authList) == 0 {
+		return scheduledJobs, nil
+	}
+
+	query := s.db.Order("run_at")
+	if !slices.Contains(authList, "*") {
+		query = query.Where("task IN ?", authList)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	res := query.Find(&scheduledJobs)
+	return scheduledJobs, res.Error
+}
+
+func (s *ScheduledJobServiceImpl) GetScheduledJob(id string) (models.ScheduledJob, error) {
+	var scheduledJob models.ScheduledJob
+
+	res := s.db.Where("scheduled_job_id = ?", id).Find(&scheduledJob)
+	if res.Error != nil {
+		return scheduledJob, res.Error
+	}
+	if scheduledJob.Task == "" {
+		return scheduledJob, fmt.Errorf("scheduled job %s not found, please check id", id)
+	}
+
+	return scheduledJob, nil
+}
+
+// ScheduleJob stores a job to run at a given time, its input is validated straight away.
+func (s *ScheduledJobServiceImpl) ScheduleJob(userID uuid.UUID, username string, taskName string, extraVars string,
+	runAt time.Time) (models.ScheduledJob, error) {
+	if !runAt.After(time.Now()) {
+		return models.ScheduledJob{}, errors.New("run_at must be in the future")
+	}
+
+	task, err := getTaskSpec(s.config.Kube, taskName)
+	if err != nil {
+		return models.ScheduledJob{}, err
+	}
+
+	extraVars, err = normaliseExtraVars(task, extraVars)
+	if err != nil {
+		return models.ScheduledJob{}, err
+	}
+
+	scheduledJob := models.ScheduledJob{
+		Task:      taskName,
+		Owner:     username,
+		OwnerID:   userID,
+		ExtraVars: extraVars,
+		RunAt:     runAt,
+		Status:    ScheduledJobPending,
+	}
+	res := s.db.Create(&scheduledJob)
+
+	return scheduledJob, res.Error
+}
+
+func (s *ScheduledJobServiceImpl) RescheduleJob(id string, runAt time.Time) (models.ScheduledJob, error) {
+	if !runAt.After(time.Now()) {
+		return models.ScheduledJob{}, errors.New("run_at must be in the future")
+	}
+
+	return s.updatePending(id, map[string]interface{}{"run_at": runAt})
+}
+
+func (s *ScheduledJobServiceImpl) CancelScheduledJob(id string) (models.ScheduledJob, error) {
+	return s.updatePending(id, map[string]interface{}{"status": ScheduledJobCancelled})
+}
+
+// updatePending only updates jobs still pending, jobs claimed by the scheduler are launching.
+func (s *ScheduledJobServiceImpl) updatePending(id string, values map[string]interface{}) (models.ScheduledJob, error) {
+	scheduledJob, err := s.GetScheduledJob(id)
+	if err != nil {
+		return scheduledJob, err
+	}
+
+	res := s.db.Model(&models.ScheduledJob{}).
+		Where("scheduled_job_id = ? AND status = ?", id, ScheduledJobPending).
+		Updates(values)
+	if res.Error != nil {
+		return scheduledJob, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return scheduledJob, fmt.Errorf("scheduled job %s is not pending anymore", id)
+	}
+
+	return s.GetScheduledJob(id)
+}
+
+// LaunchScheduledJobs creates the jobs due to run. Rows are claimed with SKIP LOCKED and committed as launching
+// before the jobs are created, so concurrent replicas never launch the same job and rows aren't locked meanwhile.
+// Jobs still launching after the claim timeout are failed, they may or may not have been created.
+func (s *ScheduledJobServiceImpl) LaunchScheduledJobs() error {
+	err := s.db.Model(&models.ScheduledJob{}).
+		Where("status = ? AND claimed_at < ?", ScheduledJobLaunching, time.Now().Add(-scheduledJobsClaimTimeout)).
+		Updates(map[string]interface{}{
+			"status": ScheduledJobFailed,
+			"error":  "the replica launching the job stopped, please check whether it started",
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	var scheduledJobs []models.ScheduledJob
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ?", ScheduledJobPending, time.Now()).
+			Order("run_at").
+			Limit(scheduledJobsBatch).
+			Find(&scheduledJobs)
+		if res.Error != nil || len(scheduledJobs) == 0 {
+			return res.Error
+		}
+
+		ids := make([]uuid.UUID, len(scheduledJobs))
+		for i, scheduledJob := range scheduledJobs {
+			ids[i] = scheduledJob.ID
+		}
+		return tx.Model(&models.ScheduledJob{}).
+			Where("scheduled_job_id IN ?", ids).
+			Updates(map[string]interface{}{"status": ScheduledJobLaunching, "claimed_at": time.Now()}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range scheduledJobs {
+		scheduledJob := &scheduledJobs[i]
+
+		job, err := s.launch(scheduledJob)
+		if err != nil {
+			log.Printf("Failed to launch scheduled job %s: %v\n", scheduledJob.ID, err)
+			scheduledJob.Status = ScheduledJobFailed
+			scheduledJob.Error = err.Error()
+		} else {
+			scheduledJob.Status = ScheduledJobLaunched
+			scheduledJob.JobID = job.ID
+		}
+
+		// The other jobs are still launched, this one is failed once its claim times out
+		err = s.db.Model(scheduledJob).
+			Where("status = ?", ScheduledJobLaunching).
+			Select("status", "job_id", "error").
+			Updates(scheduledJob).Error
+		if err != nil {
+			log.Printf("Failed to update scheduled job %s to %s: %v\n", scheduledJob.ID, scheduledJob.Status, err)
+		}
+	}
+
+	return nil
+}
+
+// launch creates the job as its owner, whose roles may have been removed since it was scheduled.
+func (s *ScheduledJobServiceImpl) launch(scheduledJob *models.ScheduledJob) (models.Job, error) {
+	var owner models.User
+	res := s.db.Where("user_id = ?", scheduledJob.OwnerID).Find(&owner)
+	if res.Error != nil {
+		return models.Job{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.Job{}, fmt.Errorf("scheduled job owner %s not found", scheduledJob.Owner)
+	}
+
+	authorised, err := s.AuthService.IsAutorised(&models.Authorization{
+		UserID:     owner.ID,
+		Provider:   owner.Provider,
+		Resource:   "jobs",
+		ResourceID: scheduledJob.Task,
+		Access:     "write",
+	})
+	if err != nil {
+		return models.Job{}, err
+	}
+	if !authorised {
+		return models.Job{}, fmt.Errorf("scheduled job owner %s cannot run task %s anymore", owner.Username, scheduledJob.Task)
+	}
+
+	return s.JobService.CreateJob(owner.Username, scheduledJob.Task, scheduledJob.ExtraVars, false)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type fakeJobService struct {
+	JobService
+	created []string
+}
+
+func (f *fakeJobService) CreateJob(username string, task string, extraVars string, override bool) (models.Job, error) {
+	if task == "missing" {
+		return models.Job{}, errors.New("task missing not found")
+	}
+	f.created = append(f.created, username+"/"+task)
+	return models.Job{ID: task + "-x7k2p"}, nil
+}
+
+// fakeAuthService authorises users to run the tasks they're granted.
+type fakeAuthService struct {
+	AuthService
+	granted map[uuid.UUID][]string
+}
+
+func (f *fakeAuthService) IsAutorised(auth *models.Authorization) (bool, error) {
+	for _, task := range f.granted[auth.UserID] {
+		if auth.Resource == "jobs" && task == auth.ResourceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestLaunchScheduledJobs(t *testing.T) {
+	db := testDB(t, &models.ScheduledJob{}, &models.User{})
+	alice := models.User{ID: uuid.NewV4(), Username: "alice", Provider: "local"}
+	bob := models.User{ID: uuid.NewV4(), Username: "bob", Provider: "local"}
+	db.Create(&alice)
+	db.Create(&bob)
+
+	jobs := &fakeJobService{}
+	s := &ScheduledJobServiceImpl{
+		db:         db,
+		JobService: jobs,
+		AuthService: &fakeAuthService{granted: map[uuid.UUID][]string{
+			alice.ID: {"backup", "missing", "report"},
+			bob.ID:   {"report"},
+		}},
+	}
+
+	due := time.Now().Add(-time.Minute)
+	scheduled := []models.ScheduledJob{
+		{Task: "backup", Owner: "alice", OwnerID: alice.ID, RunAt: due},
+		// Bob's role granting backup was removed after the job was scheduled
+		{Task: "backup", Owner: "bob", OwnerID: bob.ID, RunAt: due},
+		{Task: "report", Owner: "carol", OwnerID: uuid.NewV4(), RunAt: due},
+		{Task: "missing", Owner: "alice", OwnerID: alice.ID, RunAt: due},
+		{Task: "report", Owner: "bob", OwnerID: bob.ID, RunAt: time.Now().Add(time.Hour)},
+	}
+	for i := range scheduled {
+		scheduled[i].ID = uuid.NewV4()
+		scheduled[i].Status = ScheduledJobPending
+		db.Create(&scheduled[i])
+	}
+
+	if err := s.LaunchScheduledJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs.created) != 1 || jobs.created[0] != "alice/backup" {
+		t.Errorf("unexpected jobs created %v", jobs.created)
+	}
+	expected := []struct {
+		status string
+		jobID  string
+	}{
+		{ScheduledJobLaunched, "backup-x7k2p"},
+		{ScheduledJobFailed, ""},
+		{ScheduledJobFailed, ""},
+		{ScheduledJobFailed, ""},
+		{ScheduledJobPending, ""},
+	}
+	for i, e := range expected {
+		scheduledJob, _ := s.GetScheduledJob(scheduled[i].ID.String())
+		if scheduledJob.Status != e.status || scheduledJob.JobID != e.jobID {
+			t.Errorf("%s of %s: expected %s %q, got %s %q (%s)", scheduled[i].Task, scheduled[i].Owner,
+				e.status, e.jobID, scheduledJob.Status, scheduledJob.JobID, scheduledJob.Error)
+		}
+		if e.status == ScheduledJobFailed && scheduledJob.Error == "" {
+			t.Errorf("%s of %s: failed without an error", scheduled[i].Task, scheduled[i].Owner)
+		}
+	}
+}
+
+func TestLaunchScheduledJobsUpdateFailure(t *testing.T) {
+	db := testDB(t, &models.ScheduledJob{}, &models.User{})
+	alice := models.User{ID: uuid.NewV4(), Username: "alice", Provider: "local"}
+	db.Create(&alice)
+
+	due := time.Now().Add(-time.Minute)
+	first := models.ScheduledJob{ID: uuid.NewV4(), Task: "backup", OwnerID: alice.ID, RunAt: due.Add(-time.Minute), Status: ScheduledJobPending}
+	second := models.ScheduledJob{ID: uuid.NewV4(), Task: "report", OwnerID: alice.ID, RunAt: due, Status: ScheduledJobPending}
+	db.Create(&first)
+	db.Create(&second)
+
+	// The status of the first job launched can't be saved
+	db.Callback().Update().Before("gorm:update").Register("fail_first", func(tx *gorm.DB) {
+		if scheduledJob, ok := tx.Statement.Dest.(*models.ScheduledJob); ok && scheduledJob.ID == first.ID {
+			tx.AddError(errors.New("connection reset"))
+		}
+	})
+
+	jobs := &fakeJobService{}
+	s := &ScheduledJobServiceImpl{
+		db:          db,
+		JobService:  jobs,
+		AuthService: &fakeAuthService{granted: map[uuid.UUID][]string{alice.ID: {"backup", "report"}}},
+	}
+	if err := s.LaunchScheduledJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs.created) != 2 {
+		t.Errorf("expected the rest of the batch to be launched, got %v", jobs.created)
+	}
+	if scheduledJob, _ := s.GetScheduledJob(second.ID.String()); scheduledJob.Status != ScheduledJobLaunched {
+		t.Errorf("expected the second job to be launched, got %s", scheduledJob.Status)
+	}
+	// The first job stays claimed until its claim times out
+	if scheduledJob, _ := s.GetScheduledJob(first.ID.String()); scheduledJob.Status != ScheduledJobLaunching {
+		t.Errorf("expected the first job to stay launching, got %s", scheduledJob.Status)
+	}
+}
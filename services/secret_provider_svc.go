@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+)
+
+const (
+	SecretProviderKubernetes = "kubernetes"
+	SecretProviderVault      = "vault"
+)
+
+// SecretProvider resolves the secrets of a runner when a job is created.
+type SecretProvider interface {
+	// JobSecrets returns the values to inject into the job, a nil map means that
+	// the job reads them straight from the runner Kubernetes Secret.
+	JobSecrets(*models.Runner) (map[string]string, error)
+	Validate(*models.Runner) error
+}
+
+type KubernetesSecretProvider struct{}
+
+type VaultSecretProvider struct {
+	config config.VaultConfig
+}
+
+func NewSecretProvider(config config.Config, provider string) (SecretProvider, error) {
+	switch provider {
+	case "", SecretProviderKubernetes:
+		return &KubernetesSecretProvider{}, nil
+	case SecretProviderVault:
+		return &VaultSecretProvider{config: config.Vault}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret provider %s", provider)
+	}
+}
+
+func (k *KubernetesSecretProvider) JobSecrets(runner *models.Runner) (map[string]string, error) {
+	return nil, nil
+}
+
+func (k *KubernetesSecretProvider) Validate(runner *models.Runner) error {
+	return nil
+}
+
+func (v *VaultSecretProvider) JobSecrets(runner *models.Runner) (map[string]string, error) {
+	var secrets map[string]string
+	var err error
+
+	if runner.VaultEngine == "dynamic" {
+		secrets, err = helpers.ReadVaultDynamicSecret(v.config, runner.VaultMount, runner.VaultPath)
+	} else {
+		secrets, err = helpers.ReadVaultKV(v.config, runner.VaultMount, runner.VaultPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch secrets for runner %s: %w", runner.Name, err)
+	}
+
+	return secrets, nil
+}
+
+func (v *VaultSecretProvider) Validate(runner *models.Runner) error {
+	if v.config.Address == "" {
+		return fmt.Errorf("vault secret provider is not configured, please set VAULT_ADDR")
+	}
+	if runner.VaultEngine != "" && runner.VaultEngine != "kv" && runner.VaultEngine != "dynamic" {
+		return fmt.Errorf("invalid vaultEngine '%s', should be 'kv' or 'dynamic'", runner.VaultEngine)
+	}
+	if runner.VaultMount == "" || runner.VaultPath == "" {
+		return fmt.Errorf("vaultMount and vaultPath are required with the vault secret provider")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	snmpTrapOID   = ".1.3.6.1.6.3.1.1.4.1.0"
+	snmpUptimeOID = ".1.3.6.1.2.1.1.3.0"
+	// Generic SNMPv1 traps, as SNMPv2 trap OIDs by RFC 3584
+	snmpGenericTrapsOID = ".1.3.6.1.6.3.1.1.5"
+)
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"":       gosnmp.NoAuth,
+	"none":   gosnmp.NoAuth,
+	"md5":    gosnmp.MD5,
+	"sha":    gosnmp.SHA,
+	"sha224": gosnmp.SHA224,
+	"sha256": gosnmp.SHA256,
+	"sha384": gosnmp.SHA384,
+	"sha512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"":        gosnmp.NoPriv,
+	"none":    gosnmp.NoPriv,
+	"des":     gosnmp.DES,
+	"aes":     gosnmp.AES,
+	"aes192":  gosnmp.AES192,
+	"aes256":  gosnmp.AES256,
+	"aes192c": gosnmp.AES192C,
+	"aes256c": gosnmp.AES256C,
+}
+
+// ListenSNMP receives SNMP traps and informs on UDP. SNMPv1 and v2c traps are accepted with the configured
+// communities, SNMPv3 ones from the configured users only, so at least one of them is required.
+func (e *EventRuleServiceImpl) ListenSNMP() error {
+	if e.config.SNMP.Communities == "" && e.config.SNMP.V3Users == "" {
+		return errors.New("no SNMP community or SNMPv3 user configured, please set SNMP_COMMUNITIES or SNMP_V3_USERS")
+	}
+
+	logger := gosnmp.NewLogger(log.New(io.Discard, "", 0))
+	users := gosnmp.NewSnmpV3SecurityParametersTable(logger)
+	err := addSNMPUsers(users, e.config.SNMP.V3Users, logger)
+	if err != nil {
+		return err
+	}
+
+	var communities []string
+	if e.config.SNMP.Communities != "" {
+		communities = strings.Split(e.config.SNMP.Communities, ",")
+	}
+
+	listener := gosnmp.NewTrapListener()
+	// SNMPv3 traps are authenticated against the users table only when the params are v3,
+	// earlier versions are read whatever the params
+	listener.Params = &gosnmp.GoSNMP{
+		Version:                     gosnmp.Version3,
+		TrapSecurityParametersTable: users,
+		Logger:                      logger,
+	}
+	listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+		if packet.Version != gosnmp.Version3 && !slices.Contains(communities, packet.Community) {
+			log.Printf("Ignoring SNMP trap from %s with an unknown community\n", addr.IP)
+			return
+		}
+		e.handleEvent(EventSourceSNMP, snmpFields(packet, addr.IP.String()))
+	}
+
+	log.Printf("Listening for SNMP traps on %s\n", e.config.SNMP.TrapAddr)
+	return listener.Listen("udp://" + e.config.SNMP.TrapAddr)
+}
+
+// addSNMPUsers adds the SNMPv3 users, configured as user:auth protocol:auth passphrase:privacy protocol:privacy passphrase
+// with the protocols and passphrases optional.
+func addSNMPUsers(table *gosnmp.SnmpV3SecurityParametersTable, users string, logger gosnmp.Logger) error {
+	if users == "" {
+		return nil
+	}
+
+	for _, user := range strings.Split(users, ",") {
+		parts := strings.Split(user, ":")
+		parts = append(parts, make([]string, 5-min(len(parts), 5))...)
+		if parts[0] == "" || len(parts) > 5 {
+			return fmt.Errorf("invalid SNMPv3 user %q", user)
+		}
+
+		auth, ok := snmpAuthProtocols[strings.ToLower(parts[1])]
+		if !ok {
+			return fmt.Errorf("invalid authentication protocol %s of SNMPv3 user %s", parts[1], parts[0])
+		}
+		priv, ok := snmpPrivProtocols[strings.ToLower(parts[3])]
+		if !ok {
+			return fmt.Errorf("invalid privacy protocol %s of SNMPv3 user %s", parts[3], parts[0])
+		}
+
+		err := table.Add(parts[0], &gosnmp.UsmSecurityParameters{
+			UserName:                 parts[0],
+			AuthenticationProtocol:   auth,
+			AuthenticationPassphrase: parts[2],
+			PrivacyProtocol:          priv,
+			PrivacyPassphrase:        parts[4],
+			Logger:                   logger,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid SNMPv3 user %s: %w", parts[0], err)
+		}
+	}
+
+	return nil
+}
+
+// snmpFields reads a trap into the fields matched by rules and passed to their tasks,
+// its variables are mapped by OID.
+func snmpFields(packet *gosnmp.SnmpPacket, address string) map[string]interface{} {
+	fields := map[string]interface{}{
+		"source":   EventSourceSNMP,
+		"host":     address,
+		"address":  address,
+		"version":  packet.Version.String(),
+		"user":     "",
+		"trap_oid": "",
+		"uptime":   uint(0),
+	}
+	if usm, ok := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		fields["user"] = usm.UserName
+	}
+
+	if packet.Version == gosnmp.Version1 {
+		fields["uptime"] = packet.Timestamp
+		if packet.GenericTrap == 6 {
+			fields["trap_oid"] = fmt.Sprintf("%s.0.%d", packet.Enterprise, packet.SpecificTrap)
+		} else {
+			fields["trap_oid"] = fmt.Sprintf("%s.%d", snmpGenericTrapsOID, packet.GenericTrap+1)
+		}
+		if packet.AgentAddress != "" && packet.AgentAddress != "0.0.0.0" {
+			fields["host"] = packet.AgentAddress
+		}
+	}
+
+	variables := map[string]interface{}{}
+	for _, variable := range packet.Variables {
+		value := snmpValue(variable)
+		switch variable.Name {
+		case snmpTrapOID:
+			fields["trap_oid"] = value
+		case snmpUptimeOID:
+			fields["uptime"] = value
+		default:
+			variables[variable.Name] = value
+		}
+	}
+	fields["variables"] = variables
+
+	return fields
+}
+
+// snmpValue converts a variable to a JSON friendly value, octet strings that aren't text are kept as hex.
+func snmpValue(variable gosnmp.SnmpPDU) interface{} {
+	switch value := variable.Value.(type) {
+	case []byte:
+		if utf8.Valid(value) && !strings.ContainsFunc(string(value), func(r rune) bool {
+			return r < ' ' && r != '\t' && r != '\n' && r != '\r'
+		}) {
+			return string(value)
+		}
+		return fmt.Sprintf("%x", value)
+	case nil:
+		return nil
+	case string, int, uint, uint32, uint64, int64, float32, float64, bool:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syslog facilities and severities, indexed by their code.
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+const (
+	// Large enough for the messages of network devices, longer ones are truncated
+	syslogMaxMessage = 64 * 1024
+	syslogTCPTimeout = 5 * time.Minute
+	// Connections over this are closed as they're accepted
+	syslogMaxConnections = 100
+)
+
+// ListenSyslog receives syslog messages on UDP and TCP, RFC 5424 and RFC 3164 formats are accepted.
+// TCP messages are framed with octet counting or newlines, as described in RFC 6587.
+func (e *EventRuleServiceImpl) ListenSyslog() error {
+	udp, err := net.ListenPacket("udp", e.config.SyslogAddr)
+	if err != nil {
+		return err
+	}
+	defer udp.Close()
+
+	tcp, err := net.Listen("tcp", e.config.SyslogAddr)
+	if err != nil {
+		return err
+	}
+	defer tcp.Close()
+
+	log.Printf("Listening for syslog messages on %s\n", e.config.SyslogAddr)
+
+	errs := make(chan error, 2)
+	go func() {
+		buf := make([]byte, syslogMaxMessage)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			e.handleSyslog(string(buf[:n]), addr)
+		}
+	}()
+	go func() {
+		connections := make(chan struct{}, syslogMaxConnections)
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case connections <- struct{}{}:
+				go func() {
+					defer func() { <-connections }()
+					e.readSyslogStream(conn)
+				}()
+			default:
+				log.Printf("Too many syslog connections, closing connection from %s\n", conn.RemoteAddr())
+				conn.Close()
+			}
+		}
+	}()
+
+	return <-errs
+}
+
+func (e *EventRuleServiceImpl) readSyslogStream(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, syslogMaxMessage)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(syslogTCPTimeout))
+		msg, err := readSyslogFrame(reader)
+		if msg != "" {
+			e.handleSyslog(msg, conn.RemoteAddr())
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Syslog connection from %s closed: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads a message starting with its length, or else ending with a newline.
+// Lines longer than the reader buffer are truncated.
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		length, err := reader.ReadSlice(' ')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", errors.New("invalid message length")
+		}
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(string(length), " "))
+		if err != nil || n > syslogMaxMessage {
+			return "", fmt.Errorf("invalid message length %q", length)
+		}
+		msg := make([]byte, n)
+		_, err = io.ReadFull(reader, msg)
+		return string(msg), err
+	}
+
+	line, err := reader.ReadSlice('\n')
+	msg := string(line)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = reader.ReadSlice('\n')
+	}
+	return strings.TrimRight(msg, "\r\n\x00"), err
+}
+
+func (e *EventRuleServiceImpl) handleSyslog(msg string, addr net.Addr) {
+	address := addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	fields, err := parseSyslog(msg, address)
+	if err != nil {
+		log.Printf("Invalid syslog message from %s: %v\n", address, err)
+		return
+	}
+
+	e.handleEvent(EventSourceSyslog, fields)
+}
+
+// parseSyslog reads a syslog message into the fields matched by rules and passed to their tasks.
+// Messages that aren't RFC 5424 are read leniently as RFC 3164, devices rarely follow it exactly.
+func parseSyslog(msg string, address string) (map[string]interface{}, error) {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	if !strings.HasPrefix(msg, "<") {
+		return nil, errors.New("missing priority")
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New("invalid priority")
+	}
+	priority, err := strconv.Atoi(msg[1:end])
+	if err != nil || priority > 191 {
+		return nil, fmt.Errorf("invalid priority %q", msg[1:end])
+	}
+	msg = msg[end+1:]
+
+	fields := map[string]interface{}{
+		"source":    EventSourceSyslog,
+		"host":      address,
+		"address":   address,
+		"facility":  syslogFacilities[priority/8],
+		"severity":  syslogSeverities[priority%8],
+		"app":       "",
+		"procid":    "",
+		"msgid":     "",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"message":   msg,
+	}
+
+	if strings.HasPrefix(msg, "1 ") {
+		return fields, parseRFC5424(msg[2:], fields)
+	}
+	parseRFC3164(msg, fields)
+	return fields, nil
+}
+
+// parseRFC5424 reads the header, structured data and message following the version.
+func parseRFC5424(msg string, fields map[string]interface{}) error {
+	header := strings.SplitN(msg, " ", 6)
+	if len(header) < 6 {
+		return errors.New("incomplete RFC 5424 header")
+	}
+
+	for i, name := range []string{"timestamp", "host", "app", "procid", "msgid"} {
+		if header[i] != "-" {
+			fields[name] = header[i]
+		}
+	}
+
+	data, rest, err := parseStructuredData(header[5])
+	if err != nil {
+		return err
+	}
+	fields["structured_data"] = data
+	fields["message"] = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+
+	return nil
+}
+
+// parseStructuredData reads the structured data elements, as a map of parameters by element ID.
+func parseStructuredData(msg string) (map[string]interface{}, string, error) {
+	data := map[string]interface{}{}
+	if strings.HasPrefix(msg, "-") {
+		return data, msg[1:], nil
+	}
+
+	for strings.HasPrefix(msg, "[") {
+		end := strings.IndexAny(msg, " ]")
+		if end < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		params := map[string]interface{}{}
+		data[msg[1:end]] = params
+		msg = msg[end:]
+
+		for strings.HasPrefix(msg, " ") {
+			eq := strings.Index(msg, `="`)
+			if eq < 0 {
+				return nil, "", errors.New("invalid structured data parameter")
+			}
+			name := msg[1:eq]
+			msg = msg[eq+2:]
+
+			var value strings.Builder
+			i := 0
+			for ; i < len(msg) && msg[i] != '"'; i++ {
+				if msg[i] == '\\' && i+1 < len(msg) && strings.IndexByte(`"\]`, msg[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(msg[i])
+			}
+			if i == len(msg) {
+				return nil, "", errors.New("unterminated structured data parameter")
+			}
+			params[name] = value.String()
+			msg = msg[i+1:]
+		}
+
+		if !strings.HasPrefix(msg, "]") {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		msg = msg[1:]
+	}
+
+	return data, msg, nil
+}
+
+// parseRFC3164 reads the timestamp, hostname and tag when they're present, the sender address is
+// the hostname otherwise.
+func parseRFC3164(msg string, fields map[string]interface{}) {
+	if len(msg) >= len(time.Stamp) {
+		if ts, err := time.Parse(time.Stamp, msg[:len(time.Stamp)]); err == nil {
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// Messages sent on new year's eve and received after it
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			fields["timestamp"] = ts.Format(time.RFC3339)
+			msg = strings.TrimPrefix(msg[len(time.Stamp):], " ")
+
+			if host, rest, ok := strings.Cut(msg, " "); ok && !strings.HasSuffix(host, ":") {
+				fields["host"] = host
+				msg = rest
+			}
+		}
+	}
+
+	tag, rest, ok := strings.Cut(msg, ": ")
+	if ok && tag != "" && !strings.ContainsAny(tag, " \t") {
+		if app, pid, ok := strings.Cut(tag, "["); ok && strings.HasSuffix(pid, "]") {
+			fields["app"] = app
+			fields["procid"] = strings.TrimSuffix(pid, "]")
+		} else {
+			fields["app"] = tag
+		}
+		msg = rest
+	}
+
+	fields["message"] = msg
+}
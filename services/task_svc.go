@@ -1,21 +1,16 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"sort"
 	"strconv"
 
 	"github.com/kriten-io/kriten/config"
 	"github.com/kriten-io/kriten/helpers"
 	"github.com/kriten-io/kriten/models"
 
-	"github.com/go-openapi/loads"
-	"github.com/go-openapi/strfmt"
-	"github.com/go-openapi/validate"
 	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
 )
 
 type TaskService interface {
@@ -27,15 +22,24 @@ type TaskService interface {
 	GetSchema(string) (map[string]interface{}, error)
 	DeleteSchema(string) error
 	UpdateSchema(string, map[string]interface{}) (map[string]interface{}, error)
+	CheckTask(models.Task) error
+	SyncRevision(string) error
+	ListRevisions(string) ([]models.TaskRevision, error)
+	GetRevision(string, int) (models.TaskRevision, error)
+	DiffRevisions(string, int, int) (models.TaskRevisionDiff, error)
+	RollbackTask(string, int) (*models.Task, error)
+	SyncManifest(string, models.RunnerManifest, bool) ([]models.ManifestChange, error)
 }
 
 type TaskServiceImpl struct {
+	db             *gorm.DB
 	WebhookService WebhookService
 	config         config.Config
 }
 
-func NewTaskService(ws WebhookService, config config.Config) TaskService {
+func NewTaskService(database *gorm.DB, ws WebhookService, config config.Config) TaskService {
 	return &TaskServiceImpl{
+		db:             database,
 		WebhookService: ws,
 		config:         config,
 	}
@@ -48,30 +52,20 @@ func (t *TaskServiceImpl) ListTasks(authList []string) ([]*models.Task, error) {
 		return tasks, nil
 	}
 
-	configMaps, err := helpers.ListConfigMaps(t.config.Kube)
+	objs, err := helpers.ListResources(t.config.Kube, helpers.KindTask, "")
 	if err != nil {
 		return nil, err
 	}
 
-	for _, configMap := range configMaps.Items {
-		runnerName := configMap.Data["runner"]
-		if runnerName != "" {
-			if authList[0] == "*" || slices.Contains(authList, configMap.Data["name"]) {
-				var taskData *models.Task
-				b, _ := json.Marshal(configMap.Data)
-
-				_ = json.Unmarshal(b, &taskData)
-				taskData.Synchronous, _ = strconv.ParseBool(configMap.Data["synchronous"])
-				if configMap.Data["schema"] != "" {
-					var jsonData map[string]interface{}
-					err = json.Unmarshal([]byte(configMap.Data["schema"]), &jsonData)
-					if err != nil {
-						return nil, err
-					}
-					taskData.Schema = jsonData
-				}
-				tasks = append(tasks, taskData)
+	for i := range objs {
+		if authList[0] == "*" || slices.Contains(authList, objs[i].GetName()) {
+			var taskData models.Task
+			err = helpers.ResourceToModel(&objs[i], &taskData)
+			if err != nil {
+				return nil, err
 			}
+			taskData.Revision, _ = strconv.Atoi(objs[i].GetLabels()[helpers.LabelTaskRevision])
+			tasks = append(tasks, &taskData)
 		}
 	}
 
@@ -79,65 +73,38 @@ func (t *TaskServiceImpl) ListTasks(authList []string) ([]*models.Task, error) {
 }
 
 func (t *TaskServiceImpl) GetTask(name string) (*models.Task, error) {
+	return getTaskSpec(t.config.Kube, name)
+}
+
+func getTaskSpec(kube config.KubeConfig, name string) (*models.Task, error) {
 	var taskData models.Task
-	configMap, err := helpers.GetConfigMap(t.config.Kube, name)
+
+	obj, err := helpers.GetResource(kube, helpers.KindTask, name)
 	if err != nil {
 		return nil, err
 	}
-	if configMap.Data["runner"] == "" {
-		return nil, fmt.Errorf("task %s not found", name)
-	}
-
-	// TODO: this is a temporary solution to return synchronous as a boolean
-	b, _ := json.Marshal(configMap.Data)
-
-	_ = json.Unmarshal(b, &taskData)
-	taskData.Synchronous, _ = strconv.ParseBool(configMap.Data["synchronous"])
 
-	if configMap.Data["schema"] != "" {
-		var jsonData map[string]interface{}
-		err = json.Unmarshal([]byte(configMap.Data["schema"]), &jsonData)
-		if err != nil {
-			return nil, err
-		}
-		taskData.Schema = jsonData
+	err = helpers.ResourceToModel(obj, &taskData)
+	if err != nil {
+		return nil, err
 	}
+	taskData.Revision, _ = strconv.Atoi(obj.GetLabels()[helpers.LabelTaskRevision])
 
 	return &taskData, nil
 }
 
 func (t *TaskServiceImpl) CreateTask(task models.Task) (*models.Task, error) {
-	var jsonData []byte
 	err := helpers.ValidateK8sConfigMapName(task.Name)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
-	runner, err := helpers.GetConfigMap(t.config.Kube, task.Runner)
-	if err != nil || runner.Data["image"] == "" {
-		return nil, fmt.Errorf("error retrieving runner %s, please specify an existing runner", task.Runner)
-	}
 
-	if task.Schema != nil {
-		jsonData, err = json.Marshal(task.Schema)
-		if err != nil {
-			return nil, err
-		}
-
-		err = ValidateSchema(jsonData)
-		if err != nil {
-			return nil, err
-		}
+	err = t.CheckTask(task)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parsing a models.Task into a map
-	b, _ := json.Marshal(task)
-	var data map[string]string
-	_ = json.Unmarshal(b, &data)
-	data["synchronous"] = strconv.FormatBool(task.Synchronous)
-	data["schema"] = string(jsonData)
-	delete(data, "secret")
-
-	_, err = helpers.CreateOrUpdateConfigMap(t.config.Kube, data, "create")
+	err = t.writeTask(task, nil, "create")
 	if err != nil {
 		return nil, err
 	}
@@ -150,38 +117,17 @@ func (t *TaskServiceImpl) CreateTask(task models.Task) (*models.Task, error) {
 }
 
 func (t *TaskServiceImpl) UpdateTask(task models.Task) (*models.Task, error) {
-	var jsonData []byte
-
-	_, err := helpers.GetConfigMap(t.config.Kube, task.Name)
+	_, err := helpers.GetResource(t.config.Kube, helpers.KindTask, task.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	runner, err := helpers.GetConfigMap(t.config.Kube, task.Runner)
-	if err != nil || runner.Data["image"] == "" {
-		return nil, fmt.Errorf("error retrieving runner %s, please specify an existing runner", task.Runner)
-	}
-
-	if task.Schema != nil {
-		jsonData, err = json.Marshal(task.Schema)
-		if err != nil {
-			return nil, err
-		}
-
-		err = ValidateSchema(jsonData)
-		if err != nil {
-			return nil, err
-		}
+	err = t.CheckTask(task)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parsing a models.Task into a map
-	b, _ := json.Marshal(task)
-	var data map[string]string
-	_ = json.Unmarshal(b, &data)
-	data["synchronous"] = strconv.FormatBool(task.Synchronous)
-	data["schema"] = string(jsonData)
-
-	_, err = helpers.CreateOrUpdateConfigMap(t.config.Kube, data, "update")
+	err = t.writeTask(task, nil, "update")
 	if err != nil {
 		return nil, err
 	}
@@ -194,12 +140,20 @@ func (t *TaskServiceImpl) UpdateTask(task models.Task) (*models.Task, error) {
 }
 
 func (t *TaskServiceImpl) DeleteTask(name string) error {
+	_, err := helpers.GetResource(t.config.Kube, helpers.KindTask, name)
+	if err != nil {
+		return err
+	}
+
 	res, err := t.WebhookService.ListTaskWebhooks(name)
+	if err != nil {
+		return err
+	}
 	if len(res) != 0 {
 		return fmt.Errorf("cannot delete task %s, please remove associated webhooks first", name)
 	}
 
-	err = helpers.DeleteConfigMap(t.config.Kube, name)
+	err = helpers.DeleteResource(t.config.Kube, helpers.KindTask, name)
 	if err != nil {
 		return err
 	}
@@ -208,47 +162,27 @@ func (t *TaskServiceImpl) DeleteTask(name string) error {
 }
 
 func (t *TaskServiceImpl) GetSchema(name string) (map[string]interface{}, error) {
-	var data map[string]any
-
-	configMap, err := helpers.GetConfigMap(t.config.Kube, name)
+	task, err := t.GetTask(name)
 	if err != nil {
 		return nil, err
 	}
-	if configMap.Data["runner"] == "" {
-		return nil, fmt.Errorf("task %s not found", name)
-	}
 
-	if configMap.Data["schema"] != "" {
-		err = json.Unmarshal([]byte(configMap.Data["schema"]), &data)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return data, nil
+	return task.Schema, nil
 }
 
 func (t *TaskServiceImpl) UpdateSchema(taskName string, schema map[string]interface{}) (map[string]interface{}, error) {
-	task, err := helpers.GetConfigMap(t.config.Kube, taskName)
-	if err != nil {
-		return nil, err
-	}
-	if task.Data["runner"] == "" {
-		return nil, fmt.Errorf("task %s not found", taskName)
-	}
-
-	data, err := json.Marshal(schema)
+	task, err := t.GetTask(taskName)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ValidateSchema(data)
+	task.Schema = schema
+	err = t.CheckTask(*task)
 	if err != nil {
 		return nil, err
 	}
 
-	task.Data["schema"] = string(data)
-	_, err = helpers.CreateOrUpdateConfigMap(t.config.Kube, task.Data, "update")
+	err = t.writeTask(*task, nil, "update")
 	if err != nil {
 		return nil, err
 	}
@@ -266,40 +200,346 @@ func (t *TaskServiceImpl) DeleteSchema(name string) error {
 		return nil
 	}
 
-	// Parsing a models.Task into a map
-	b, _ := json.Marshal(task)
-	var data map[string]string
-	_ = json.Unmarshal(b, &data)
-	delete(data, "schema")
-	_, err = helpers.CreateOrUpdateConfigMap(t.config.Kube, data, "update")
+	task.Schema = nil
+	return t.writeTask(*task, nil, "update")
+}
+
+// CheckTask validates the runner and schema of a task before it's stored.
+func (t *TaskServiceImpl) CheckTask(task models.Task) error {
+	_, err := helpers.GetResource(t.config.Kube, helpers.KindRunner, task.Runner)
 	if err != nil {
-		return err
+		return fmt.Errorf("error retrieving runner %s, please specify an existing runner", task.Runner)
+	}
+
+	switch task.UnknownProperties {
+	case "", helpers.UnknownPropertiesAllow, helpers.UnknownPropertiesDrop, helpers.UnknownPropertiesReject:
+	default:
+		return fmt.Errorf("invalid unknownProperties %s, allowed: allow, drop, reject", task.UnknownProperties)
+	}
+
+	for _, schema := range []map[string]any{task.Schema, task.OutputSchema} {
+		if schema == nil {
+			continue
+		}
+
+		_, err = helpers.CompileSchema(schema)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func ValidateSchema(schema []byte) error {
-	input, err := os.ReadFile("spec.json")
+// writeTask stores the task revision, then writes the task with it so the resource never has a revision
+// that isn't stored.
+func (t *TaskServiceImpl) writeTask(task models.Task, extraLabels map[string]string, operation string) error {
+	revision, err := t.storeRevision(task)
+	if err != nil {
+		return fmt.Errorf("failed to store the revision of task %s: %w", task.Name, err)
+	}
+
+	spec, err := helpers.ResourceSpec(task, "revision")
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	output := bytes.ReplaceAll(input, []byte("\"%schema%\""), schema)
-	doc, err := loads.Analyzed(output, "2.0")
+	labels := map[string]string{
+		helpers.LabelRunner:       task.Runner,
+		helpers.LabelTaskRevision: strconv.Itoa(revision.Revision),
+	}
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+	obj, err := helpers.CreateOrUpdateResource(t.config.Kube, helpers.KindTask, task.Name, spec, labels, operation)
 	if err != nil {
-		log.Printf("error while loading spec: %v\n", err)
 		return err
 	}
 
-	validate.SetContinueOnErrors(true)       // Set global options
-	err = validate.Spec(doc, strfmt.Default) // Validates spec with default Swagger 2.0 format definitions
+	_ = helpers.UpdateResourceStatus(t.config.Kube, helpers.KindTask, obj, "Ready", "")
+
+	return nil
+}
+
+// storeRevision stores a new revision of a task unless the latest one matches it. Revisions are numbered
+// under a lock so concurrent writes of a task, e.g. by several replicas, don't pick the same number.
+func (t *TaskServiceImpl) storeRevision(task models.Task) (models.TaskRevision, error) {
+	var revision models.TaskRevision
+
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "task_revision/"+task.Name).Error
+		if err != nil {
+			return err
+		}
+
+		revision, err = nextRevision(tx, task)
+		if err != nil || !revision.CreatedAt.IsZero() {
+			return err
+		}
+		return tx.Create(&revision).Error
+	})
+
+	return revision, err
+}
+
+// nextRevision returns the latest revision of a task if it matches, otherwise a new one still to be stored.
+func nextRevision(db *gorm.DB, task models.Task) (models.TaskRevision, error) {
+	var latest models.TaskRevision
+
+	task.Revision = 0
+	res := db.Where("task = ?", task.Name).Order("revision desc").Limit(1).Find(&latest)
+	if res.Error != nil {
+		return latest, res.Error
+	}
+
+	if res.RowsAffected != 0 && sameSpec(latest.Spec, task) {
+		return latest, nil
+	}
 
+	return models.TaskRevision{
+		Task:     task.Name,
+		Revision: latest.Revision + 1,
+		Spec:     task,
+	}, nil
+}
+
+// SyncRevision records a new revision for tasks changed outside of the API, e.g. with kubectl.
+func (t *TaskServiceImpl) SyncRevision(name string) error {
+	task, err := getTaskSpec(t.config.Kube, name)
 	if err != nil {
-		log.Printf("This spec has some validation errors: %v\n", err)
 		return err
 	}
+	current := task.Revision
 
-	return nil
+	revision, err := t.storeRevision(*task)
+	if err != nil {
+		return err
+	}
+	if revision.Revision == current {
+		return nil
+	}
+
+	obj, err := helpers.GetResource(t.config.Kube, helpers.KindTask, name)
+	if err != nil {
+		return err
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[helpers.LabelTaskRevision] = strconv.Itoa(revision.Revision)
+	obj.SetLabels(labels)
+
+	_, err = helpers.UpdateResource(t.config.Kube, helpers.KindTask, obj)
+	return err
+}
+
+func (t *TaskServiceImpl) ListRevisions(name string) ([]models.TaskRevision, error) {
+	var revisions []models.TaskRevision
+
+	res := t.db.Where("task = ?", name).Order("revision desc").Find(&revisions)
+	if res.Error != nil {
+		return revisions, res.Error
+	}
+
+	return revisions, nil
+}
+
+func (t *TaskServiceImpl) GetRevision(name string, revision int) (models.TaskRevision, error) {
+	var taskRevision models.TaskRevision
+
+	res := t.db.Where("task = ? AND revision = ?", name, revision).Find(&taskRevision)
+	if res.Error != nil {
+		return taskRevision, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return taskRevision, fmt.Errorf("revision %d of task %s not found", revision, name)
+	}
+
+	return taskRevision, nil
+}
+
+// DiffRevisions compares two revisions of a task, 0 stands for the current one.
+func (t *TaskServiceImpl) DiffRevisions(name string, from int, to int) (models.TaskRevisionDiff, error) {
+	diff := models.TaskRevisionDiff{Task: name, From: from, To: to}
+
+	if from == 0 || to == 0 {
+		task, err := t.GetTask(name)
+		if err != nil {
+			return diff, err
+		}
+		if from == 0 {
+			diff.From = task.Revision
+		}
+		if to == 0 {
+			diff.To = task.Revision
+		}
+	}
+
+	fromRevision, err := t.GetRevision(name, diff.From)
+	if err != nil {
+		return diff, err
+	}
+	toRevision, err := t.GetRevision(name, diff.To)
+	if err != nil {
+		return diff, err
+	}
+
+	fromSpec, err := helpers.ResourceSpec(fromRevision.Spec, "revision")
+	if err != nil {
+		return diff, err
+	}
+	toSpec, err := helpers.ResourceSpec(toRevision.Spec, "revision")
+	if err != nil {
+		return diff, err
+	}
+
+	diff.Changes = diffValues("", fromSpec, toSpec)
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		return diff.Changes[i].Path < diff.Changes[j].Path
+	})
+
+	return diff, nil
+}
+
+// RollbackTask restores the spec of a previous revision, which is stored as a new revision.
+func (t *TaskServiceImpl) RollbackTask(name string, revision int) (*models.Task, error) {
+	taskRevision, err := t.GetRevision(name, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.UpdateTask(taskRevision.Spec)
+}
+
+// SyncManifest creates, updates and deletes the tasks of a runner to match its manifest.
+// Only tasks created from a manifest are updated or removed, tasks created through the API are left untouched.
+func (t *TaskServiceImpl) SyncManifest(runner string, manifest models.RunnerManifest, dryRun bool) ([]models.ManifestChange, error) {
+	var changes []models.ManifestChange
+
+	objs, err := helpers.ListResources(t.config.Kube, helpers.KindTask, "")
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*models.Task, len(objs))
+	managed := make(map[string]bool, len(objs))
+	for i := range objs {
+		var task models.Task
+		err = helpers.ResourceToModel(&objs[i], &task)
+		if err != nil {
+			return nil, err
+		}
+		existing[task.Name] = &task
+		managed[task.Name] = objs[i].GetLabels()[helpers.LabelSource] == helpers.SourceManifest &&
+			task.Runner == runner
+	}
+
+	labels := map[string]string{helpers.LabelSource: helpers.SourceManifest}
+	declared := make(map[string]bool, len(manifest.Tasks))
+	for _, entry := range manifest.Tasks {
+		task := models.Task{
+			Name:              entry.Name,
+			Runner:            runner,
+			Command:           entry.Command,
+			Schema:            entry.Schema,
+			OutputSchema:      entry.OutputSchema,
+			UnknownProperties: entry.UnknownProperties,
+			Tags:              entry.Tags,
+		}
+		if task.Schema == nil {
+			task.Schema = manifest.Defaults.Schema
+		}
+		if task.UnknownProperties == "" {
+			task.UnknownProperties = manifest.Defaults.UnknownProperties
+		}
+		if entry.Synchronous != nil {
+			task.Synchronous = *entry.Synchronous
+		} else if manifest.Defaults.Synchronous != nil {
+			task.Synchronous = *manifest.Defaults.Synchronous
+		}
+		if task.Command == "" {
+			task.Command = manifest.Defaults.Command
+		}
+
+		change := models.ManifestChange{Task: task.Name}
+		if declared[task.Name] {
+			change.Action, change.Reason = "skip", "declared more than once"
+			changes = append(changes, change)
+			continue
+		}
+		declared[task.Name] = true
+
+		operation := "create"
+		current, ok := existing[task.Name]
+		switch {
+		case ok && !managed[task.Name]:
+			change.Action, change.Reason = "skip", "task exists and is not managed by the manifest"
+			changes = append(changes, change)
+			continue
+		case ok && sameSpec(current, task):
+			change.Action = "unchanged"
+			changes = append(changes, change)
+			continue
+		case ok:
+			operation = "update"
+		}
+		change.Action = operation
+
+		err = helpers.ValidateK8sConfigMapName(task.Name)
+		if err == nil && task.Command == "" {
+			err = fmt.Errorf("command is required")
+		}
+		if err == nil {
+			err = t.CheckTask(task)
+		}
+		if err == nil && !dryRun {
+			err = t.writeTask(task, labels, operation)
+		}
+		if err != nil {
+			change.Error = err.Error()
+		}
+		changes = append(changes, change)
+	}
+
+	for name := range managed {
+		if !managed[name] || declared[name] {
+			continue
+		}
+		change := models.ManifestChange{Task: name, Action: "delete"}
+		if !dryRun {
+			err = t.DeleteTask(name)
+			if err != nil {
+				change.Error = err.Error()
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// diffValues returns the changes between two decoded JSON values, objects are compared key by key.
+func diffValues(path string, from interface{}, to interface{}) []models.TaskRevisionChange {
+	var changes []models.TaskRevisionChange
+
+	fromMap, fromOk := from.(map[string]interface{})
+	toMap, toOk := to.(map[string]interface{})
+	if !fromOk || !toOk {
+		if !sameSpec(from, to) {
+			changes = append(changes, models.TaskRevisionChange{Path: path, From: from, To: to})
+		}
+		return changes
+	}
+
+	for k, v := range fromMap {
+		changes = append(changes, diffValues(path+"/"+k, v, toMap[k])...)
+	}
+	for k, v := range toMap {
+		if _, ok := fromMap[k]; !ok {
+			changes = append(changes, models.TaskRevisionChange{Path: path + "/" + k, To: v})
+		}
+	}
+
+	return changes
 }
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+type taskWebhookService struct {
+	WebhookService
+	webhooks []models.Webhook
+	err      error
+}
+
+func (f *taskWebhookService) ListTaskWebhooks(_ string) ([]models.Webhook, error) {
+	return f.webhooks, f.err
+}
+
+func TestDeleteTask(t *testing.T) {
+	tests := []struct {
+		name     string
+		webhooks *taskWebhookService
+		deleted  bool
+	}{
+		{"without webhooks", &taskWebhookService{}, true},
+		{"with webhooks", &taskWebhookService{webhooks: []models.Webhook{{Task: "backup"}}}, false},
+		// The task isn't deleted when its webhooks can't be checked
+		{"webhooks not listed", &taskWebhookService{err: errors.New("connection refused")}, false},
+	}
+
+	for _, test := range tests {
+		task := &unstructured.Unstructured{}
+		task.SetAPIVersion(helpers.KritenGroup + "/" + helpers.KritenVersion)
+		task.SetKind("Task")
+		task.SetNamespace("kriten")
+		task.SetName("backup")
+		client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), task)
+
+		ts := &TaskServiceImpl{
+			WebhookService: test.webhooks,
+			config:         config.Config{Kube: config.KubeConfig{DynamicClient: client, Namespace: "kriten"}},
+		}
+		err := ts.DeleteTask("backup")
+		if (err == nil) != test.deleted {
+			t.Errorf("%s: expected deleted %v, got %v", test.name, test.deleted, err)
+		}
+
+		_, err = client.Resource(helpers.TaskResource).Namespace("kriten").Get(context.TODO(), "backup", metav1.GetOptions{})
+		if (err != nil) != test.deleted {
+			t.Errorf("%s: task deleted %v, got %v", test.name, test.deleted, err)
+		}
+	}
+}
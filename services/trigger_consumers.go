@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/nats-io/nats.go"
+	uuid "github.com/satori/go.uuid"
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm/clause"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kubernetes events are recorded long enough for every replica to have seen them
+const triggerEventsRetention = 24 * time.Hour
+
+// messageHandler runs a trigger on an event received by its consumer.
+type messageHandler func(trigger models.Trigger, event models.CloudEvent)
+
+// SyncConsumers starts consumers for the enabled NATS, Kafka and Kubernetes triggers, restarts the ones
+// of updated triggers and stops the others. Consumers failing to start are retried on the next sync.
+func (t *TriggerServiceImpl) SyncConsumers() error {
+	var triggers []models.Trigger
+	res := t.db.Where("source IN ? AND disable = ?", []string{TriggerNATS, TriggerKafka, TriggerKubernetes}, false).
+		Find(&triggers)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	err := t.db.Where("created_at < ?", time.Now().Add(-triggerEventsRetention)).Delete(&models.TriggerEvent{}).Error
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	active := map[uuid.UUID]bool{}
+	for _, trigger := range triggers {
+		active[trigger.ID] = true
+
+		consumer, ok := t.consumers[trigger.ID]
+		if ok && consumer.updatedAt.Equal(trigger.UpdatedAt) {
+			continue
+		}
+		if ok {
+			consumer.cancel()
+			delete(t.consumers, trigger.ID)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var err error
+		switch trigger.Source {
+		case TriggerNATS:
+			err = consumeNATS(ctx, trigger, t.handleMessage)
+		case TriggerKafka:
+			err = consumeKafka(ctx, trigger, t.handleMessage)
+		case TriggerKubernetes:
+			err = t.consumeKubernetes(ctx, trigger)
+		}
+		if err != nil {
+			cancel()
+			log.Printf("Failed to start consumer of trigger %s: %v\n", trigger.Name, err)
+			continue
+		}
+
+		log.Printf("Started %s consumer of trigger %s\n", trigger.Source, trigger.Name)
+		t.consumers[trigger.ID] = &triggerConsumer{updatedAt: trigger.UpdatedAt, cancel: cancel}
+	}
+
+	for id, consumer := range t.consumers {
+		if !active[id] {
+			consumer.cancel()
+			delete(t.consumers, id)
+		}
+	}
+
+	return nil
+}
+
+// consumeNATS subscribes to the trigger subject in a queue group, so every message is handled once
+// whatever the number of replicas. The connection is drained when the context is cancelled.
+func consumeNATS(ctx context.Context, trigger models.Trigger, handle messageHandler) error {
+	conn, err := nats.Connect(strings.Join(trigger.Servers, ","),
+		nats.Name("kriten-trigger-"+trigger.Name), nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.QueueSubscribe(trigger.Subject, triggerGroup(trigger), func(msg *nats.Msg) {
+		event, err := busEvent("nats://"+msg.Subject, msg.Header.Get, "ce-", msg.Header.Get("Content-Type"), msg.Data)
+		if err != nil {
+			log.Printf("Trigger %s received an invalid event: %v\n", trigger.Name, err)
+			return
+		}
+		handle(trigger, event)
+	})
+	if err == nil {
+		// The subscription is registered on the server once flushed
+		err = conn.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := conn.Drain(); err != nil {
+			conn.Close()
+		}
+	}()
+
+	return nil
+}
+
+// consumeKafka reads the trigger topic in a consumer group. Offsets are committed once messages are handled,
+// so messages being handled by a replica that stops are read again.
+func consumeKafka(ctx context.Context, trigger models.Trigger, handle messageHandler) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: trigger.Servers,
+		Topic:   trigger.Topic,
+		GroupID: triggerGroup(trigger),
+	})
+
+	go func() {
+		defer reader.Close()
+		for {
+			msg, err := reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Trigger %s failed to read from kafka: %v\n", trigger.Name, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+				continue
+			}
+
+			header := func(key string) string {
+				for _, h := range msg.Headers {
+					if h.Key == key {
+						return string(h.Value)
+					}
+				}
+				return ""
+			}
+			event, err := busEvent("kafka://"+msg.Topic, header, "ce_", header("content-type"), msg.Value)
+			if err != nil {
+				log.Printf("Trigger %s received an invalid event: %v\n", trigger.Name, err)
+			} else {
+				handle(trigger, event)
+			}
+
+			err = reader.CommitMessages(ctx, msg)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Trigger %s failed to commit kafka offset %d: %v\n", trigger.Name, msg.Offset, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// consumeKubernetes watches the trigger resource with an informer. Objects listed when it starts aren't events,
+// and every replica watches so events are recorded to run the task once.
+func (t *TriggerServiceImpl) consumeKubernetes(ctx context.Context, trigger models.Trigger) error {
+	gvr, err := helpers.ParseGVR(trigger.Resource)
+	if err != nil {
+		return err
+	}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(t.config.Kube.DynamicClient, gvr, trigger.Namespace, 0,
+		cache.Indexers{}, func(options *metav1.ListOptions) {
+			options.LabelSelector = trigger.LabelSelector
+			options.FieldSelector = trigger.FieldSelector
+		}).Informer()
+
+	watched := func(eventType string) bool {
+		return len(trigger.Types) == 0 || slices.Contains(trigger.Types, eventType)
+	}
+	handler := cache.ResourceEventHandlerDetailedFuncs{}
+	if watched("add") {
+		handler.AddFunc = func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				t.handleObject(trigger, "add", nil, obj)
+			}
+		}
+	}
+	if watched("update") {
+		handler.UpdateFunc = func(oldObj, newObj interface{}) {
+			t.handleObject(trigger, "update", oldObj, newObj)
+		}
+	}
+	if watched("delete") {
+		handler.DeleteFunc = func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			t.handleObject(trigger, "delete", nil, obj)
+		}
+	}
+
+	_, err = informer.AddEventHandler(handler)
+	if err != nil {
+		return err
+	}
+
+	go informer.Run(ctx.Done())
+	return nil
+}
+
+// handleObject runs a trigger on a change of a Kubernetes object, once whatever the number of replicas.
+// The event data has the object, and the previous one on updates, without their managed fields.
+func (t *TriggerServiceImpl) handleObject(trigger models.Trigger, eventType string, oldObj interface{}, obj interface{}) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	old, _ := oldObj.(*unstructured.Unstructured)
+	if old != nil && old.GetResourceVersion() == object.GetResourceVersion() {
+		return
+	}
+
+	id := fmt.Sprintf("%s/%s/%s", eventType, object.GetUID(), object.GetResourceVersion())
+	res := t.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.TriggerEvent{
+		Trigger: trigger.ID,
+		EventID: id,
+	})
+	if res.Error != nil {
+		log.Printf("Trigger %s failed to record event %s: %v\n", trigger.Name, id, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"type":   eventType,
+		"object": kubernetesObject(object),
+	}
+	if old != nil {
+		data["old_object"] = kubernetesObject(old)
+	}
+
+	subject := object.GetName()
+	if object.GetNamespace() != "" {
+		subject = object.GetNamespace() + "/" + subject
+	}
+
+	t.handleMessage(trigger, models.CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          "kubernetes://" + trigger.Resource,
+		Type:            eventTypePrefix + "kubernetes." + eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	})
+}
+
+// kubernetesObject returns a copy of an object from the informer cache without its managed fields,
+// which are rarely useful to tasks and often most of the object.
+func kubernetesObject(object *unstructured.Unstructured) map[string]interface{} {
+	object = object.DeepCopy()
+	unstructured.RemoveNestedField(object.Object, "metadata", "managedFields")
+	return object.Object
+}
+
+// busEvent reads a CloudEvent from a bus message. Other messages are wrapped in an event without a type,
+// so triggers without types consume any message.
+func busEvent(source string, header func(string) string, prefix string, contentType string,
+	body []byte) (models.CloudEvent, error) {
+	event, err := parseCloudEvent(header, prefix, contentType, body)
+	if err == nil || header(prefix+"specversion") != "" || strings.Contains(string(body), `"specversion"`) {
+		return event, err
+	}
+
+	event = models.CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.NewV4().String(),
+		Source:          source,
+		Time:            time.Now().UTC(),
+		DataContentType: contentType,
+	}
+	if len(body) > 0 {
+		event.Data = eventData(body)
+	}
+	return event, nil
+}
+
+func triggerGroup(trigger models.Trigger) string {
+	if trigger.Group != "" {
+		return trigger.Group
+	}
+	return "kriten-" + trigger.Name
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// eventRecorder records the events handled by trigger consumers.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []models.CloudEvent
+}
+
+func (r *eventRecorder) handle(_ models.Trigger, event models.CloudEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func (r *eventRecorder) wait(t *testing.T, n int) []models.CloudEvent {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d events, received %d", n, r.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.CloudEvent(nil), r.events...)
+}
+
+func runNATSServer(t *testing.T) *natsserver.Server {
+	t.Helper()
+
+	opts := natstest.DefaultTestOptions
+	opts.Port = natsserver.RANDOM_PORT
+	server := natstest.RunServer(&opts)
+	t.Cleanup(server.Shutdown)
+	return server
+}
+
+func connectNATS(t *testing.T, server *natsserver.Server) *nats.Conn {
+	t.Helper()
+
+	conn, err := nats.Connect(server.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func natsTrigger(server *natsserver.Server) models.Trigger {
+	return models.Trigger{
+		Name:    "orders",
+		Source:  TriggerNATS,
+		Servers: []string{server.ClientURL()},
+		Subject: "orders.>",
+	}
+}
+
+func TestConsumeNATS(t *testing.T) {
+	server := runNATSServer(t)
+	conn := connectNATS(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &eventRecorder{}
+	if err := consumeNATS(ctx, natsTrigger(server), recorder.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	structured := &nats.Msg{
+		Subject: "orders.created",
+		Header:  nats.Header{"Content-Type": []string{"application/cloudevents+json"}},
+		Data: []byte(`{"specversion": "1.0", "id": "1", "source": "/shop", "type": "order.created",
+			"data": {"order": 42}}`),
+	}
+	binary := &nats.Msg{
+		Subject: "orders.paid",
+		Header: nats.Header{
+			"Content-Type":   []string{"application/json"},
+			"ce-specversion": []string{"1.0"},
+			"ce-id":          []string{"2"},
+			"ce-source":      []string{"/shop"},
+			"ce-type":        []string{"order.paid"},
+		},
+		Data: []byte(`{"order": 42}`),
+	}
+	plain := &nats.Msg{Subject: "orders.shipped", Data: []byte(`{"order": 42}`)}
+	invalid := &nats.Msg{Subject: "orders.cancelled", Data: []byte(`{"specversion": "0.3"}`)}
+
+	for _, msg := range []*nats.Msg{structured, binary, invalid, plain} {
+		if err := conn.PublishMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := recorder.wait(t, 3)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, received %d", len(events))
+	}
+	if events[0].ID != "1" || events[0].Type != "order.created" {
+		t.Errorf("unexpected structured event %+v", events[0])
+	}
+	if events[1].ID != "2" || events[1].Type != "order.paid" || events[1].DataContentType != "application/json" {
+		t.Errorf("unexpected binary event %+v", events[1])
+	}
+	if events[2].Type != "" || events[2].Source != "nats://orders.shipped" || events[2].ID == "" {
+		t.Errorf("unexpected plain event %+v", events[2])
+	}
+	for _, event := range events {
+		data, ok := event.Data.(map[string]interface{})
+		if !ok || fmt.Sprint(data["order"]) != "42" {
+			t.Errorf("unexpected data %v of event %s", event.Data, event.ID)
+		}
+	}
+}
+
+func TestConsumeNATSQueueGroup(t *testing.T) {
+	server := runNATSServer(t)
+	conn := connectNATS(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Consumers of the same trigger on two replicas
+	recorder := &eventRecorder{}
+	for i := 0; i < 2; i++ {
+		if err := consumeNATS(ctx, natsTrigger(server), recorder.handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := conn.Publish("orders.created", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder.wait(t, 20)
+	time.Sleep(200 * time.Millisecond)
+	if recorder.count() != 20 {
+		t.Errorf("expected every message to be handled once, %d events for 20 messages", recorder.count())
+	}
+}
+
+func TestConsumeNATSStop(t *testing.T) {
+	server := runNATSServer(t)
+	conn := connectNATS(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &eventRecorder{}
+	if err := consumeNATS(ctx, natsTrigger(server), recorder.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Publish("orders.created", []byte(`{}`))
+	recorder.wait(t, 1)
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.NumClients() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("consumer connection not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn.Publish("orders.created", []byte(`{}`))
+	conn.Flush()
+	time.Sleep(200 * time.Millisecond)
+	if recorder.count() != 1 {
+		t.Errorf("expected no event once stopped, received %d", recorder.count()-1)
+	}
+}
+
+func TestConsumeNATSUnreachable(t *testing.T) {
+	trigger := models.Trigger{Name: "orders", Servers: []string{"nats://127.0.0.1:1"}, Subject: "orders.>"}
+	if err := consumeNATS(context.Background(), trigger, (&eventRecorder{}).handle); err == nil {
+		t.Error("expected an error on an unreachable server")
+	}
+}
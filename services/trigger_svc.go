@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/helpers"
+	"github.com/kriten-io/kriten/models"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	TriggerHTTP       = "http"
+	TriggerNATS       = "nats"
+	TriggerKafka      = "kafka"
+	TriggerKubernetes = "kubernetes"
+)
+
+var triggerSources = []string{TriggerHTTP, TriggerNATS, TriggerKafka, TriggerKubernetes}
+
+// Kubernetes events matched by the types of kubernetes triggers
+var kubernetesEventTypes = []string{"add", "update", "delete"}
+
+type TriggerService interface {
+	ListTriggers([]string) ([]models.Trigger, error)
+	GetTrigger(string) (models.Trigger, error)
+	CreateTrigger(models.Trigger) (models.Trigger, error)
+	UpdateTrigger(models.Trigger) (models.Trigger, error)
+	DeleteTrigger(string) error
+	VerifyTrigger(string, http.Header, []byte) (models.User, models.Trigger, error)
+	ReceiveEvent(models.Trigger, models.User, http.Header, []byte) (models.Job, bool, error)
+	SyncConsumers() error
+}
+
+// TriggerEventError is returned when an event isn't valid or can't be filtered or mapped into the task inputs.
+type TriggerEventError struct {
+	Err error
+}
+
+func (e *TriggerEventError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TriggerEventError) Unwrap() error {
+	return e.Err
+}
+
+// TriggerOwnerError is returned when the trigger owner isn't allowed to run the trigger task.
+type TriggerOwnerError struct {
+	Owner string
+	Task  string
+}
+
+func (e *TriggerOwnerError) Error() string {
+	return fmt.Sprintf("trigger owner %s cannot run task %s", e.Owner, e.Task)
+}
+
+type TriggerServiceImpl struct {
+	JobService   JobService
+	AuthService  AuthService
+	AuditService AuditService
+	db           *gorm.DB
+	config       config.Config
+	// NATS, Kafka and Kubernetes consumers running on this replica, by trigger
+	consumers map[uuid.UUID]*triggerConsumer
+	mu        sync.Mutex
+}
+
+func NewTriggerService(database *gorm.DB, js JobService, as AuthService, als AuditService,
+	config config.Config) TriggerService {
+	return &TriggerServiceImpl{
+		JobService:   js,
+		AuthService:  as,
+		AuditService: als,
+		db:           database,
+		config:       config,
+		consumers:    map[uuid.UUID]*triggerConsumer{},
+	}
+}
+
+func (t *TriggerServiceImpl) ListTriggers(authList []string) ([]models.Trigger, error) {
+	var triggers []models.Trigger
+	var res *gorm.DB
+
+	if len(authList) == 0 {
+		return triggers, nil
+	} else if slices.Contains(authList, "*") {
+		res = t.db.Order("name").Find(&triggers)
+	} else {
+		res = t.db.Where("name IN ?", authList).Order("name").Find(&triggers)
+	}
+
+	return triggers, res.Error
+}
+
+func (t *TriggerServiceImpl) GetTrigger(name string) (models.Trigger, error) {
+	var trigger models.Trigger
+	res := t.db.Where("name = ?", name).Find(&trigger)
+	if res.Error != nil {
+		return models.Trigger{}, res.Error
+	}
+
+	if trigger.Name == "" {
+		return models.Trigger{}, fmt.Errorf("trigger %s not found, please check name", name)
+	}
+
+	return trigger, nil
+}
+
+// CreateTrigger creates a trigger, HTTP triggers get a random secret when it isn't set.
+func (t *TriggerServiceImpl) CreateTrigger(trigger models.Trigger) (models.Trigger, error) {
+	err := checkTrigger(trigger)
+	if err != nil {
+		return trigger, err
+	}
+
+	if trigger.Source == TriggerHTTP && trigger.Secret == "" {
+		trigger.Secret, err = GenerateToken(40)
+		if err != nil {
+			return trigger, err
+		}
+	}
+
+	res := t.db.Create(&trigger)
+	return trigger, res.Error
+}
+
+// UpdateTrigger replaces a trigger, its owner is kept and so is its secret when not set.
+// Consumers pick the changes up on the next sync.
+func (t *TriggerServiceImpl) UpdateTrigger(trigger models.Trigger) (models.Trigger, error) {
+	current, err := t.GetTrigger(trigger.Name)
+	if err != nil {
+		return trigger, err
+	}
+	trigger.ID = current.ID
+	trigger.Owner = current.Owner
+	trigger.CreatedAt = current.CreatedAt
+	if trigger.Secret == "" {
+		trigger.Secret = current.Secret
+	}
+
+	err = checkTrigger(trigger)
+	if err != nil {
+		return trigger, err
+	}
+
+	res := t.db.Save(&trigger)
+	if res.Error != nil {
+		return models.Trigger{}, res.Error
+	}
+
+	return t.GetTrigger(trigger.Name)
+}
+
+func (t *TriggerServiceImpl) DeleteTrigger(name string) error {
+	trigger, err := t.GetTrigger(name)
+	if err != nil {
+		return err
+	}
+
+	return t.db.Unscoped().Delete(&trigger).Error
+}
+
+// VerifyTrigger authenticates an HTTP event with the trigger secret, either as a bearer token
+// or as the HMAC-SHA256 of the body in X-Kriten-Signature, as signed by Kriten subscriptions.
+func (t *TriggerServiceImpl) VerifyTrigger(name string, header http.Header, body []byte) (models.User, models.Trigger, error) {
+	trigger, err := t.GetTrigger(name)
+	if err != nil || trigger.Source != TriggerHTTP {
+		return models.User{}, models.Trigger{}, errors.New("invalid trigger")
+	}
+	if trigger.Disable {
+		return models.User{}, trigger, errors.New("trigger disabled")
+	}
+
+	if signature := header.Get("X-Kriten-Signature"); signature != "" {
+		expected := computeHMAC(sha256.New, trigger.Secret, body)
+		err = compareSignature(signature, "sha256="+hex.EncodeToString(expected))
+	} else {
+		token, _ := strings.CutPrefix(header.Get("Authorization"), "Bearer ")
+		err = compareSignature(token, trigger.Secret)
+	}
+	if err != nil {
+		return models.User{}, trigger, err
+	}
+
+	owner, err := t.getOwner(trigger)
+	if err != nil {
+		return models.User{}, trigger, err
+	}
+
+	return owner, trigger, nil
+}
+
+// ReceiveEvent runs the trigger on a CloudEvent sent over HTTP, in the structured or the binary mode.
+func (t *TriggerServiceImpl) ReceiveEvent(trigger models.Trigger, owner models.User, header http.Header,
+	body []byte) (models.Job, bool, error) {
+	event, err := parseCloudEvent(header.Get, "Ce-", header.Get("Content-Type"), body)
+	if err != nil {
+		return models.Job{}, false, &TriggerEventError{Err: err}
+	}
+
+	return t.runTrigger(trigger, owner, event)
+}
+
+// runTrigger filters the event and maps it into the task inputs, then runs the task as the trigger owner.
+// It returns whether the task ran, events of other types and filtered out events don't run it.
+func (t *TriggerServiceImpl) runTrigger(trigger models.Trigger, owner models.User, event models.CloudEvent) (models.Job, bool, error) {
+	// Kubernetes informers only handle the types of their trigger
+	if trigger.Source != TriggerKubernetes && len(trigger.Types) > 0 && !subscribed(trigger.Types, event.Type) {
+		return models.Job{}, false, nil
+	}
+
+	// Roles granting the resource may have been removed since the trigger was created
+	if trigger.Source == TriggerKubernetes {
+		authorised, err := t.AuthService.IsAutorised(&models.Authorization{
+			UserID:     owner.ID,
+			Provider:   owner.Provider,
+			Resource:   "kubernetes",
+			ResourceID: trigger.Resource,
+			Access:     "read",
+		})
+		if err != nil {
+			return models.Job{}, false, err
+		}
+		if !authorised {
+			return models.Job{}, false, fmt.Errorf("trigger owner %s cannot watch %s", owner.Username, trigger.Resource)
+		}
+	}
+
+	authorised, err := t.AuthService.IsAutorised(&models.Authorization{
+		UserID:     owner.ID,
+		Provider:   owner.Provider,
+		Resource:   "jobs",
+		ResourceID: trigger.Task,
+		Access:     "write",
+	})
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	if !authorised {
+		return models.Job{}, false, &TriggerOwnerError{Owner: owner.Username, Task: trigger.Task}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	extraVars, run, err := mapPayload(trigger.Mapping, trigger.Filter, string(payload))
+	if err == nil && run && trigger.Mapping == "" {
+		extraVars, err = eventExtraVars(event)
+	}
+	if err != nil {
+		return models.Job{}, false, &TriggerEventError{Err: err}
+	}
+	if !run {
+		return models.Job{}, false, nil
+	}
+
+	job, err := t.JobService.CreateJob(owner.Username, trigger.Task, extraVars, false)
+	return job, true, err
+}
+
+// handleMessage runs a trigger on an event consumed from a bus. Runs are audited as the owner,
+// like the HTTP ones, but events filtered out aren't as busy subjects would flood the audit logs.
+func (t *TriggerServiceImpl) handleMessage(trigger models.Trigger, event models.CloudEvent) {
+	owner, err := t.getOwner(trigger)
+	run := true
+	if err == nil {
+		_, run, err = t.runTrigger(trigger, owner, event)
+	}
+	if err == nil && !run {
+		return
+	}
+
+	audit := models.AuditLog{
+		UserID:        owner.ID,
+		UserName:      owner.Username,
+		Provider:      owner.Provider,
+		EventType:     "run",
+		EventCategory: "triggers",
+		EventTarget:   trigger.Name,
+		Status:        "success",
+	}
+	if err != nil {
+		audit.Status = "error"
+		log.Printf("Trigger %s failed to run on event %s: %v\n", trigger.Name, event.ID, err)
+	}
+	t.AuditService.CreateAudit(audit)
+}
+
+func (t *TriggerServiceImpl) getOwner(trigger models.Trigger) (models.User, error) {
+	var user models.User
+	res := t.db.Where("user_id = ?", trigger.Owner).Find(&user)
+	if res.Error != nil {
+		return models.User{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.User{}, errors.New("trigger owner not found")
+	}
+
+	return user, nil
+}
+
+func checkTrigger(trigger models.Trigger) error {
+	switch trigger.Source {
+	case TriggerHTTP:
+	case TriggerNATS:
+		if len(trigger.Servers) == 0 || trigger.Subject == "" {
+			return errors.New("nats triggers need servers and a subject")
+		}
+	case TriggerKafka:
+		if len(trigger.Servers) == 0 || trigger.Topic == "" {
+			return errors.New("kafka triggers need servers and a topic")
+		}
+	case TriggerKubernetes:
+		if _, err := helpers.ParseGVR(trigger.Resource); err != nil {
+			return err
+		}
+		if _, err := labels.Parse(trigger.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label_selector: %w", err)
+		}
+		if _, err := fields.ParseSelector(trigger.FieldSelector); err != nil {
+			return fmt.Errorf("invalid field_selector: %w", err)
+		}
+		for _, eventType := range trigger.Types {
+			if !slices.Contains(kubernetesEventTypes, eventType) {
+				return fmt.Errorf("invalid type %s, allowed: %s", eventType, strings.Join(kubernetesEventTypes, ", "))
+			}
+		}
+	default:
+		return fmt.Errorf("invalid trigger source %s, allowed: %s", trigger.Source, strings.Join(triggerSources, ", "))
+	}
+
+	_, _, err := parsePayloadTemplates(trigger.Mapping, trigger.Filter)
+	return err
+}
+
+// parseCloudEvent reads a CloudEvent in the binary mode, where attributes are headers with the given prefix
+// and the body is the data, or in the structured mode, where the body is the JSON encoded event.
+func parseCloudEvent(header func(string) string, prefix string, contentType string,
+	body []byte) (models.CloudEvent, error) {
+	var event models.CloudEvent
+
+	if header(prefix+"specversion") != "" {
+		event = models.CloudEvent{
+			SpecVersion:     header(prefix + "specversion"),
+			ID:              header(prefix + "id"),
+			Source:          header(prefix + "source"),
+			Type:            header(prefix + "type"),
+			Subject:         header(prefix + "subject"),
+			DataContentType: contentType,
+		}
+		if value := header(prefix + "time"); value != "" {
+			eventTime, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return event, fmt.Errorf("invalid event time: %w", err)
+			}
+			event.Time = eventTime
+		}
+		if len(body) > 0 {
+			event.Data = eventData(body)
+		}
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(string(body)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&event); err != nil {
+			return event, fmt.Errorf("invalid CloudEvent: %w", err)
+		}
+	}
+
+	if event.SpecVersion != "1.0" {
+		return event, fmt.Errorf("unsupported CloudEvents specversion %q, 1.0 is expected", event.SpecVersion)
+	}
+	if event.ID == "" || event.Source == "" || event.Type == "" {
+		return event, errors.New("invalid CloudEvent, id, source and type are required")
+	}
+
+	return event, nil
+}
+
+// eventData decodes JSON event data, other data is kept as a string.
+func eventData(body []byte) interface{} {
+	var data interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	if decoder.Decode(&data) != nil {
+		return string(body)
+	}
+	return data
+}
+
+// eventExtraVars returns the event data as the task extra vars, data other than an object is set as "data".
+func eventExtraVars(event models.CloudEvent) (string, error) {
+	extraVars := event.Data
+	if _, ok := extraVars.(map[string]interface{}); !ok {
+		extraVars = map[string]interface{}{"data": event.Data}
+	}
+
+	data, err := json.Marshal(extraVars)
+	return string(data), err
+}
+
+// triggerConsumer is a NATS, Kafka or Kubernetes consumer, restarted when its trigger is updated.
+type triggerConsumer struct {
+	updatedAt time.Time
+	cancel    context.CancelFunc
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+var webhookTemplateFuncs = template.FuncMap{
+	"toJson": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// payloadMapping is a mapping parsed from its JSON object. Keys are kept as they are, string values holding
+// template actions are replaced by a mappingValue, so payload values can't change the structure of the extra vars.
+type payloadMapping struct {
+	document map[string]any
+}
+
+// mappingValue is a template rendering a value of the mapping. A value made of a single action,
+// e.g. "{{ .data.count }}", renders its result as JSON and keeps its type, others render a string.
+type mappingValue struct {
+	template *template.Template
+	json     bool
+}
+
+// parsePayloadTemplates parses the mapping and filter of a webhook or trigger. The filter is a Go template
+// executed against the decoded payload, the mapping a JSON object whose string values can be Go templates.
+// Missing keys are an error in the mapping only, so a filter on a field that isn't sent simply doesn't match.
+func parsePayloadTemplates(mappingText string, filterText string) (mapping *payloadMapping, filter *template.Template, err error) {
+	if mappingText != "" {
+		mapping, err = parseMapping(mappingText)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid mapping: %w", err)
+		}
+	}
+	if filterText != "" {
+		filter, err = template.New("filter").Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(filterText)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	return mapping, filter, nil
+}
+
+func parseMapping(text string) (*payloadMapping, error) {
+	var document map[string]any
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("mapping must be a JSON object: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("mapping must be a single JSON object")
+	}
+
+	for key, value := range document {
+		parsed, err := parseMappingValue(key, value)
+		if err != nil {
+			return nil, err
+		}
+		document[key] = parsed
+	}
+
+	return &payloadMapping{document: document}, nil
+}
+
+// parseMappingValue parses the templates of a mapping value, they're named after its path, e.g. "device.tags.0".
+func parseMappingValue(path string, value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			parsed, err := parseMappingValue(path+"."+key, item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = parsed
+		}
+	case []any:
+		for i, item := range v {
+			parsed, err := parseMappingValue(fmt.Sprintf("%s.%d", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = parsed
+		}
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		tmpl, err := template.New(path).Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		return &mappingValue{template: tmpl, json: renderJSON(tmpl)}, nil
+	}
+
+	return value, nil
+}
+
+// renderJSON pipes the single action of a template to toJson, unless it's already done.
+// It returns false when the template has anything else than the action.
+func renderJSON(tmpl *template.Template) bool {
+	nodes := tmpl.Tree.Root.Nodes
+	if len(nodes) != 1 {
+		return false
+	}
+	action, ok := nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) != 0 {
+		return false
+	}
+
+	last := action.Pipe.Cmds[len(action.Pipe.Cmds)-1]
+	if ident, ok := last.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "toJson" {
+		return true
+	}
+
+	toJson := parse.NewIdentifier("toJson").SetTree(tmpl.Tree).SetPos(action.Pos)
+	action.Pipe.Cmds = append(action.Pipe.Cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      action.Pos,
+		Args:     []parse.Node{toJson},
+	})
+	return true
+}
+
+// render executes the templates of the mapping against the decoded payload.
+func (m *payloadMapping) render(data any) (map[string]any, error) {
+	rendered, err := renderMappingValue(m.document, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return rendered.(map[string]any), nil
+}
+
+func renderMappingValue(value any, data any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		rendered := make(map[string]any, len(v))
+		for key, item := range v {
+			var err error
+			rendered[key], err = renderMappingValue(item, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case []any:
+		rendered := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			rendered[i], err = renderMappingValue(item, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case *mappingValue:
+		var out bytes.Buffer
+		if err := v.template.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		if !v.json {
+			return out.String(), nil
+		}
+
+		var result any
+		decoder := json.NewDecoder(&out)
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("%s didn't render JSON: %w", v.template.Name(), err)
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
+
+// mapPayload applies a filter and a mapping to a JSON payload, returning
+// the task extra vars and whether the task should run at all.
+func mapPayload(mappingText string, filterText string, payload string) (string, bool, error) {
+	if mappingText == "" && filterText == "" {
+		return payload, true, nil
+	}
+
+	mapping, filter, err := parsePayloadTemplates(mappingText, filterText)
+	if err != nil {
+		return "", false, err
+	}
+
+	var data any
+	if strings.TrimSpace(payload) != "" {
+		decoder := json.NewDecoder(strings.NewReader(payload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			return "", false, fmt.Errorf("payload is not valid JSON: %w", err)
+		}
+	}
+
+	if filter != nil {
+		var out bytes.Buffer
+		if err := filter.Execute(&out, data); err != nil {
+			return "", false, fmt.Errorf("filter failed: %w", err)
+		}
+		run, err := strconv.ParseBool(strings.TrimSpace(out.String()))
+		if err != nil {
+			return "", false, fmt.Errorf("filter must render true or false, got %q", out.String())
+		}
+		if !run {
+			return "", false, nil
+		}
+	}
+
+	if mapping == nil {
+		return payload, true, nil
+	}
+
+	extraVars, err := mapping.render(data)
+	if err != nil {
+		return "", false, fmt.Errorf("mapping failed: %w", err)
+	}
+
+	out, err := json.Marshal(extraVars)
+	if err != nil {
+		return "", false, fmt.Errorf("mapping failed: %w", err)
+	}
+
+	return string(out), true, nil
+}
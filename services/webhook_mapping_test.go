@@ -0,0 +1,110 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMappingPayload = `{
+	"event": "node.create",
+	"data": {"name": "leaf1\", \"admin\": true, \"x\": \"", "count": 3, "enabled": false, "tags": ["core", "dc1"]}
+}`
+
+func TestMapPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		mapping  string
+		expected map[string]any
+	}{
+		{
+			"value types are kept",
+			`{"count": "{{ .data.count }}", "enabled": "{{ .data.enabled }}", "tags": "{{ .data.tags }}"}`,
+			map[string]any{"count": json.Number("3"), "enabled": false, "tags": []any{"core", "dc1"}},
+		},
+		{
+			"text is rendered as a string",
+			`{"summary": "{{ .event }} with {{ .data.count }} items", "static": 1}`,
+			map[string]any{"summary": "node.create with 3 items", "static": json.Number("1")},
+		},
+		{
+			"nested values and explicit toJson",
+			`{"device": {"tags": ["{{ index .data.tags 0 }}", "fixed"], "count": "{{ .data.count | toJson }}"}}`,
+			map[string]any{"device": map[string]any{"tags": []any{"core", "fixed"}, "count": json.Number("3")}},
+		},
+		{
+			"payload values can't inject keys",
+			`{"hostname": "{{ .data.name }}", "label": "host {{ .data.name }}"}`,
+			map[string]any{
+				"hostname": `leaf1", "admin": true, "x": "`,
+				"label":    `host leaf1", "admin": true, "x": "`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		extraVars, run, err := mapPayload(test.mapping, "", testMappingPayload)
+		if err != nil || !run {
+			t.Errorf("%s: unexpected result %v, %v", test.name, run, err)
+			continue
+		}
+
+		var rendered map[string]any
+		decoder := json.NewDecoder(strings.NewReader(extraVars))
+		decoder.UseNumber()
+		if err := decoder.Decode(&rendered); err != nil {
+			t.Errorf("%s: extra vars aren't a JSON object: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rendered, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, rendered)
+		}
+	}
+}
+
+func TestMapPayloadFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		run    bool
+		valid  bool
+	}{
+		{`{{ eq .event "node.create" }}`, true, true},
+		{`{{ eq .event "node.delete" }}`, false, true},
+		// Missing keys don't match instead of failing
+		{`{{ eq (print .data.missing) "x" }}`, false, true},
+		{`{{ .event }}`, false, false},
+		{`{{ .event`, false, false},
+	}
+
+	for _, test := range tests {
+		extraVars, run, err := mapPayload("", test.filter, testMappingPayload)
+		if (err == nil) != test.valid || run != test.run {
+			t.Errorf("%s: expected run %v and valid %v, got %v, %v", test.filter, test.run, test.valid, run, err)
+		}
+		if run && extraVars != testMappingPayload {
+			t.Errorf("%s: payload not passed through without a mapping", test.filter)
+		}
+	}
+}
+
+func TestMapPayloadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		payload string
+	}{
+		{"mapping isn't JSON", `hostname: "{{ .data.name }}"`, testMappingPayload},
+		{"mapping isn't an object", `["{{ .data.name }}"]`, testMappingPayload},
+		{"several objects", `{"a": 1} {"b": 2}`, testMappingPayload},
+		{"invalid template", `{"hostname": "{{ .data.name "}`, testMappingPayload},
+		{"missing key", `{"site": "{{ .data.site }}"}`, testMappingPayload},
+		{"payload isn't JSON", `{"hostname": "{{ .data.name }}"}`, `name=leaf1`},
+	}
+
+	for _, test := range tests {
+		if _, run, err := mapPayload(test.mapping, "", test.payload); err == nil || run {
+			t.Errorf("%s: expected an error, got run %v", test.name, run)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	SignatureInfrahub = "infrahub"
+	SignatureNetbox   = "netbox" // X-Hook-Signature, also used by Nautobot
+	SignatureGitHub   = "github"
+	SignatureGitLab   = "gitlab"
+	SignatureGitea    = "gitea"
+	SignatureSlack    = "slack"
+	SignatureHMAC     = "hmac"
+)
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// WebhookReplayError is returned when a message was already delivered to a webhook.
+type WebhookReplayError struct {
+	Webhook string
+	Message string
+}
+
+func (e *WebhookReplayError) Error() string {
+	return fmt.Sprintf("message %s was already delivered to webhook %s", e.Message, e.Webhook)
+}
+
+// signatureVerifier checks a webhook request against the webhook secret.
+type signatureVerifier func(webhook *models.Webhook, header http.Header, body []byte) error
+
+var signatureVerifiers = map[string]signatureVerifier{
+	SignatureInfrahub: verifyInfrahub,
+	SignatureNetbox:   verifyNetbox,
+	SignatureGitHub:   verifyGitHub,
+	SignatureGitLab:   verifyGitLab,
+	SignatureGitea:    verifyGitea,
+	SignatureSlack:    verifySlack,
+	SignatureHMAC:     verifyHMAC,
+}
+
+// signatureMessage names the headers identifying a delivery, used to reject replayed requests.
+// ID is the delivery ID header, required from the senders always sending one. Key is a signed header
+// identifying the message, messages are identified by their body otherwise, as unsigned headers can
+// be changed by whoever replays a request. Timestamps are signed by the schemes that send them.
+type signatureMessage struct {
+	ID        string
+	Key       string
+	Timestamp string
+}
+
+// Schemes not listed are deduplicated by body, e.g. netbox and hmac
+var signatureMessages = map[string]signatureMessage{
+	SignatureInfrahub: {ID: "webhook-id", Key: "webhook-id", Timestamp: "webhook-timestamp"},
+	// Slack doesn't send a delivery ID, the signature covers both the timestamp and the body
+	SignatureSlack:  {Key: "X-Slack-Signature", Timestamp: "X-Slack-Request-Timestamp"},
+	SignatureGitHub: {ID: "X-GitHub-Delivery"},
+	SignatureGitLab: {ID: "X-Gitlab-Event-UUID"},
+	SignatureGitea:  {ID: "X-Gitea-Delivery"},
+}
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+var signatureEncodings = map[string]func([]byte) string{
+	"hex":    hex.EncodeToString,
+	"base64": base64.StdEncoding.EncodeToString,
+}
+
+// signatureScheme returns the webhook scheme, when it isn't set the scheme is
+// detected from the headers supported before schemes were introduced.
+func signatureScheme(webhook *models.Webhook, header http.Header) string {
+	if webhook.SignatureScheme != "" {
+		return webhook.SignatureScheme
+	}
+	if header.Get("webhook-signature") != "" {
+		return SignatureInfrahub
+	}
+	return SignatureNetbox
+}
+
+func verifySignature(webhook *models.Webhook, header http.Header, body []byte) error {
+	scheme := signatureScheme(webhook, header)
+	verify, ok := signatureVerifiers[scheme]
+	if !ok {
+		return fmt.Errorf("unknown signature scheme %s", scheme)
+	}
+
+	return verify(webhook, header, body)
+}
+
+func checkSignatureScheme(webhook models.Webhook) error {
+	if webhook.SignatureScheme == "" {
+		return nil
+	}
+	if _, ok := signatureVerifiers[webhook.SignatureScheme]; !ok {
+		var schemes []string
+		for scheme := range signatureVerifiers {
+			schemes = append(schemes, scheme)
+		}
+		slices.Sort(schemes)
+		return fmt.Errorf("invalid signature scheme %s, allowed: %s",
+			webhook.SignatureScheme, strings.Join(schemes, ", "))
+	}
+	if webhook.SignatureScheme != SignatureHMAC {
+		return nil
+	}
+
+	if webhook.SignatureHeader == "" {
+		return errors.New("the hmac signature scheme needs a signature_header")
+	}
+	if _, ok := signatureAlgorithms[webhook.SignatureAlgorithm]; webhook.SignatureAlgorithm != "" && !ok {
+		return fmt.Errorf("invalid signature algorithm %s, allowed: sha1, sha256, sha512", webhook.SignatureAlgorithm)
+	}
+	if _, ok := signatureEncodings[webhook.SignatureEncoding]; webhook.SignatureEncoding != "" && !ok {
+		return fmt.Errorf("invalid signature encoding %s, allowed: hex, base64", webhook.SignatureEncoding)
+	}
+
+	return nil
+}
+
+func computeHMAC(algorithm func() hash.Hash, secret string, data ...[]byte) []byte {
+	h := hmac.New(algorithm, []byte(secret))
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func compareSignature(signature string, expected string) error {
+	if signature == "" || !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func checkSignatureTimestamp(timestamp string, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if math.Abs(time.Since(time.Unix(seconds, 0)).Seconds()) > tolerance.Seconds() {
+		return errors.New("signature timestamp out of tolerance")
+	}
+	return nil
+}
+
+// verifyInfrahub checks Opsmill Infrahub signatures: "v1," followed by the base64
+// HMAC-SHA256 of the message ID, timestamp and body.
+func verifyInfrahub(webhook *models.Webhook, header http.Header, body []byte) error {
+	msgID := header.Get("webhook-id")
+	timestamp := header.Get("webhook-timestamp")
+	if msgID == "" || timestamp == "" {
+		return errInvalidSignature
+	}
+
+	signature, ok := strings.CutPrefix(header.Get("webhook-signature"), "v1,")
+	if !ok {
+		return errInvalidSignature
+	}
+
+	data := []byte(fmt.Sprintf("%s.%s.", msgID, timestamp))
+	expected := computeHMAC(sha256.New, webhook.Secret, data, body)
+	return compareSignature(signature, base64.StdEncoding.EncodeToString(expected))
+}
+
+func verifyNetbox(webhook *models.Webhook, header http.Header, body []byte) error {
+	expected := computeHMAC(sha512.New, webhook.Secret, body)
+	return compareSignature(header.Get("X-Hook-Signature"), hex.EncodeToString(expected))
+}
+
+func verifyGitHub(webhook *models.Webhook, header http.Header, body []byte) error {
+	expected := computeHMAC(sha256.New, webhook.Secret, body)
+	return compareSignature(header.Get("X-Hub-Signature-256"), "sha256="+hex.EncodeToString(expected))
+}
+
+// verifyGitLab compares the secret token, GitLab doesn't sign the body.
+func verifyGitLab(webhook *models.Webhook, header http.Header, _ []byte) error {
+	return compareSignature(header.Get("X-Gitlab-Token"), webhook.Secret)
+}
+
+func verifyGitea(webhook *models.Webhook, header http.Header, body []byte) error {
+	expected := computeHMAC(sha256.New, webhook.Secret, body)
+	return compareSignature(header.Get("X-Gitea-Signature"), hex.EncodeToString(expected))
+}
+
+// verifySlack checks Slack signing secrets, the timestamp is signed along with the body.
+func verifySlack(webhook *models.Webhook, header http.Header, body []byte) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	if timestamp == "" {
+		return errInvalidSignature
+	}
+
+	expected := computeHMAC(sha256.New, webhook.Secret, []byte("v0:"+timestamp+":"), body)
+	return compareSignature(header.Get("X-Slack-Signature"), "v0="+hex.EncodeToString(expected))
+}
+
+// verifyHMAC checks the body HMAC in a configurable header, defaults are sha256 and hex.
+func verifyHMAC(webhook *models.Webhook, header http.Header, body []byte) error {
+	algorithm, ok := signatureAlgorithms[webhook.SignatureAlgorithm]
+	if !ok {
+		algorithm = sha256.New
+	}
+	encode, ok := signatureEncodings[webhook.SignatureEncoding]
+	if !ok {
+		encode = hex.EncodeToString
+	}
+
+	expected := computeHMAC(algorithm, webhook.Secret, body)
+	return compareSignature(header.Get(webhook.SignatureHeader), webhook.SignaturePrefix+encode(expected))
+}
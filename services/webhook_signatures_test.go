@@ -0,0 +1,176 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/models"
+)
+
+const testWebhookSecret = "s3cret"
+
+var testWebhookBody = []byte(`{"event": "push", "ref": "refs/heads/main"}`)
+
+func sign(algorithm func() hash.Hash, secret string, data ...[]byte) []byte {
+	h := hmac.New(algorithm, []byte(secret))
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func headers(values ...string) http.Header {
+	header := http.Header{}
+	for i := 0; i < len(values); i += 2 {
+		header.Set(values[i], values[i+1])
+	}
+	return header
+}
+
+// signedHeaders returns the headers a sender of each scheme sends with testWebhookBody.
+func signedHeaders(scheme string, secret string, timestamp string) http.Header {
+	switch scheme {
+	case SignatureInfrahub:
+		signature := sign(sha256.New, secret, []byte("msg_1."+timestamp+"."), testWebhookBody)
+		return headers("webhook-id", "msg_1", "webhook-timestamp", timestamp,
+			"webhook-signature", "v1,"+base64.StdEncoding.EncodeToString(signature))
+	case SignatureNetbox:
+		return headers("X-Hook-Signature", hex.EncodeToString(sign(sha512.New, secret, testWebhookBody)))
+	case SignatureGitHub:
+		return headers("X-GitHub-Delivery", "72d3162e", "X-Hub-Signature-256",
+			"sha256="+hex.EncodeToString(sign(sha256.New, secret, testWebhookBody)))
+	case SignatureGitLab:
+		return headers("X-Gitlab-Event-UUID", "13792a34", "X-Gitlab-Token", secret)
+	case SignatureGitea:
+		return headers("X-Gitea-Delivery", "a8e9b2c1", "X-Gitea-Signature",
+			hex.EncodeToString(sign(sha256.New, secret, testWebhookBody)))
+	case SignatureSlack:
+		signature := sign(sha256.New, secret, []byte("v0:"+timestamp+":"), testWebhookBody)
+		return headers("X-Slack-Request-Timestamp", timestamp, "X-Slack-Signature", "v0="+hex.EncodeToString(signature))
+	case SignatureHMAC:
+		return headers("X-Signature", hex.EncodeToString(sign(sha256.New, secret, testWebhookBody)))
+	}
+	return nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for _, scheme := range []string{
+		SignatureInfrahub, SignatureNetbox, SignatureGitHub, SignatureGitLab, SignatureGitea, SignatureSlack, SignatureHMAC,
+	} {
+		webhook := &models.Webhook{Secret: testWebhookSecret, SignatureScheme: scheme, SignatureHeader: "X-Signature"}
+
+		if err := verifySignature(webhook, signedHeaders(scheme, testWebhookSecret, now), testWebhookBody); err != nil {
+			t.Errorf("%s: valid signature rejected: %v", scheme, err)
+		}
+		if err := verifySignature(webhook, signedHeaders(scheme, "wrong", now), testWebhookBody); err == nil {
+			t.Errorf("%s: signature with another secret accepted", scheme)
+		}
+		if err := verifySignature(webhook, http.Header{}, testWebhookBody); err == nil {
+			t.Errorf("%s: request without signature accepted", scheme)
+		}
+		// GitLab only sends a token, the body isn't signed
+		tampered := []byte(`{"event": "push", "ref": "refs/heads/prod"}`)
+		if err := verifySignature(webhook, signedHeaders(scheme, testWebhookSecret, now), tampered); err == nil && scheme != SignatureGitLab {
+			t.Errorf("%s: signature of another body accepted", scheme)
+		}
+	}
+}
+
+func TestVerifySignatureTimestamp(t *testing.T) {
+	now := time.Now().Unix()
+
+	for _, scheme := range []string{SignatureInfrahub, SignatureSlack} {
+		webhook := &models.Webhook{Secret: testWebhookSecret, SignatureScheme: scheme}
+		header := signedHeaders(scheme, testWebhookSecret, strconv.FormatInt(now, 10))
+
+		// The timestamp is part of the signature, it can't be refreshed without the secret
+		timestampHeader := signatureMessages[scheme].Timestamp
+		header.Set(timestampHeader, strconv.FormatInt(now+60, 10))
+		if err := verifySignature(webhook, header, testWebhookBody); err == nil {
+			t.Errorf("%s: signature with a changed timestamp accepted", scheme)
+		}
+	}
+}
+
+func TestVerifyHMACSettings(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		encoding  string
+		prefix    string
+		signature string
+	}{
+		{"", "", "", hex.EncodeToString(sign(sha256.New, testWebhookSecret, testWebhookBody))},
+		{"sha1", "hex", "sha1=", "sha1=" + hex.EncodeToString(sign(sha1.New, testWebhookSecret, testWebhookBody))},
+		{"sha512", "base64", "", base64.StdEncoding.EncodeToString(sign(sha512.New, testWebhookSecret, testWebhookBody))},
+	}
+
+	for _, test := range tests {
+		webhook := &models.Webhook{
+			Secret:             testWebhookSecret,
+			SignatureScheme:    SignatureHMAC,
+			SignatureHeader:    "X-Signature",
+			SignatureAlgorithm: test.algorithm,
+			SignatureEncoding:  test.encoding,
+			SignaturePrefix:    test.prefix,
+		}
+		if err := verifySignature(webhook, headers("X-Signature", test.signature), testWebhookBody); err != nil {
+			t.Errorf("%s %s: valid signature rejected: %v", test.algorithm, test.encoding, err)
+		}
+		if err := verifySignature(webhook, headers("X-Other", test.signature), testWebhookBody); err == nil {
+			t.Errorf("%s %s: signature in another header accepted", test.algorithm, test.encoding)
+		}
+	}
+}
+
+func TestSignatureScheme(t *testing.T) {
+	webhook := &models.Webhook{}
+	if scheme := signatureScheme(webhook, headers("webhook-signature", "v1,abc")); scheme != SignatureInfrahub {
+		t.Errorf("expected infrahub to be detected, got %s", scheme)
+	}
+	if scheme := signatureScheme(webhook, headers("X-Hook-Signature", "abc")); scheme != SignatureNetbox {
+		t.Errorf("expected netbox by default, got %s", scheme)
+	}
+	// A configured scheme isn't switched by the headers sent
+	webhook.SignatureScheme = SignatureGitHub
+	if scheme := signatureScheme(webhook, headers("webhook-signature", "v1,abc")); scheme != SignatureGitHub {
+		t.Errorf("expected the configured scheme, got %s", scheme)
+	}
+
+	webhook.SignatureScheme = "unknown"
+	if err := verifySignature(webhook, http.Header{}, testWebhookBody); err == nil {
+		t.Error("unknown scheme accepted")
+	}
+}
+
+func TestCheckSignatureScheme(t *testing.T) {
+	tests := []struct {
+		webhook models.Webhook
+		valid   bool
+	}{
+		{models.Webhook{}, true},
+		{models.Webhook{SignatureScheme: SignatureGitHub}, true},
+		{models.Webhook{SignatureScheme: "bitbucket"}, false},
+		{models.Webhook{SignatureScheme: SignatureHMAC}, false},
+		{models.Webhook{SignatureScheme: SignatureHMAC, SignatureHeader: "X-Signature"}, true},
+		{models.Webhook{SignatureScheme: SignatureHMAC, SignatureHeader: "X-Signature", SignatureAlgorithm: "md5"}, false},
+		{models.Webhook{SignatureScheme: SignatureHMAC, SignatureHeader: "X-Signature", SignatureEncoding: "base32"}, false},
+	}
+
+	for _, test := range tests {
+		err := checkSignatureScheme(test.webhook)
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %v, got %v", test.webhook, test.valid, err)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"time"
 
 	"github.com/kriten-io/kriten/config"
 	"github.com/kriten-io/kriten/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/exp/slices"
@@ -18,25 +25,62 @@ type WebhookService interface {
 	ListAllWebhooks([]string) ([]models.Webhook, error)
 	GetWebhook(string) (models.Webhook, error)
 	CreateWebhook(models.Webhook) (models.Webhook, error)
+	UpdateWebhook(models.Webhook) (models.Webhook, error)
 	DeleteWebhook(string) error
+	RotateWebhookSecret(string, string, time.Duration) (models.Webhook, error)
+	VerifyWebhook(string, http.Header, []byte) (models.User, models.Webhook, string, error)
+	DeliverWebhook(models.Webhook, string, models.WebhookDelivery) (models.WebhookDelivery, models.Job, error)
+	ListWebhookDeliveries(string) ([]models.WebhookDelivery, error)
+	RedeliverWebhook(string, string) (models.WebhookDelivery, models.Job, error)
+}
+
+const (
+	DeliveryLaunched = "launched"
+	DeliveryFiltered = "filtered"
+	DeliveryFailed   = "failed"
+	DeliveryRejected = "rejected"
+)
+
+// Headers not recorded with deliveries, as they carry credentials
+var redactedDeliveryHeaders = []string{"Authorization", "Cookie", "Token", "X-Gitlab-Token"}
+
+// WebhookMappingError is returned when a payload can't be filtered or mapped into the task inputs.
+type WebhookMappingError struct {
+	Err error
+}
+
+func (e *WebhookMappingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WebhookMappingError) Unwrap() error {
+	return e.Err
+}
+
+var webhookColumns = []string{
+	"id", "owner", "secret", "description", "task",
+	"signature_scheme", "signature_header", "signature_algorithm", "signature_encoding", "signature_prefix",
+	"mapping", "filter", "previous_secret", "previous_secret_expires_at", "created_at", "updated_at",
 }
 
 type WebhookServiceImpl struct {
-	db     *gorm.DB
-	config config.Config
+	db         *gorm.DB
+	JobService JobService
+	config     config.Config
 }
 
-func NewWebhookService(database *gorm.DB, config config.Config) WebhookService {
+func NewWebhookService(database *gorm.DB, js JobService, config config.Config) WebhookService {
 	return &WebhookServiceImpl{
-		db:     database,
-		config: config,
+		db:         database,
+		JobService: js,
+		config:     config,
 	}
 }
 
 func (w *WebhookServiceImpl) ListWebhooks(userid uuid.UUID) ([]models.Webhook, error) {
 	var webHooks []models.Webhook
 
-	res := w.db.Select("id", "owner", "secret", "description", "task", "created_at", "updated_at").
+	res := w.db.Select(webhookColumns).
 		Where("owner = ?", userid).
 		Find(&webHooks)
 
@@ -50,7 +94,7 @@ func (w *WebhookServiceImpl) ListWebhooks(userid uuid.UUID) ([]models.Webhook, e
 func (w *WebhookServiceImpl) ListTaskWebhooks(taskName string) ([]models.Webhook, error) {
 	var webHooks []models.Webhook
 
-	res := w.db.Select("id", "owner", "secret", "description", "task", "created_at", "updated_at").
+	res := w.db.Select(webhookColumns).
 		Where("task = ?", taskName).
 		Find(&webHooks)
 
@@ -84,7 +128,7 @@ func (w *WebhookServiceImpl) ListAllWebhooks(authList []string) ([]models.Webhoo
 func (w *WebhookServiceImpl) GetWebhook(id string) (models.Webhook, error) {
 	var webHook models.Webhook
 
-	res := w.db.Select("id", "owner", "secret", "description", "task", "created_at", "updated_at").
+	res := w.db.Select(webhookColumns).
 		Where("id = ?", id).
 		Find(&webHook)
 
@@ -99,16 +143,311 @@ func (w *WebhookServiceImpl) GetWebhook(id string) (models.Webhook, error) {
 	return webHook, nil
 }
 
+// CreateWebhook creates a webhook, a random secret is generated when it isn't set.
+// The secret is only returned here and by rotations.
 func (w *WebhookServiceImpl) CreateWebhook(webHook models.Webhook) (models.Webhook, error) {
+	err := checkWebhook(webHook)
+	if err != nil {
+		return webHook, err
+	}
+
+	if webHook.Secret == "" {
+		webHook.Secret, err = GenerateToken(40)
+		if err != nil {
+			return webHook, err
+		}
+	}
+
 	res := w.db.Create(&webHook)
 
 	return webHook, res.Error
 }
 
+// UpdateWebhook updates the fields set, the result is checked before committing
+// as e.g. the hmac scheme settings can be spread between the current and new values.
+func (w *WebhookServiceImpl) UpdateWebhook(webHook models.Webhook) (models.Webhook, error) {
+	_, err := w.GetWebhook(webHook.ID.String())
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	err = w.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Updates(webHook).Error
+		if err != nil {
+			return err
+		}
+
+		var updated models.Webhook
+		err = tx.Select(webhookColumns).Where("id = ?", webHook.ID).Find(&updated).Error
+		if err != nil {
+			return err
+		}
+		return checkWebhook(updated)
+	})
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	return w.GetWebhook(webHook.ID.String())
+}
+
+// RotateWebhookSecret replaces the secret of a webhook, a random one is generated when it's empty.
+// The previous secret keeps verifying requests for the grace period, so senders can be updated.
+func (w *WebhookServiceImpl) RotateWebhookSecret(id string, secret string, grace time.Duration) (models.Webhook, error) {
+	webHook, err := w.GetWebhook(id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	if secret == "" {
+		secret, err = GenerateToken(40)
+		if err != nil {
+			return models.Webhook{}, err
+		}
+	}
+
+	rotated := models.Webhook{ID: webHook.ID, Secret: secret}
+	if grace > 0 {
+		expiresAt := time.Now().Add(grace)
+		rotated.PreviousSecret = webHook.Secret
+		rotated.PreviousSecretExpiresAt = &expiresAt
+	}
+
+	// updating from a struct, so the secrets go through the encrypted serializer
+	res := w.db.Model(&rotated).
+		Select("secret", "previous_secret", "previous_secret_expires_at").
+		Updates(rotated)
+	if res.Error != nil {
+		return models.Webhook{}, res.Error
+	}
+
+	return w.GetWebhook(id)
+}
+
 func (w *WebhookServiceImpl) DeleteWebhook(id string) error {
 	webHook, err := w.GetWebhook(id)
 	if err != nil {
 		return err
 	}
+	err = w.db.Where("webhook = ?", webHook.ID).Delete(&models.WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	err = w.db.Where("webhook_id = ?", webHook.ID).Delete(&models.WebhookMessage{}).Error
+	if err != nil {
+		return err
+	}
 	return w.db.Unscoped().Delete(&webHook).Error
 }
+
+// DeliverWebhook filters and maps the delivery payload into the webhook task inputs, then runs the task
+// as the given user. Every delivery is recorded, including the filtered and failed ones.
+func (w *WebhookServiceImpl) DeliverWebhook(webHook models.Webhook, username string,
+	delivery models.WebhookDelivery) (models.WebhookDelivery, models.Job, error) {
+	var job models.Job
+	delivery.ID = uuid.Nil
+	delivery.Webhook = webHook.ID
+	delivery.Headers = deliveryHeaders(delivery.Headers)
+
+	extraVars, run, err := mapPayload(webHook.Mapping, webHook.Filter, delivery.Payload)
+	switch {
+	case err != nil:
+		err = &WebhookMappingError{Err: err}
+	case !run:
+		delivery.Status = DeliveryFiltered
+	default:
+		delivery.ExtraVars = extraVars
+		job, err = w.JobService.CreateJob(username, webHook.Task, extraVars, false)
+	}
+
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	} else if run {
+		delivery.Status = DeliveryLaunched
+		delivery.JobID = job.ID
+	}
+
+	w.recordDelivery(&delivery)
+	return delivery, job, err
+}
+
+func (w *WebhookServiceImpl) ListWebhookDeliveries(id string) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	res := w.db.Where("webhook = ?", id).Order("created_at DESC").Find(&deliveries)
+	return deliveries, res.Error
+}
+
+// RedeliverWebhook delivers a recorded payload again, as the webhook owner.
+// The payload is mapped with the current webhook settings, so it can be used to retry after fixing them.
+func (w *WebhookServiceImpl) RedeliverWebhook(id string, deliveryID string) (models.WebhookDelivery, models.Job, error) {
+	webHook, err := w.GetWebhook(id)
+	if err != nil {
+		return models.WebhookDelivery{}, models.Job{}, err
+	}
+
+	var previous models.WebhookDelivery
+	res := w.db.Where("delivery_id = ? AND webhook = ?", deliveryID, id).Find(&previous)
+	if res.Error != nil {
+		return models.WebhookDelivery{}, models.Job{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.WebhookDelivery{}, models.Job{}, fmt.Errorf("delivery %s not found, please check uuid", deliveryID)
+	}
+	if previous.Status == DeliveryRejected {
+		return models.WebhookDelivery{}, models.Job{}, errors.New("rejected deliveries cannot be redelivered")
+	}
+
+	owner, err := w.getOwner(&webHook)
+	if err != nil {
+		return models.WebhookDelivery{}, models.Job{}, err
+	}
+
+	return w.DeliverWebhook(webHook, owner.Username, models.WebhookDelivery{
+		Headers:      previous.Headers,
+		Verification: "redelivered",
+		Payload:      previous.Payload,
+		RedeliveryOf: &previous.ID,
+	})
+}
+
+func (w *WebhookServiceImpl) recordDelivery(delivery *models.WebhookDelivery) {
+	res := w.db.Create(delivery)
+	if res.Error != nil {
+		log.Printf("Failed to record delivery of webhook %s: %v\n", delivery.Webhook, res.Error)
+	}
+}
+
+func deliveryHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedDeliveryHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "[redacted]")
+		}
+	}
+	return header
+}
+
+func checkWebhook(webHook models.Webhook) error {
+	err := checkSignatureScheme(webHook)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = parsePayloadTemplates(webHook.Mapping, webHook.Filter)
+	return err
+}
+
+// VerifyWebhook checks the request signature with the webhook scheme, returning the webhook, its owner
+// and how the request was verified. Rejected requests are recorded as deliveries.
+func (w *WebhookServiceImpl) VerifyWebhook(id string, header http.Header,
+	body []byte) (models.User, models.Webhook, string, error) {
+	webHook, err := w.GetWebhook(id)
+	if err != nil {
+		return models.User{}, models.Webhook{}, "", errors.New("invalid webhook")
+	}
+
+	verification, err := w.verifySecrets(&webHook, header, body)
+	if err == nil {
+		err = w.checkReplay(&webHook, header, body)
+	}
+	if err != nil {
+		w.recordDelivery(&models.WebhookDelivery{
+			Webhook:      webHook.ID,
+			Status:       DeliveryRejected,
+			Headers:      deliveryHeaders(header),
+			Verification: err.Error(),
+			Payload:      string(body),
+		})
+		return models.User{}, webHook, "", err
+	}
+
+	user, err := w.getOwner(&webHook)
+	if err != nil {
+		return models.User{}, webHook, "", err
+	}
+
+	return user, webHook, verification, nil
+}
+
+// verifySecrets verifies a request with the current secret, then with the previous one during a rotation.
+func (w *WebhookServiceImpl) verifySecrets(webHook *models.Webhook, header http.Header, body []byte) (string, error) {
+	err := verifySignature(webHook, header, body)
+	if err == nil {
+		return "verified", nil
+	}
+
+	if webHook.PreviousSecret == "" || webHook.PreviousSecretExpiresAt == nil ||
+		time.Now().After(*webHook.PreviousSecretExpiresAt) {
+		return "", err
+	}
+
+	previous := *webHook
+	previous.Secret = webHook.PreviousSecret
+	if verifySignature(&previous, header, body) != nil {
+		return "", err
+	}
+	return "verified with the previous secret", nil
+}
+
+func (w *WebhookServiceImpl) getOwner(webHook *models.Webhook) (models.User, error) {
+	var user models.User
+	res := w.db.Where("user_id = ?", webHook.Owner).Find(&user)
+	if res.Error != nil {
+		return models.User{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.User{}, errors.New("webhook owner not found")
+	}
+
+	return user, nil
+}
+
+// checkReplay rejects signed timestamps out of tolerance and messages already delivered, identified by
+// a signed header or else by their body. Messages are stored in the database so every replica sees them,
+// expired ones are cleaned up as new ones come in.
+func (w *WebhookServiceImpl) checkReplay(webHook *models.Webhook, header http.Header, body []byte) error {
+	message := signatureMessages[signatureScheme(webHook, header)]
+	if message.ID != "" && header.Get(message.ID) == "" {
+		return fmt.Errorf("missing %s header", message.ID)
+	}
+
+	if message.Timestamp != "" {
+		tolerance := time.Duration(w.config.WebhookTimestampTolerance) * time.Second
+		err := checkSignatureTimestamp(header.Get(message.Timestamp), tolerance)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.config.WebhookReplayWindow <= 0 {
+		return nil
+	}
+
+	messageID := header.Get(message.Key)
+	if message.Key == "" {
+		sum := sha256.Sum256(body)
+		messageID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	now := time.Now()
+	err := w.db.Where("expires_at < ?", now).Delete(&models.WebhookMessage{}).Error
+	if err != nil {
+		return err
+	}
+
+	res := w.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WebhookMessage{
+		WebhookID: webHook.ID,
+		MessageID: messageID,
+		ExpiresAt: now.Add(time.Duration(w.config.WebhookReplayWindow) * time.Second),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &WebhookReplayError{Webhook: webHook.ID.String(), Message: messageID}
+	}
+
+	return nil
+}
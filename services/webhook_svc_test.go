@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kriten-io/kriten/config"
+	"github.com/kriten-io/kriten/models"
+
+	"github.com/glebarez/sqlite"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB returns an in-memory database with the tables of the given models.
+func testDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	// Every connection would open its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	// Postgres column types and defaults are replaced by SQLite ones, schemas are cached per database
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "gen_random_uuid()" {
+				field.DefaultValue = "(lower(hex(randomblob(16))))"
+			}
+			if strings.HasSuffix(string(field.DataType), "[]") {
+				field.DataType = "text"
+			}
+		}
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testWebhookService(t *testing.T) *WebhookServiceImpl {
+	return &WebhookServiceImpl{
+		db:     testDB(t, &models.WebhookMessage{}),
+		config: config.Config{WebhookTimestampTolerance: 300, WebhookReplayWindow: 86400},
+	}
+}
+
+func TestCheckReplayTimestamp(t *testing.T) {
+	w := testWebhookService(t)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		valid     bool
+	}{
+		{"current", strconv.FormatInt(now, 10), true},
+		{"within tolerance", strconv.FormatInt(now-200, 10), true},
+		{"stale", strconv.FormatInt(now-600, 10), false},
+		{"future", strconv.FormatInt(now+600, 10), false},
+		{"invalid", "yesterday", false},
+		{"missing", "", false},
+	}
+
+	for _, scheme := range []string{SignatureInfrahub, SignatureSlack} {
+		webhook := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: scheme}
+		for _, test := range tests {
+			header := signedHeaders(scheme, testWebhookSecret, test.timestamp)
+			// Messages differ by their ID or signature, only the timestamp is checked
+			header.Set("webhook-id", "msg_"+test.name)
+			err := w.checkReplay(webhook, header, testWebhookBody)
+			if (err == nil) != test.valid {
+				t.Errorf("%s %s timestamp: expected valid %v, got %v", scheme, test.name, test.valid, err)
+			}
+		}
+	}
+}
+
+func TestCheckReplayMessageID(t *testing.T) {
+	w := testWebhookService(t)
+	webhook := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: SignatureInfrahub}
+	header := signedHeaders(SignatureInfrahub, testWebhookSecret, strconv.FormatInt(time.Now().Unix(), 10))
+
+	if err := w.checkReplay(webhook, header, testWebhookBody); err != nil {
+		t.Fatal(err)
+	}
+
+	var replayErr *WebhookReplayError
+	if err := w.checkReplay(webhook, header, testWebhookBody); !errors.As(err, &replayErr) {
+		t.Fatalf("expected a replayed message ID to be rejected, got %v", err)
+	}
+	if replayErr.Message != "msg_1" {
+		t.Errorf("unexpected replayed message %s", replayErr.Message)
+	}
+
+	// Messages are remembered per webhook
+	other := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: SignatureInfrahub}
+	if err := w.checkReplay(other, header, testWebhookBody); err != nil {
+		t.Errorf("message to another webhook rejected: %v", err)
+	}
+}
+
+func TestCheckReplaySlack(t *testing.T) {
+	w := testWebhookService(t)
+	webhook := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: SignatureSlack}
+	now := time.Now().Unix()
+
+	header := signedHeaders(SignatureSlack, testWebhookSecret, strconv.FormatInt(now, 10))
+	if err := w.checkReplay(webhook, header, testWebhookBody); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.checkReplay(webhook, header, testWebhookBody); err == nil {
+		t.Error("expected a replayed signature to be rejected")
+	}
+
+	// The same body is sent again with a new timestamp, so a new signature
+	header = signedHeaders(SignatureSlack, testWebhookSecret, strconv.FormatInt(now+1, 10))
+	if err := w.checkReplay(webhook, header, testWebhookBody); err != nil {
+		t.Errorf("new message with the same body rejected: %v", err)
+	}
+}
+
+func TestCheckReplayBody(t *testing.T) {
+	w := testWebhookService(t)
+	webhook := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: SignatureGitHub}
+
+	if err := w.checkReplay(webhook, http.Header{}, testWebhookBody); err == nil {
+		t.Error("expected a request without X-GitHub-Delivery to be rejected")
+	}
+
+	if err := w.checkReplay(webhook, headers("X-GitHub-Delivery", "1"), testWebhookBody); err != nil {
+		t.Fatal(err)
+	}
+	// Delivery IDs aren't signed, a replayed body with another one is still rejected
+	if err := w.checkReplay(webhook, headers("X-GitHub-Delivery", "2"), testWebhookBody); err == nil {
+		t.Error("expected a replayed body to be rejected")
+	}
+	if err := w.checkReplay(webhook, headers("X-GitHub-Delivery", "3"), []byte(`{"event": "push"}`)); err != nil {
+		t.Errorf("new body rejected: %v", err)
+	}
+}
+
+func TestCheckReplayExpired(t *testing.T) {
+	w := testWebhookService(t)
+	webhook := &models.Webhook{ID: uuid.NewV4(), SignatureScheme: SignatureNetbox}
+
+	if err := w.checkReplay(webhook, http.Header{}, testWebhookBody); err != nil {
+		t.Fatal(err)
+	}
+	w.db.Model(&models.WebhookMessage{}).Where("webhook_id = ?", webhook.ID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	if err := w.checkReplay(webhook, http.Header{}, testWebhookBody); err != nil {
+		t.Errorf("message accepted again once expired: %v", err)
+	}
+	var count int64
+	w.db.Model(&models.WebhookMessage{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected expired messages to be deleted, %d left", count)
+	}
+}
+
+func TestVerifySecrets(t *testing.T) {
+	w := &WebhookServiceImpl{}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	inGrace := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name         string
+		signedWith   string
+		previous     string
+		expiresAt    *time.Time
+		verification string
+	}{
+		{"current secret", "new", "old", &inGrace, "verified"},
+		{"previous secret in grace period", "old", "old", &inGrace, "verified with the previous secret"},
+		{"previous secret expired", "old", "old", &expired, ""},
+		{"previous secret without expiry", "old", "old", nil, ""},
+		{"no previous secret", "old", "", &inGrace, ""},
+		{"unknown secret", "other", "old", &inGrace, ""},
+	}
+
+	for _, test := range tests {
+		webhook := &models.Webhook{
+			Secret:                  "new",
+			SignatureScheme:         SignatureGitHub,
+			PreviousSecret:          test.previous,
+			PreviousSecretExpiresAt: test.expiresAt,
+		}
+		verification, err := w.verifySecrets(webhook, signedHeaders(SignatureGitHub, test.signedWith, now), testWebhookBody)
+		if verification != test.verification || (err == nil) != (test.verification != "") {
+			t.Errorf("%s: unexpected verification %q, %v", test.name, verification, err)
+		}
+		if webhook.Secret != "new" {
+			t.Errorf("%s: webhook secret changed to %s", test.name, webhook.Secret)
+		}
+	}
+}